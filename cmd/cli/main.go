@@ -1,47 +1,11 @@
 package main
 
 import (
-	"flag"
-	"time"
+	"os"
 
-	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
-	"github.com/tim-hilt/codescene/internal"
-	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/cli"
 )
 
-func parseFlags() ([]string, bool) {
-	force := flag.Bool("f", false, "force re-analyzing of repo")
-	flag.Parse()
-	repos := flag.Args()
-	return repos, *force
-}
-
-func commitCompletedCallback(curr, total int) {
-	log.Info().Int("current", curr).Int("total", total).Msg("Processed commits")
-}
-
 func main() {
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	repos, force := parseFlags()
-	if len(repos) == 0 {
-		log.Fatal().Msg("No repository specified")
-		return
-	}
-
-	db, err := database.Init()
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize database")
-		return
-	}
-	defer db.Close()
-
-	for _, repo := range repos {
-		start := time.Now()
-		if err := internal.Analyze(db, repo, force, commitCompletedCallback); err != nil {
-			log.Err(err).Msg("Failed to analyze")
-			return
-		}
-		log.Info().Dur("duration", time.Since(start)).Str("repo", repo).Msg("Analysis completed")
-	}
+	os.Exit(cli.Run(os.Args[1:]))
 }
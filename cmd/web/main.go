@@ -1,35 +1,12 @@
 package main
 
 import (
-	"fmt"
-	"net/http"
 	"os"
-	"os/signal"
 
-	"github.com/tim-hilt/codescene/internal/database"
-	"github.com/tim-hilt/codescene/internal/server"
+	"github.com/tim-hilt/codescene/internal/cli"
 )
 
+// main serves the web UI and API. It takes the flags of "codescene serve".
 func main() {
-	db, err := database.Init()
-	if err != nil {
-		panic(err)
-	}
-
-	s := &server.Server{DB: db}
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		<-c
-		fmt.Println("Ctrl-C pressed! Exiting...")
-		if err = db.Close(); err != nil {
-			panic(err)
-		}
-		os.Exit(0)
-	}()
-
-	if err := http.ListenAndServe(":8000", s); err != nil {
-		panic(err)
-	}
+	os.Exit(cli.Run(append([]string{"serve"}, os.Args[1:]...)))
 }
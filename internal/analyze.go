@@ -1,43 +1,65 @@
 package internal
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/boyter/scc/v3/processor"
 
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/tim-hilt/codescene/internal/database"
 	"github.com/tim-hilt/codescene/internal/git"
+	"github.com/tim-hilt/codescene/internal/metrics"
 )
 
 var (
 	ErrRepoFormat = errors.New("provide repo in the format <user>/<repo>")
 
-	LargeByteCount = 1000000
-	Concurrency    = runtime.NumCPU()
+	// ErrNestedRepo is returned for repositories in nested groups like
+	// gitlab.com/<group>/<subgroup>/<repo>, as projects are named
+	// <host>/<user>/<repo>.
+	ErrNestedRepo = errors.New("repositories in nested groups aren't supported, provide repo in the format <user>/<repo>")
+
+	LargeByteCount      = 1000000
+	Concurrency         = runtime.NumCPU()
+	ClassificationRules = database.DefaultClassificationRules
 )
 
-func sanitizeRepo(repo string) (string, error) {
+// SanitizeRepo normalizes repo to the <host>/<user>/<repo> form used as project name.
+func SanitizeRepo(repo string) (string, error) {
 	u, err := url.Parse(repo)
 	if err != nil {
 		return "", err
 	}
 
+	u.Path = strings.TrimSuffix(strings.TrimPrefix(u.Path, "/"), "/")
+
 	if u.Host == "" {
-		// Assume github.com, if host not provided
-		u.Host = "github.com"
+		// Accept already sanitized repos in the form <host>/<user>/<repo>
+		if host, path, found := strings.Cut(u.Path, "/"); found && strings.Contains(host, ".") {
+			u.Host = host
+			u.Path = path
+		} else {
+			// Assume github.com, if host not provided
+			u.Host = "github.com"
+		}
 	}
 
-	u.Path = strings.TrimSuffix(strings.TrimPrefix(u.Path, "/"), "/")
-
-	if len(strings.Split(u.Path, "/")) != 2 {
+	switch segments := len(strings.Split(u.Path, "/")); {
+	case segments > 2:
+		return "", ErrNestedRepo
+	case segments != 2:
 		return "", ErrRepoFormat
 	}
 
@@ -46,8 +68,29 @@ func sanitizeRepo(repo string) (string, error) {
 	return repo, nil
 }
 
-func Analyze(db *database.DB, repo string, force bool, filestateProcessedCallback func(curr, total int)) error {
-	repo, err := sanitizeRepo(repo)
+// ParseTime parses dates like 2024-01-31 and RFC 3339 timestamps. An empty
+// value is the zero time.
+func ParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use YYYY-MM-DD or RFC 3339", value)
+	}
+
+	return t, nil
+}
+
+// Analyze imports the commits of repo that aren't in the database yet.
+// Progress is reported to progress, which may be nil.
+func Analyze(ctx context.Context, db *database.DB, repo string, force bool, progress ProgressFunc) error {
+	repo, err := SanitizeRepo(repo)
 	if err != nil {
 		return err
 	}
@@ -64,7 +107,11 @@ func Analyze(db *database.DB, repo string, force bool, filestateProcessedCallbac
 		return err
 	}
 
-	repository, err := git.Clone(repo, newestCommitAt)
+	tracker := newProgressTracker(progress)
+	defer tracker.end()
+
+	tracker.begin(PhaseCloning, 0)
+	repository, err := git.Clone(ctx, repo, newestCommitAt)
 
 	if err == git.ErrNoNewCommits {
 		log.Info().Str("repository", repo).Msg("no new commits")
@@ -76,94 +123,214 @@ func Analyze(db *database.DB, repo string, force bool, filestateProcessedCallbac
 	}
 	defer repository.Close()
 
-	errs := make(chan error)
+	tracker.begin(PhaseLog, 0)
+	commits, err := repository.Log(ctx)
+	if err != nil {
+		return err
+	}
+	tracker.progress(len(commits), len(commits))
 
-	commits, numCommits, filestates, numFilestates, err := repository.Log(errs)
+	tracker.begin(PhaseDiffing, len(commits))
+	changes, err := repository.Diff(ctx, commits, tracker.progress)
 	if err != nil {
 		return err
 	}
 
-	log.Info().Str("repo", repo).Int("commits", numCommits).Msg("Injecting new commits")
+	// Incremental clones contain commits that were analyzed before, to diff
+	// the oldest new commits against their parents
+	commits, changes, err = dropKnownCommits(db, repo, commits, changes)
+	if err != nil {
+		return err
+	}
 
-	go db.PersistCommits(commits, errs)
+	if len(commits) == 0 {
+		log.Info().Str("repository", repo).Msg("no new commits")
+		return nil
+	}
+
+	log.Info().Str("repo", repo).Int("commits", len(commits)).Msg("Injecting new commits")
 
 	processor.ProcessConstants()
 
+	tracker.begin(PhaseCounting, len(changes))
+
+	// The first failing stage cancels the others
+	g, gctx := errgroup.WithContext(ctx)
+
+	input := make(chan database.FileState, Concurrency)
+	output := make(chan database.FileState)
 	removedFiles := make(map[string][]string)
-	output := processFilestates(repository, filestates, &removedFiles, errs)
-	go db.PersistFileStates(output, numFilestates, filestateProcessedCallback, errs)
+	filestates := make([]database.FileState, 0, len(changes))
 
-	for err := range errs {
-		if err != nil {
-			return err
+	g.Go(func() error {
+		defer close(input)
+
+		for _, filestate := range changes {
+			select {
+			case input <- filestate:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
 		}
+
+		return nil
+	})
+	g.Go(func() error {
+		var processed int
+		return processFilestates(gctx, repository, input, output, removedFiles, func() {
+			processed++
+			tracker.advance(processed)
+			metrics.FilestatesProcessed.Inc()
+		})
+	})
+	g.Go(func() error {
+		for filestate := range output {
+			filestates = append(filestates, filestate)
+		}
+
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		return err
 	}
 
-	if err := db.Flush(); err != nil {
+	// Later analyses skip commits older than the newest stored one, so a run
+	// that fails or is canceled must not leave some of its commits behind
+	lastID, err := db.LastCommitID(repo)
+	if err != nil {
 		return err
 	}
 
-	if err := db.FillFilestates(repo, removedFiles); err != nil {
+	if err := persist(ctx, db, repo, commits, filestates, removedFiles, tracker); err != nil {
+		if cleanErr := db.DeleteCommitsAfter(repo, lastID); cleanErr != nil {
+			return errors.Join(err, fmt.Errorf("removing commits of failed analysis: %w", cleanErr))
+		}
 		return err
 	}
 
 	return nil
 }
 
-func processFilestates(repository git.Repository, input chan database.FileState, removedFiles *map[string][]string, errs chan error) chan database.FileState {
-	output := make(chan database.FileState)
+// persist stores commits and filestates, carries the untouched files over to
+// the new commits and classifies them.
+func persist(ctx context.Context, db *database.DB, repo string, commits []database.Commit, filestates []database.FileState, removedFiles map[string][]string, tracker *progressTracker) error {
+	// Commits are persisted first, as filestates reference them
+	tracker.begin(PhasePersisting, len(commits)+len(filestates))
+	if err := db.PersistCommits(ctx, commits); err != nil {
+		return err
+	}
+	tracker.advance(len(commits))
+
+	if err := db.PersistFileStates(ctx, filestates, func(curr, _ int) {
+		tracker.advance(len(commits) + curr)
+	}); err != nil {
+		return err
+	}
+
+	if err := db.Flush(); err != nil {
+		return err
+	}
+
+	tracker.begin(PhaseFilling, 0)
+	if err := db.FillFilestates(ctx, repo, commits, removedFiles, tracker.progress); err != nil {
+		return err
+	}
+
+	if err := db.ClassifyCommits(repo, ClassificationRules); err != nil {
+		return err
+	}
+
+	return db.ExtractIssues(repo)
+}
+
+func dropKnownCommits(db *database.DB, repo string, commits []database.Commit, changes []database.FileState) ([]database.Commit, []database.FileState, error) {
+	hashes, err := db.GetCommitHashes(repo)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	go func() {
-		defer close(errs)
-		defer close(output)
-		var (
-			wg  sync.WaitGroup
-			mut sync.Mutex
-		)
-
-		for i := 0; i < Concurrency; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-
-				for filestate := range input {
-					filestate.Location = filepath.Join(repository.Path, filestate.Filename)
-					content, err := repository.Show(filestate.CommitHash, filestate.Filename)
-
-					if err == os.ErrNotExist {
-						mut.Lock()
-						if _, exists := (*removedFiles)[filestate.CommitHash]; !exists {
-							(*removedFiles)[filestate.CommitHash] = []string{}
-						}
-						(*removedFiles)[filestate.CommitHash] = append((*removedFiles)[filestate.CommitHash], filestate.Filename)
-						mut.Unlock()
-						continue
-					}
-
-					if err != nil {
-						errs <- err
-						return
-					}
-
-					newFileJob(content, &filestate)
-					if filestate.FileJob == nil {
-						continue
-					}
-
-					if err := processFile(&filestate); err != nil && err.Error() != "Missing #!" {
-						errs <- err
-						return
-					}
-
-					output <- filestate
+	known := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		known[hash] = true
+	}
+
+	n := len(commits)
+	commits = slices.DeleteFunc(commits, func(c database.Commit) bool {
+		return known[c.Hash]
+	})
+	metrics.CacheHits.WithLabelValues("commit").Add(float64(n - len(commits)))
+	changes = slices.DeleteFunc(changes, func(fs database.FileState) bool {
+		return known[fs.CommitHash]
+	})
+
+	return commits, changes, nil
+}
+
+// processFilestates counts the content of the filestates received from input
+// on Concurrency workers and sends the results to output. Files that don't
+// exist at their commit are collected in removedFiles. processed is called
+// after each filestate, one call at a time. output is closed once all workers
+// returned.
+func processFilestates(ctx context.Context, repository git.Repository, input <-chan database.FileState, output chan<- database.FileState, removedFiles map[string][]string, processed func()) error {
+	defer close(output)
+
+	g, ctx := errgroup.WithContext(ctx)
+	var mut sync.Mutex
+
+	for range Concurrency {
+		g.Go(func() error {
+			for filestate := range input {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				filestate.Location = filepath.Join(repository.Path, filestate.Filename)
+				content, err := repository.Show(ctx, filestate.CommitHash, filestate.Filename)
+
+				if err == os.ErrNotExist {
+					mut.Lock()
+					removedFiles[filestate.CommitHash] = append(removedFiles[filestate.CommitHash], filestate.Filename)
+					processed()
+					mut.Unlock()
+					continue
+				}
+
+				if err != nil {
+					return err
 				}
-			}()
-		}
 
-		wg.Wait()
-	}()
+				newFileJob(content, &filestate)
+				if filestate.FileJob == nil {
+					mut.Lock()
+					processed()
+					mut.Unlock()
+					continue
+				}
+
+				if err := processFile(&filestate); err != nil && err.Error() != "Missing #!" {
+					return err
+				}
+
+				// The content isn't needed anymore once it's counted
+				filestate.Content = nil
+
+				mut.Lock()
+				processed()
+				mut.Unlock()
+
+				select {
+				case output <- filestate:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			return nil
+		})
+	}
 
-	return output
+	return g.Wait()
 }
 
 func newFileJob(content []byte, filestate *database.FileState) {
@@ -0,0 +1,314 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/metrics"
+)
+
+const testProject = "github.com/o/p"
+
+// testRepo is a git repository served in place of https://github.com/o/p.
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	date time.Time
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+
+	root := t.TempDir()
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "url.file://"+root+"/.insteadOf")
+	t.Setenv("GIT_CONFIG_VALUE_0", "https://github.com/")
+
+	r := &testRepo{t, filepath.Join(root, "o", "p"), time.Now().AddDate(0, -1, 0).Truncate(time.Second)}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r.git("init", "--quiet", "--initial-branch=main")
+
+	return r
+}
+
+func (r *testRepo) git(args ...string) string {
+	r.t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_DATE="+r.date.Format(time.RFC3339), "GIT_COMMITTER_DATE="+r.date.Format(time.RFC3339),
+		"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %v: %v: %s", args, err, output)
+	}
+
+	return string(output)
+}
+
+// commit writes files, removes those with empty content and commits them as
+// author, a day after the previous commit.
+func (r *testRepo) commit(author, message string, files map[string]string) {
+	r.t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(r.dir, name)
+		if content == "" {
+			r.git("rm", "--quiet", name)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+		r.git("add", name)
+	}
+
+	r.date = r.date.Add(24 * time.Hour)
+	r.git("commit", "--quiet", "--author="+author+" <"+author+"@example.com>", "-m", message)
+}
+
+func goFile(complexity int) string {
+	body := ""
+	for i := range complexity {
+		body += fmt.Sprintf("\tif x == %d {\n\t\treturn %d\n\t}\n", i, i)
+	}
+	return "package main\n\nfunc f(x int) int {\n" + body + "\treturn 0\n}\n"
+}
+
+func openTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	db, err := database.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func count(t *testing.T, db *database.DB, query string, args ...any) int {
+	t.Helper()
+
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAnalyzeCanceledRunLeavesNoCommits(t *testing.T) {
+	for _, phase := range []Phase{PhasePersisting, PhaseFilling} {
+		t.Run(string(phase), func(t *testing.T) {
+			repo := newTestRepo(t)
+			db := openTestDB(t)
+
+			repo.commit("alice", "Add main", map[string]string{"main.go": goFile(1), "README.md": "# p\n"})
+			repo.commit("bob", "Add util", map[string]string{"util/util.go": goFile(2)})
+			if err := Analyze(context.Background(), db, testProject, false, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			repo.commit("alice", "Grow main", map[string]string{"main.go": goFile(3)})
+			repo.commit("bob", "Remove readme", map[string]string{"README.md": ""})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := Analyze(ctx, db, testProject, false, func(p Progress) {
+				if p.Phase == phase {
+					cancel()
+				}
+			})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v, want %v", err, context.Canceled)
+			}
+
+			if n := count(t, db, "SELECT COUNT(*) FROM commits WHERE project = ?", testProject); n != 2 {
+				t.Errorf("got %d commits after canceled run, want 2", n)
+			}
+			if n := count(t, db, "SELECT COUNT(*) FROM filestates"); n != 5 {
+				t.Errorf("got %d filestates after canceled run, want 5", n)
+			}
+
+			// The next run analyzes the commits of the canceled one
+			if err := Analyze(context.Background(), db, testProject, false, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			hashes, err := db.GetCommitHashes(testProject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hashes) != 4 {
+				t.Fatalf("got %d commits, want 4", len(hashes))
+			}
+
+			for i, want := range []int{2, 3, 3, 2} {
+				if n := count(t, db, "SELECT COUNT(*) FROM filestates WHERE commit_hash = ?", hashes[i]); n != want {
+					t.Errorf("got %d filestates for commit %d, want %d", n, i, want)
+				}
+			}
+
+			before := count(t, db, "SELECT complexity FROM filestates WHERE commit_hash = ? AND path = 'main.go'", hashes[1])
+			after := count(t, db, "SELECT complexity FROM filestates WHERE commit_hash = ? AND path = 'main.go'", hashes[3])
+			if after <= before {
+				t.Errorf("got complexity %d of main.go at the last commit, want more than %d", after, before)
+			}
+		})
+	}
+}
+
+func TestAnalyzeCanceledFirstRunLeavesNoRows(t *testing.T) {
+	for _, phase := range []Phase{PhaseDiffing, PhaseCounting, PhasePersisting, PhaseFilling} {
+		t.Run(string(phase), func(t *testing.T) {
+			repo := newTestRepo(t)
+			db := openTestDB(t)
+
+			repo.commit("alice", "Add main", map[string]string{"main.go": goFile(1)})
+			repo.commit("bob", "Add util", map[string]string{"util/util.go": goFile(2)})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := Analyze(ctx, db, testProject, false, func(p Progress) {
+				if p.Phase == phase {
+					cancel()
+				}
+			})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v, want %v", err, context.Canceled)
+			}
+
+			for _, table := range []string{"commits", "filestates", "commit_issues"} {
+				if n := count(t, db, "SELECT COUNT(*) FROM "+table); n != 0 {
+					t.Errorf("got %d rows in %s, want 0", n, table)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeMetrics(t *testing.T) {
+	repo := newTestRepo(t)
+	db := openTestDB(t)
+
+	repo.commit("alice", "Add main", map[string]string{"main.go": goFile(1), "README.md": "# p\n"})
+	repo.commit("bob", "Add util", map[string]string{"util/util.go": goFile(2)})
+	repo.commit("alice", "Grow main", map[string]string{"main.go": goFile(3)})
+
+	counters := map[string]prometheus.Counter{
+		"clone":      metrics.GitCommands.WithLabelValues("clone", "success"),
+		"log":        metrics.GitCommands.WithLabelValues("log", "success"),
+		"diff":       metrics.GitCommands.WithLabelValues("diff", "success"),
+		"show":       metrics.GitCommands.WithLabelValues("show", "success"),
+		"filestates": metrics.FilestatesProcessed,
+		// The files a commit didn't touch are carried over
+		"carried over": metrics.CacheHits.WithLabelValues("filestate"),
+	}
+	before := make(map[string]float64)
+	for name, counter := range counters {
+		before[name] = testutil.ToFloat64(counter)
+	}
+	phases := []Phase{PhaseCloning, PhaseLog, PhaseDiffing, PhaseCounting, PhasePersisting, PhaseFilling}
+	for _, phase := range phases {
+		before[string(phase)] = observations(t, phase)
+	}
+
+	if err := Analyze(context.Background(), db, testProject, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]float64{
+		"clone":        1,
+		"log":          1,
+		"diff":         3,
+		"show":         4,
+		"filestates":   4,
+		"carried over": 4,
+	} {
+		if got := testutil.ToFloat64(counters[name]) - before[name]; got != want {
+			t.Errorf("got %v more %s, want %v", got, name, want)
+		}
+	}
+
+	for _, phase := range phases {
+		if got := observations(t, phase) - before[string(phase)]; got != 1 {
+			t.Errorf("got %v more durations of phase %s, want 1", got, phase)
+		}
+	}
+}
+
+// observations returns how often the duration of phase was observed.
+func observations(t *testing.T, phase Phase) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := metrics.AnalysisPhaseDuration.WithLabelValues(string(phase)).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return float64(m.GetHistogram().GetSampleCount())
+}
+
+func TestSanitizeRepo(t *testing.T) {
+	tests := []struct {
+		repo string
+		want string
+		err  error
+	}{
+		{"o/p", "github.com/o/p", nil},
+		{"github.com/o/p", "github.com/o/p", nil},
+		{"https://github.com/o/p", "github.com/o/p", nil},
+		{"https://gitlab.com/o/p/", "gitlab.com/o/p", nil},
+		{"p", "", ErrRepoFormat},
+		{"https://gitlab.com/o", "", ErrRepoFormat},
+		{"gitlab.com/group/sub/p", "", ErrNestedRepo},
+		{"https://gitlab.com/group/sub/p", "", ErrNestedRepo},
+	}
+
+	for _, test := range tests {
+		got, err := SanitizeRepo(test.repo)
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("SanitizeRepo(%q) = %q, %v, want %q, %v", test.repo, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		valid bool
+	}{
+		{"", time.Time{}, true},
+		{"2024-01-31", time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), true},
+		{"2024-01-31T12:30:00+02:00", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC), true},
+		{"31.01.2024", time.Time{}, false},
+		{"2024-01-31 12:30", time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		got, err := ParseTime(test.value)
+		if (err == nil) != test.valid || !got.Equal(test.want) {
+			t.Errorf("ParseTime(%q) = %s, %v, want %s, valid %t", test.value, got, err, test.want, test.valid)
+		}
+	}
+}
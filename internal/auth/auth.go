@@ -0,0 +1,214 @@
+// Package auth authenticates requests to the web server and describes what
+// the authenticated principal may do.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator, if the request
+	// doesn't carry credentials it understands.
+	ErrNoCredentials = errors.New("authentication required")
+
+	// ErrInvalidCredentials is returned by an Authenticator, if the request
+	// carries credentials it understands, but they are wrong.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	ErrRole = errors.New("role must be one of viewer, analyst or admin")
+)
+
+// Role is ordered, each role may do everything the roles below it may do.
+type Role int
+
+const (
+	// RoleViewer may read the analyses of the projects visible to it
+	RoleViewer Role = iota + 1
+	// RoleAnalyst may additionally analyze repositories
+	RoleAnalyst
+	// RoleAdmin may additionally delete projects
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleAnalyst:
+		return "analyst"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "viewer":
+		*r = RoleViewer
+	case "analyst":
+		*r = RoleAnalyst
+	case "admin":
+		*r = RoleAdmin
+	default:
+		return fmt.Errorf("%w, got %q", ErrRole, text)
+	}
+
+	return nil
+}
+
+// Principal is an authenticated user or client.
+type Principal struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	// Projects are path.Match patterns of the projects visible to the
+	// principal, e.g. "github.com/acme/*". All projects are visible, if empty.
+	Projects []string `json:"projects"`
+}
+
+// Anonymous is used, if authentication is disabled.
+var Anonymous = Principal{Name: "anonymous", Role: RoleAdmin}
+
+// Allows reports whether the principal has at least role.
+func (p Principal) Allows(role Role) bool {
+	return p.Role >= role
+}
+
+// CanView reports whether project is visible to the principal.
+func (p Principal) CanView(project string) bool {
+	if len(p.Projects) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.Projects {
+		if matched, _ := path.Match(pattern, project); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, or Anonymous.
+func FromContext(ctx context.Context) Principal {
+	if p, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return p
+	}
+
+	return Anonymous
+}
+
+// Authenticator identifies the principal making a request.
+type Authenticator interface {
+	// Authenticate returns ErrNoCredentials, if the request carries no
+	// credentials for this authenticator, and ErrInvalidCredentials, if they
+	// are wrong.
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// Chain tries its authenticators in order until one of them recognizes the
+// credentials of the request.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+
+		return p, err
+	}
+
+	return Principal{}, ErrNoCredentials
+}
+
+// Config is the content of an auth file.
+type Config struct {
+	Tokens []Token     `yaml:"tokens"`
+	Users  []User      `yaml:"users"`
+	OIDC   *OIDCConfig `yaml:"oidc"`
+}
+
+// Load reads a YAML or JSON auth file and returns the authenticators it
+// configures. Static tokens are tried before OIDC bearer tokens.
+func Load(file string) (Chain, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON, so this handles both formats
+	var config Config
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, err
+	}
+
+	var chain Chain
+
+	if len(config.Tokens) > 0 {
+		tokens, err := NewTokens(config.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, tokens)
+	}
+
+	if len(config.Users) > 0 {
+		basic, err := NewBasic(config.Users)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, basic)
+	}
+
+	if config.OIDC != nil {
+		oidc, err := NewOIDC(*config.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, oidc)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%s configures no authentication method", file)
+	}
+
+	return chain, nil
+}
+
+func validate(name string, role Role, projects []string) error {
+	if name == "" {
+		return errors.New("principal has no name")
+	}
+
+	if role == 0 {
+		return fmt.Errorf("%s: %w", name, ErrRole)
+	}
+
+	for _, pattern := range projects {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: invalid project pattern %q: %w", name, pattern, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// ClockSkew is tolerated when checking the validity period of tokens.
+	ClockSkew = time.Minute
+
+	// KeyRefreshInterval is the minimum time between two fetches of the
+	// issuer's keys triggered by tokens signed with unknown keys.
+	KeyRefreshInterval = time.Minute
+)
+
+// OIDCConfig configures the verification of ID or access tokens issued by an
+// OpenID Connect provider.
+type OIDCConfig struct {
+	// Issuer must match the iss claim. Its keys are discovered from
+	// <issuer>/.well-known/openid-configuration.
+	Issuer string `yaml:"issuer"`
+	// Audience must be contained in the aud claim.
+	Audience string `yaml:"audience"`
+	// GroupsClaim names the claim holding the groups of the user, "groups" by
+	// default.
+	GroupsClaim string `yaml:"groupsClaim"`
+	// Groups map the groups of a user to roles. A user gets the highest role
+	// and the projects of all groups they are member of.
+	Groups []OIDCGroup `yaml:"groups"`
+	// DefaultRole is given to users without a mapped group. They can view all
+	// projects. These users are rejected, if empty.
+	DefaultRole Role `yaml:"defaultRole"`
+}
+
+type OIDCGroup struct {
+	Group    string   `yaml:"group"`
+	Role     Role     `yaml:"role"`
+	Projects []string `yaml:"projects"`
+}
+
+// OIDC authenticates requests with bearer tokens signed by an OpenID Connect
+// provider using RS256, RS384, RS512, ES256, ES384 or ES512.
+type OIDC struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	fetch     singleflight.Group
+}
+
+func NewOIDC(config OIDCConfig) (*OIDC, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("oidc: issuer and audience are required")
+	}
+
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	for _, g := range config.Groups {
+		if err := validate(g.Group, g.Role, g.Projects); err != nil {
+			return nil, fmt.Errorf("oidc: %w", err)
+		}
+	}
+
+	return &OIDC{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type claims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"`
+	Expiry            *float64        `json:"exp"`
+	NotBefore         *float64        `json:"nbf"`
+	PreferredUsername string          `json:"preferred_username"`
+	Email             string          `json:"email"`
+}
+
+func (o *OIDC) Authenticate(r *http.Request) (Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return Principal{}, ErrNoCredentials
+	}
+
+	payload, err := o.verify(token)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	if err := o.validate(c); err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	p := Principal{Name: c.Subject}
+	if c.PreferredUsername != "" {
+		p.Name = c.PreferredUsername
+	} else if c.Email != "" {
+		p.Name = c.Email
+	}
+
+	groups, err := groupsClaim(payload, o.config.GroupsClaim)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	allProjects := false
+	for _, g := range o.config.Groups {
+		if !slices.Contains(groups, g.Group) {
+			continue
+		}
+
+		p.Role = max(p.Role, g.Role)
+		if len(g.Projects) == 0 {
+			allProjects = true
+		}
+		p.Projects = append(p.Projects, g.Projects...)
+	}
+
+	if allProjects {
+		p.Projects = nil
+	}
+
+	if p.Role == 0 {
+		if o.config.DefaultRole == 0 {
+			return Principal{}, fmt.Errorf("%w: %s has no role", ErrInvalidCredentials, p.Name)
+		}
+		p.Role = o.config.DefaultRole
+	}
+
+	return p, nil
+}
+
+func (o *OIDC) validate(c claims) error {
+	if c.Issuer != o.config.Issuer {
+		return fmt.Errorf("unexpected issuer %q", c.Issuer)
+	}
+
+	var audiences []string
+	if err := json.Unmarshal(c.Audience, &audiences); err != nil {
+		var audience string
+		if err := json.Unmarshal(c.Audience, &audience); err != nil {
+			return errors.New("invalid audience")
+		}
+		audiences = []string{audience}
+	}
+
+	if !slices.Contains(audiences, o.config.Audience) {
+		return errors.New("token not issued for this audience")
+	}
+
+	now := time.Now()
+	if c.Expiry == nil || now.Add(-ClockSkew).After(unixTime(*c.Expiry)) {
+		return errors.New("token expired")
+	}
+
+	if c.NotBefore != nil && now.Add(ClockSkew).Before(unixTime(*c.NotBefore)) {
+		return errors.New("token not valid yet")
+	}
+
+	return nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// groupsClaim returns the claim called name, which may be a single string or
+// a list of strings.
+func groupsClaim(payload []byte, name string) ([]string, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &all); err != nil {
+		return nil, err
+	}
+
+	raw, exists := all[name]
+	if !exists {
+		return nil, nil
+	}
+
+	var groups []string
+	if err := json.Unmarshal(raw, &groups); err == nil {
+		return groups, nil
+	}
+
+	var group string
+	if err := json.Unmarshal(raw, &group); err != nil {
+		return nil, fmt.Errorf("claim %s must be a string or a list of strings", name)
+	}
+
+	return []string{group}, nil
+}
+
+// verify checks the signature of the JWT and returns its payload.
+func (o *OIDC) verify(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := o.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	return base64.RawURLEncoding.DecodeString(parts[1])
+}
+
+// algorithm is a supported signature algorithm. ECDSA algorithms require
+// keys on a specific curve.
+type algorithm struct {
+	hash  crypto.Hash
+	rsa   bool
+	curve string
+}
+
+var algorithms = map[string]algorithm{
+	"RS256": {hash: crypto.SHA256, rsa: true},
+	"RS384": {hash: crypto.SHA384, rsa: true},
+	"RS512": {hash: crypto.SHA512, rsa: true},
+	"ES256": {hash: crypto.SHA256, curve: "P-256"},
+	"ES384": {hash: crypto.SHA384, curve: "P-384"},
+	"ES512": {hash: crypto.SHA512, curve: "P-521"},
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	a, supported := algorithms[alg]
+	if !supported {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	h := a.hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !a.rsa {
+			return fmt.Errorf("algorithm %q doesn't match RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, a.hash, digest, signature)
+	case *ecdsa.PublicKey:
+		if k.Curve.Params().Name != a.curve {
+			return fmt.Errorf("algorithm %q doesn't match EC key on curve %s", alg, k.Curve.Params().Name)
+		}
+
+		// Signatures are the concatenation of r and s
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return errors.New("unsupported key type")
+	}
+}
+
+// key returns the issuer's key with id kid, fetching the keys again, if kid
+// is unknown. Concurrent requests share a fetch, which doesn't hold o.mu, so
+// requests with known keys don't wait for the issuer.
+func (o *OIDC) key(kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	key, exists := o.keys[kid]
+	fetchedRecently := time.Since(o.fetchedAt) < KeyRefreshInterval
+	o.mu.Unlock()
+
+	if exists {
+		return key, nil
+	}
+
+	if fetchedRecently {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	_, err, _ := o.fetch.Do("keys", func() (any, error) {
+		keys, err := o.fetchKeys()
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		o.fetchedAt = time.Now()
+		if err != nil {
+			return nil, err
+		}
+		o.keys = keys
+
+		return nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching keys: %w", err)
+	}
+
+	o.mu.Lock()
+	key, exists = o.keys[kid]
+	o.mu.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	return key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (o *OIDC) fetchKeys() (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := o.getJSON(strings.TrimSuffix(o.config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip keys of unsupported types
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (o *OIDC) getJSON(url string, v any) error {
+	resp, err := o.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point not on curve")
+		}
+
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
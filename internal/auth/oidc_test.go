@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testAudience = "codescene"
+
+// testIssuer is a stub OpenID Connect provider serving the public keys of
+// keys by their id.
+type testIssuer struct {
+	*httptest.Server
+	keys map[string]crypto.Signer
+
+	// fetches counts the requests for the keys. While block is set, they
+	// wait until it is closed.
+	fetches atomic.Int32
+	mu      sync.Mutex
+	block   chan struct{}
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := &testIssuer{keys: map[string]crypto.Signer{"rsa": rsaKey, "p256": p256, "p384": p384}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": i.URL, "jwks_uri": i.URL + "/keys"})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, _ *http.Request) {
+		i.fetches.Add(1)
+
+		i.mu.Lock()
+		block := i.block
+		i.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+
+		var keys []map[string]string
+		for kid, key := range i.keys {
+			keys = append(keys, publicJWK(kid, key.Public()))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+
+	return i
+}
+
+func publicJWK(kid string, key crypto.PublicKey) map[string]string {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": encode(k.N.Bytes()), "e": encode(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return map[string]string{"kty": "EC", "kid": kid, "crv": k.Curve.Params().Name, "x": encode(k.X.FillBytes(make([]byte, size))), "y": encode(k.Y.FillBytes(make([]byte, size)))}
+	default:
+		panic("unsupported key type")
+	}
+}
+
+func (i *testIssuer) oidc(t *testing.T) *OIDC {
+	t.Helper()
+
+	o, err := NewOIDC(OIDCConfig{
+		Issuer:   i.URL,
+		Audience: testAudience,
+		Groups: []OIDCGroup{
+			{Group: "admins", Role: RoleAdmin},
+			{Group: "acme", Role: RoleViewer, Projects: []string{"github.com/acme/*"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return o
+}
+
+// claims returns valid claims of a token issued by i.
+func (i *testIssuer) claims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":                i.URL,
+		"sub":                "1234",
+		"aud":                []string{"other", testAudience},
+		"exp":                now.Add(time.Hour).Unix(),
+		"nbf":                now.Add(-time.Minute).Unix(),
+		"preferred_username": "alice",
+		"groups":             []string{"acme"},
+	}
+}
+
+// sign returns a token with the header alg and kid, signed with the key kid
+// of i using the hash of alg.
+func (i *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	return signToken(t, alg, kid, claims, func(signed []byte) []byte {
+		hash := hashes[alg[2:]]
+		h := hash.New()
+		h.Write(signed)
+		digest := h.Sum(nil)
+
+		switch key := i.keys[kid].(type) {
+		case *rsa.PrivateKey:
+			signature, err := rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		case *ecdsa.PrivateKey:
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := (key.Curve.Params().BitSize + 7) / 8
+			return append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		default:
+			t.Fatalf("unknown key %q", kid)
+			return nil
+		}
+	})
+}
+
+func signToken(t *testing.T, alg, kid string, claims map[string]any, sign func(signed []byte) []byte) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func authenticateToken(o *OIDC, token string) (Principal, error) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return o.Authenticate(r)
+}
+
+func TestOIDCAccepts(t *testing.T) {
+	i := newTestIssuer(t)
+	o := i.oidc(t)
+
+	for _, test := range []struct{ alg, kid string }{{"RS256", "rsa"}, {"RS512", "rsa"}, {"ES256", "p256"}, {"ES384", "p384"}} {
+		p, err := authenticateToken(o, i.sign(t, test.alg, test.kid, i.claims()))
+		if err != nil {
+			t.Errorf("%s with key %s: %v", test.alg, test.kid, err)
+			continue
+		}
+		if p.Name != "alice" || p.Role != RoleViewer || len(p.Projects) != 1 || p.Projects[0] != "github.com/acme/*" {
+			t.Errorf("%s with key %s: got principal %+v", test.alg, test.kid, p)
+		}
+	}
+
+	// A single audience may be given as string
+	claims := i.claims()
+	claims["aud"] = testAudience
+	claims["groups"] = "admins"
+	p, err := authenticateToken(o, i.sign(t, "RS256", "rsa", claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Role != RoleAdmin || p.Projects != nil {
+		t.Errorf("got principal %+v, want admin of all projects", p)
+	}
+}
+
+func TestOIDCRejects(t *testing.T) {
+	i := newTestIssuer(t)
+
+	rsaKey := i.keys["rsa"].Public().(*rsa.PublicKey)
+	publicKey, err := x509.MarshalPKIXPublicKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(name string, value any) map[string]any {
+		claims := i.claims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	now := time.Now()
+
+	// Signed with a known key, but under an id the issuer doesn't publish
+	unknownKey := signToken(t, "RS256", "unknown", i.claims(), func(signed []byte) []byte {
+		h := crypto.SHA256.New()
+		h.Write(signed)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, i.keys["rsa"].(*rsa.PrivateKey), crypto.SHA256, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	})
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", i.sign(t, "RS256", "rsa", with("exp", now.Add(-ClockSkew-time.Minute).Unix()))},
+		{"without expiry", i.sign(t, "RS256", "rsa", with("exp", nil))},
+		{"not yet valid", i.sign(t, "RS256", "rsa", with("nbf", now.Add(ClockSkew+time.Minute).Unix()))},
+		{"wrong issuer", i.sign(t, "RS256", "rsa", with("iss", "https://evil.example"))},
+		{"wrong audience", i.sign(t, "RS256", "rsa", with("aud", []string{"other"}))},
+		{"without audience", i.sign(t, "RS256", "rsa", with("aud", nil))},
+		{"without role", i.sign(t, "RS256", "rsa", with("groups", []string{"unknown"}))},
+		{"alg none", signToken(t, "none", "rsa", i.claims(), func([]byte) []byte { return nil })},
+		{"HS256 with public key", signToken(t, "HS256", "rsa", i.claims(), func(signed []byte) []byte {
+			mac := hmac.New(crypto.SHA256.New, publicKey)
+			mac.Write(signed)
+			return mac.Sum(nil)
+		})},
+		{"ES256 with RSA key", i.sign(t, "ES256", "rsa", i.claims())},
+		{"RS256 with EC key", signToken(t, "RS256", "p256", i.claims(), func([]byte) []byte { return make([]byte, 256) })},
+		{"ES256 with P-384 key", i.sign(t, "ES256", "p384", i.claims())},
+		{"ES384 with P-256 key", i.sign(t, "ES384", "p256", i.claims())},
+		{"unknown key", unknownKey},
+		{"tampered payload", tamper(t, i.sign(t, "ES256", "p256", i.claims()))},
+		{"malformed", "a.b"},
+	}
+
+	o := i.oidc(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := authenticateToken(o, test.token)
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidCredentials)
+			}
+		})
+	}
+}
+
+// tamper replaces the payload of token with one granting the admin role.
+func tamper(t *testing.T, token string) string {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	claims["groups"] = []string{"admins"}
+
+	payload, err = json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
+
+func TestOIDCUnknownKeyRefetchesOncePerInterval(t *testing.T) {
+	i := newTestIssuer(t)
+	o := i.oidc(t)
+
+	if _, err := authenticateToken(o, i.sign(t, "RS256", "rsa", i.claims())); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rotated key is fetched
+	rotated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.keys["rotated"] = rotated
+	KeyRefreshInterval = 0
+	t.Cleanup(func() { KeyRefreshInterval = time.Minute })
+	if _, err := authenticateToken(o, i.sign(t, "ES256", "rotated", i.claims())); err != nil {
+		t.Fatal(err)
+	}
+	if n := i.fetches.Load(); n != 2 {
+		t.Fatalf("got %d fetches, want 2", n)
+	}
+
+	// Unknown keys don't make each request fetch the keys again
+	KeyRefreshInterval = time.Hour
+	for range 3 {
+		if _, err := authenticateToken(o, signToken(t, "RS256", "unknown", i.claims(), func([]byte) []byte { return nil })); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("got error %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+	if n := i.fetches.Load(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+}
+
+func TestOIDCFetchDoesNotBlockKnownKeys(t *testing.T) {
+	i := newTestIssuer(t)
+	o := i.oidc(t)
+
+	valid := i.sign(t, "RS256", "rsa", i.claims())
+	if _, err := authenticateToken(o, valid); err != nil {
+		t.Fatal(err)
+	}
+
+	KeyRefreshInterval = 0
+	t.Cleanup(func() { KeyRefreshInterval = time.Minute })
+
+	block := make(chan struct{})
+	i.mu.Lock()
+	i.block = block
+	i.mu.Unlock()
+
+	unknown := signToken(t, "RS256", "unknown", i.claims(), func([]byte) []byte { return nil })
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			authenticateToken(o, unknown)
+		}()
+	}
+
+	for i.fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := authenticateToken(o, valid)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("request with known key waited for the key fetch")
+	}
+
+	close(block)
+	wg.Wait()
+}
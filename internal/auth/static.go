@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Token is a static API token sent as "Authorization: Bearer <token>".
+type Token struct {
+	Name     string   `yaml:"name"`
+	Token    string   `yaml:"token"`
+	Role     Role     `yaml:"role"`
+	Projects []string `yaml:"projects"`
+}
+
+// Tokens authenticates requests with static API tokens. Bearer tokens it
+// doesn't know are left to the next authenticator.
+type Tokens []Token
+
+func NewTokens(tokens []Token) (Tokens, error) {
+	for _, t := range tokens {
+		if err := validate(t.Name, t.Role, t.Projects); err != nil {
+			return nil, err
+		}
+
+		if len(t.Token) < 16 {
+			return nil, fmt.Errorf("%s: tokens must have at least 16 characters", t.Name)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (ts Tokens) Authenticate(r *http.Request) (Principal, error) {
+	bearer, ok := bearerToken(r)
+	if !ok {
+		return Principal{}, ErrNoCredentials
+	}
+
+	for _, t := range ts {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(t.Token)) == 1 {
+			return Principal{Name: t.Name, Role: t.Role, Projects: t.Projects}, nil
+		}
+	}
+
+	return Principal{}, ErrNoCredentials
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+
+	return token, true
+}
+
+// User authenticates with HTTP basic authentication. Password is a bcrypt
+// hash, as created by "htpasswd -nB".
+type User struct {
+	Name     string   `yaml:"name"`
+	Password string   `yaml:"password"`
+	Role     Role     `yaml:"role"`
+	Projects []string `yaml:"projects"`
+}
+
+// Basic authenticates requests with HTTP basic authentication.
+type Basic map[string]User
+
+// dummyHash returns the hash compared against for unknown users, so response
+// times don't reveal which users exist. It is computed on first use, so
+// processes without basic authentication don't pay for it.
+var dummyHash = sync.OnceValues(func() ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+})
+
+func NewBasic(users []User) (Basic, error) {
+	b := make(Basic, len(users))
+
+	for _, u := range users {
+		if err := validate(u.Name, u.Role, u.Projects); err != nil {
+			return nil, err
+		}
+
+		if _, err := bcrypt.Cost([]byte(u.Password)); err != nil {
+			return nil, fmt.Errorf("%s: password must be a bcrypt hash: %w", u.Name, err)
+		}
+
+		if _, exists := b[u.Name]; exists {
+			return nil, fmt.Errorf("user %s is defined twice", u.Name)
+		}
+		b[u.Name] = u
+	}
+
+	return b, nil
+}
+
+func (b Basic) Authenticate(r *http.Request) (Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return Principal{}, ErrNoCredentials
+	}
+
+	u, exists := b[name]
+	hash := []byte(u.Password)
+	if !exists {
+		var err error
+		if hash, err = dummyHash(); err != nil {
+			return Principal{}, fmt.Errorf("hashing dummy password: %w", err)
+		}
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !exists {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	return Principal{Name: u.Name, Role: u.Role, Projects: u.Projects}, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBasic([]User{{Name: "alice", Password: string(hash), Role: RoleViewer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, user, password string
+		err                  error
+	}{
+		{"valid", "alice", "secret", nil},
+		{"wrong password", "alice", "guess", ErrInvalidCredentials},
+		{"unknown user", "bob", "secret", ErrInvalidCredentials},
+		{"unknown user with dummy password", "bob", "dummy", ErrInvalidCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.SetBasicAuth(test.user, test.password)
+
+			p, err := b.Authenticate(r)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && (p.Name != "alice" || p.Role != RoleViewer) {
+				t.Errorf("got principal %+v, want alice", p)
+			}
+		})
+	}
+
+	if _, err := b.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("got error %v without credentials, want %v", err, ErrNoCredentials)
+	}
+}
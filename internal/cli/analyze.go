@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func analyzeCommand(args []string) error {
+	c := newConfig("analyze", "<repo>...")
+	c.analysisFlags()
+	force := c.flags.Bool("f", false, "force re-analyzing of repo")
+	issuesFile := c.flags.String("issues", "", "CSV or JSON file with issue metadata (key, type, estimate)")
+	componentsFile := c.flags.String("components", "", "YAML or JSON file mapping path globs to architectural components")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	repos := c.flags.Args()
+	if len(repos) == 0 {
+		return usageError{errors.New("no repository specified")}
+	}
+
+	var issues []database.Issue
+	if *issuesFile != "" {
+		var err error
+		issues, err = database.LoadIssues(*issuesFile)
+		if err != nil {
+			return fmt.Errorf("loading issues: %w", err)
+		}
+	}
+
+	var components []database.Component
+	if *componentsFile != "" {
+		var err error
+		components, err = database.LoadComponents(*componentsFile)
+		if err != nil {
+			return fmt.Errorf("loading components: %w", err)
+		}
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, repo := range repos {
+		start := time.Now()
+		bar := newProgressBar()
+		err := internal.Analyze(ctx, db, repo, *force, bar.report)
+		bar.finish()
+		if err != nil {
+			return fmt.Errorf("analyzing %s: %w", repo, err)
+		}
+		log.Info().Dur("duration", time.Since(start)).Str("repo", repo).Msg("Analysis completed")
+
+		project, err := internal.SanitizeRepo(repo)
+		if err != nil {
+			return err
+		}
+
+		if len(issues) > 0 {
+			if err := db.ImportIssues(project, issues); err != nil {
+				return fmt.Errorf("importing issues: %w", err)
+			}
+		}
+
+		if len(components) > 0 {
+			if err := db.SetComponents(project, components); err != nil {
+				return fmt.Errorf("setting components: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+const progressBarWidth = 30
+
+// progressBar renders the progress of an analysis on stderr, if it is a
+// terminal, and logs the start of each phase otherwise.
+type progressBar struct {
+	terminal   bool
+	phase      internal.Phase
+	lastRender time.Time
+}
+
+func newProgressBar() *progressBar {
+	info, err := os.Stderr.Stat()
+	return &progressBar{terminal: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
+
+func (b *progressBar) report(p internal.Progress) {
+	if !b.terminal {
+		if p.Phase != b.phase {
+			b.phase = p.Phase
+			log.Info().Str("phase", string(p.Phase)).Int("total", p.Total).Msg("Analysis phase started")
+		}
+		return
+	}
+
+	if p.Phase != b.phase {
+		b.finish()
+		b.phase = p.Phase
+	} else if p.Current < p.Total && time.Since(b.lastRender) < 100*time.Millisecond {
+		return
+	}
+	b.lastRender = time.Now()
+
+	if p.Total == 0 {
+		fmt.Fprintf(os.Stderr, "\r%-10s %d", p.Phase, p.Current)
+		return
+	}
+
+	done := progressBarWidth * p.Current / p.Total
+	fmt.Fprintf(
+		os.Stderr,
+		"\r%-10s [%s%s] %d/%d %.0f/s ETA %s   ",
+		p.Phase,
+		strings.Repeat("=", done),
+		strings.Repeat(" ", progressBarWidth-done),
+		p.Current,
+		p.Total,
+		p.Throughput,
+		time.Duration(p.ETA*float64(time.Second)).Round(time.Second),
+	)
+}
+
+// finish ends the line of the current phase.
+func (b *progressBar) finish() {
+	if b.terminal && b.phase != "" {
+		fmt.Fprintln(os.Stderr)
+	}
+}
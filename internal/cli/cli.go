@@ -0,0 +1,107 @@
+// Package cli implements the subcommands of the codescene command.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"analyze", "analyze repositories and store the results", analyzeCommand},
+		{"projects", "list the analyzed projects", projectsCommand},
+		{"delete", "delete the analysis data of projects", deleteCommand},
+		{"hotspots", "list the hotspots of a project", hotspotsCommand},
+		{"coupling", "list files, components or teams that change together", couplingCommand},
+		{"authors", "list the contributors of a project", authorsCommand},
+		{"history", "list the commits of a project", historyCommand},
+		{"report", "summarize the code health of a project", reportCommand},
+		{"serve", "serve the web UI and API", serveCommand},
+		{"query", "run a read-only SQL query", queryCommand},
+		{"export", "export the data of a project to Parquet, CSV or JSON Lines", exportCommand},
+		{"import", "import data exported to Parquet or JSON Lines", importCommand},
+		{"findings", "list hotspots, highly complex files and complexity regressions", findingsCommand},
+		{"gate", "check the changes of a range against code health thresholds", gateCommand},
+		{"review", "score the risk of the changes of a range", reviewCommand},
+	}
+}
+
+// exitError makes Run exit with code, without logging an error.
+type exitError struct {
+	code int
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit code %d", e.code)
+}
+
+// Run runs the subcommand named by args[0] and returns the exit code.
+func Run(args []string) int {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+
+		err := c.run(args[1:])
+
+		var exit exitError
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.As(err, &exit):
+			return exit.code
+		case errors.As(err, new(usageError)):
+			fmt.Fprintf(os.Stderr, "codescene %s: %v\n", c.name, err)
+			return 2
+		default:
+			log.Err(err).Str("command", c.name).Msg("Command failed")
+			return 1
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "codescene: unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: codescene <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "codescene <command> -h" for the flags of a command.`)
+}
+
+// usageError reports invalid flags or arguments.
+type usageError struct {
+	error
+}
@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+// config holds the settings shared by the subcommands. Each flag defaults to
+// the environment variable named in its usage.
+type config struct {
+	flags *flag.FlagSet
+
+	format    format
+	formats   []string
+	rules     string
+	teams     string
+	issueKeys string
+
+	// envErrs are the invalid values of environment variables found while
+	// adding the flags, which parse reports.
+	envErrs []error
+}
+
+// newConfig creates the flag set of subcommand name. args describes its
+// positional arguments in the usage.
+func newConfig(name, args string) *config {
+	c := &config{flags: flag.NewFlagSet(name, flag.ContinueOnError)}
+	c.flags.Usage = func() {
+		fmt.Fprintf(c.flags.Output(), "Usage: codescene %s [flags] %s\n\nFlags:\n", name, args)
+		c.flags.PrintDefaults()
+	}
+
+	return c
+}
+
+// formatFlag adds the -format flag to commands that print results. They
+// support table, json, csv, markdown and the extra formats.
+func (c *config) formatFlag(extra ...string) {
+	c.formats = append([]string{formatTable, formatJSON, formatCSV, formatMarkdown}, extra...)
+	c.format = formatTable
+	if v, ok := os.LookupEnv("CODESCENE_FORMAT"); ok {
+		if err := c.format.Set(v); err != nil {
+			c.envErrs = append(c.envErrs, fmt.Errorf("$CODESCENE_FORMAT: %w", err))
+		}
+	}
+
+	usage := fmt.Sprintf("output `format`: %s ($CODESCENE_FORMAT)", strings.Join(c.formats, ", "))
+	c.flags.Var(&c.format, "format", usage)
+}
+
+// analysisFlags adds the flags configuring analyses.
+func (c *config) analysisFlags() {
+	c.flags.StringVar(&c.rules, "rules", env("CODESCENE_RULES", ""), "JSON file with commit classification rules ($CODESCENE_RULES)")
+	c.flags.StringVar(&c.teams, "teams", env("CODESCENE_TEAMS", ""), "YAML or JSON file mapping authors to teams ($CODESCENE_TEAMS)")
+	c.flags.StringVar(&c.issueKeys, "issue-keys", env("CODESCENE_ISSUE_KEYS", ""), "comma-separated Jira project keys of issue references like PROJ,OPS (default all but standards like UTF-8) ($CODESCENE_ISSUE_KEYS)")
+}
+
+// parse parses args and validates the flags.
+func (c *config) parse(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		// The flag package already reported the error
+		return exitError{2}
+	}
+
+	if len(c.envErrs) > 0 {
+		return usageError{errors.Join(c.envErrs...)}
+	}
+
+	if c.formats != nil && !slices.Contains(c.formats, string(c.format)) {
+		return usageError{fmt.Errorf("format %s isn't supported, use %s", c.format, strings.Join(c.formats, ", "))}
+	}
+
+	return nil
+}
+
+// project returns the single project passed as argument.
+func (c *config) project() (string, error) {
+	if c.flags.NArg() != 1 {
+		return "", usageError{errors.New("expected exactly one project")}
+	}
+
+	return internal.SanitizeRepo(c.flags.Arg(0))
+}
+
+// open loads the files named by the flags and opens the database. Only
+// commands with analysis flags load teams.
+func (c *config) open() (*database.DB, error) {
+	if c.issueKeys != "" {
+		keys, err := database.ParseIssueKeys(c.issueKeys)
+		if err != nil {
+			return nil, usageError{err}
+		}
+		database.IssueKeys = keys
+	}
+
+	if c.rules != "" {
+		rules, err := database.LoadClassificationRules(c.rules)
+		if err != nil {
+			return nil, fmt.Errorf("loading classification rules: %w", err)
+		}
+		internal.ClassificationRules = rules
+	}
+
+	var teams []database.Team
+	if c.teams != "" {
+		var err error
+		teams, err = database.LoadTeams(c.teams)
+		if err != nil {
+			return nil, fmt.Errorf("loading teams: %w", err)
+		}
+	}
+
+	db, err := database.Init()
+	if err != nil {
+		return nil, fmt.Errorf("initializing database: %w", err)
+	}
+
+	if teams != nil {
+		if _, err := syncTeams(db, teams); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("setting teams: %w", err)
+		}
+	}
+
+	return db, nil
+}
+
+// syncTeams stores teams, if they differ from the stored definitions, and
+// reports whether they did. The order of teams and members doesn't matter.
+func syncTeams(db *database.DB, teams []database.Team) (bool, error) {
+	stored, err := db.GetTeams()
+	if err != nil {
+		return false, err
+	}
+
+	normalized := make([]database.Team, len(teams))
+	for i, team := range teams {
+		normalized[i] = database.Team{Name: team.Name, Members: slices.Compact(slices.Sorted(slices.Values(team.Members)))}
+	}
+	slices.SortFunc(normalized, func(a, b database.Team) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	// Teams without members aren't stored
+	normalized = slices.DeleteFunc(normalized, func(team database.Team) bool {
+		return len(team.Members) == 0
+	})
+
+	if slices.EqualFunc(stored, normalized, func(a, b database.Team) bool {
+		return a.Name == b.Name && slices.Equal(a.Members, b.Members)
+	}) {
+		return false, nil
+	}
+
+	if err := db.SetTeams(teams); err != nil {
+		return false, err
+	}
+	log.Info().Int("teams", len(normalized)).Msg("Updated team definitions")
+
+	return true, nil
+}
+
+func env(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+
+	return fallback
+}
+
+// envDuration returns the duration in the environment variable key or
+// fallback, if it isn't set. Invalid durations are reported by parse.
+func (c *config) envDuration(key string, fallback time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		c.envErrs = append(c.envErrs, fmt.Errorf("invalid duration %q in $%s", v, key))
+		return fallback
+	}
+
+	return d
+}
+
+// parseTime parses the value of a time flag.
+func parseTime(value string) (time.Time, error) {
+	t, err := internal.ParseTime(value)
+	if err != nil {
+		return time.Time{}, usageError{err}
+	}
+
+	return t, nil
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func TestSyncTeams(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	db, err := database.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name    string
+		teams   []database.Team
+		changed bool
+	}{
+		{"initial", []database.Team{{Name: "Platform", Members: []string{"Jane", "Alice"}}, {Name: "Apps", Members: []string{"Bob"}}}, true},
+		{"same", []database.Team{{Name: "Platform", Members: []string{"Jane", "Alice"}}, {Name: "Apps", Members: []string{"Bob"}}}, false},
+		{"reordered", []database.Team{{Name: "Apps", Members: []string{"Bob"}}, {Name: "Platform", Members: []string{"Alice", "Jane", "Alice"}}}, false},
+		{"empty team", []database.Team{{Name: "Apps", Members: []string{"Bob"}}, {Name: "Platform", Members: []string{"Alice", "Jane"}}, {Name: "New"}}, false},
+		{"moved member", []database.Team{{Name: "Apps", Members: []string{"Bob", "Jane"}}, {Name: "Platform", Members: []string{"Alice"}}}, true},
+		{"removed team", []database.Team{{Name: "Apps", Members: []string{"Bob", "Jane"}}}, true},
+		{"none", []database.Team{}, true},
+		{"still none", []database.Team{}, false},
+	}
+
+	for _, test := range tests {
+		changed, err := syncTeams(db, test.teams)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if changed != test.changed {
+			t.Errorf("%s: got changed %v, want %v", test.name, changed, test.changed)
+		}
+	}
+
+	teams, err := db.GetTeams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(teams) != 0 {
+		t.Errorf("got teams %v, want none", teams)
+	}
+}
+
+func TestFormatFlag(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		args   []string
+		extra  []string
+		format format
+		valid  bool
+	}{
+		{"default", "", nil, nil, formatTable, true},
+		{"flag", "", []string{"-format", "markdown"}, nil, formatMarkdown, true},
+		{"environment", "csv", nil, nil, formatCSV, true},
+		{"flag over environment", "csv", []string{"-format", "json"}, nil, formatJSON, true},
+		{"extra", "", []string{"-format", "sarif"}, []string{formatSARIF}, formatSARIF, true},
+		{"unsupported", "", []string{"-format", "sarif"}, nil, "", false},
+		{"unsupported environment", "arrow", nil, nil, "", false},
+		{"unknown environment", "yaml", nil, nil, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.env != "" {
+				t.Setenv("CODESCENE_FORMAT", test.env)
+			}
+
+			c := newConfig("test", "")
+			c.formatFlag(test.extra...)
+			err := c.parse(test.args)
+			if !test.valid {
+				if !errors.As(err, new(usageError)) {
+					t.Errorf("got error %v, want usage error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.format != test.format {
+				t.Errorf("got format %q, want %q", c.format, test.format)
+			}
+		})
+	}
+
+	c := newConfig("test", "")
+	c.formatFlag()
+	c.flags.SetOutput(new(strings.Builder))
+	if err := c.parse([]string{"-format", "yaml"}); !errors.Is(err, exitError{2}) {
+		t.Errorf("got error %v for an unknown format, want exit code 2", err)
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	t.Setenv("CODESCENE_READ_TIMEOUT", "1m")
+	t.Setenv("CODESCENE_WRITE_TIMEOUT", "soon")
+
+	c := newConfig("serve", "")
+	sc := c.serveFlags()
+	if sc.readTimeout != time.Minute {
+		t.Errorf("got read timeout %s, want 1m", sc.readTimeout)
+	}
+
+	err := c.parse(nil)
+	if !errors.As(err, new(usageError)) || !strings.Contains(err.Error(), "$CODESCENE_WRITE_TIMEOUT") {
+		t.Errorf("got error %v, want usage error about $CODESCENE_WRITE_TIMEOUT", err)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	tbl := table{header: []string{"path", "message"}}
+	tbl.add("a|b.go", "first\nsecond")
+
+	var b strings.Builder
+	if err := write(&b, formatMarkdown, nil, tbl); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "| path | message |\n|---|---|\n| a\\|b.go | first second |\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
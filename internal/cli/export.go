@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func exportCommand(args []string) error {
+	c := newConfig("export", "")
+	project := c.flags.String("project", "", "project to export")
+	format := c.flags.String("format", string(database.Parquet), "file format: parquet, csv, jsonl")
+	out := c.flags.String("out", ".", "directory to write the files to")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	if *project == "" {
+		return usageError{errors.New("-project is required")}
+	}
+
+	name, err := internal.SanitizeRepo(*project)
+	if err != nil {
+		return usageError{err}
+	}
+
+	if err := database.ExportFormat(*format).Validate(); err != nil {
+		return usageError{err}
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	files, err := db.Export(ctx, name, database.ExportFormat(*format), *out)
+	if err != nil {
+		return fmt.Errorf("exporting %s: %w", name, err)
+	}
+
+	for _, file := range files {
+		log.Info().Str("file", file).Msg("Exported")
+	}
+
+	return nil
+}
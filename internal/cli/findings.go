@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"os"
+	"time"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func findingsCommand(args []string) error {
+	c := newConfig("findings", "<project>")
+	c.formatFlag(formatSARIF)
+	t := internal.FindingThresholds{Since: time.Now().Add(-internal.RegressionWindow)}
+	c.flags.Float64Var(&t.HotspotScore, "hotspot-score", database.HotspotThreshold, "hotspot score from which on files are hotspots")
+	c.flags.Int64Var(&t.MaxComplexity, "max-complexity", internal.MaxFileComplexity, "complexity from which on files are highly complex")
+	c.flags.Int64Var(&t.MinComplexityIncrease, "min-increase", internal.MinComplexityIncrease, "complexity increase from which on files are regressions")
+	since := c.flags.String("since", "", "start of the period of complexity increases (default 90 days ago)")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	project, err := c.project()
+	if err != nil {
+		return err
+	}
+
+	if *since != "" {
+		if t.Since, err = parseTime(*since); err != nil {
+			return err
+		}
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	findings, err := internal.Findings(db, project, t)
+	if err != nil {
+		return err
+	}
+
+	if c.format == formatSARIF {
+		return internal.WriteSARIF(os.Stdout, findings)
+	}
+
+	tbl := table{header: []string{"rule", "level", "path", "complexity", "delta", "score", "message"}}
+	for _, f := range findings {
+		tbl.add(f.Rule, f.Level, f.Path, itoa(int(f.Complexity)), itoa(int(f.Delta)), ftoa(f.Score), f.Message)
+	}
+
+	return write(os.Stdout, c.format, findings, tbl)
+}
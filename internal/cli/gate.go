@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/git"
+)
+
+func gateCommand(args []string) error {
+	c := newConfig("gate", "<base>..<head>")
+	c.formatFlag(formatSARIF)
+	repo := c.flags.String("repo", ".", "path of the local repository")
+	project := c.flags.String("project", "", "project whose hotspots are checked (default derived from the origin remote)")
+
+	var thresholds internal.GateThresholds
+	c.flags.Float64Var(&thresholds.HotspotScore, "hotspot-score", database.HotspotThreshold, "hotspot score from which on files are hotspots")
+	c.flags.Int64Var(&thresholds.MaxHotspotIncrease, "max-hotspot-increase", 0, "maximum complexity increase of hotspots, -1 to disable")
+	c.flags.Int64Var(&thresholds.MaxNewFileComplexity, "max-new-complexity", 20, "maximum complexity of new files, -1 to disable")
+	c.flags.Float64Var(&thresholds.MaxCommentRatioDecline, "max-comment-decline", 5, "maximum decline of the comment ratio in percentage points, -1 to disable")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	if c.flags.NArg() != 1 {
+		return usageError{errors.New("expected the range as single argument")}
+	}
+
+	base, head, err := internal.ParseRange(c.flags.Arg(0))
+	if err != nil {
+		return usageError{err}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *project == "" {
+		if *project, err = remoteProject(ctx, git.Open(*repo, "")); err != nil {
+			return usageError{fmt.Errorf("deriving the project from the origin remote: %w, use -project", err)}
+		}
+	} else if *project, err = internal.SanitizeRepo(*project); err != nil {
+		return usageError{err}
+	}
+	repository := git.Open(*repo, *project)
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := internal.Gate(ctx, db, repository, *project, base, head, thresholds)
+	if err != nil {
+		return err
+	}
+
+	if err := writeGate(c.format, result); err != nil {
+		return err
+	}
+
+	if !result.Passed() {
+		return exitError{1}
+	}
+
+	return nil
+}
+
+// writeGate writes the result of a gate. Markdown is meant for comments on
+// pull requests.
+func writeGate(f format, result internal.GateResult) error {
+	switch f {
+	case formatSARIF:
+		return internal.WriteSARIF(os.Stdout, result.Findings())
+	case formatMarkdown:
+		return result.WriteMarkdown(os.Stdout)
+	}
+
+	files := table{header: []string{"path", "renamed from", "complexity before", "complexity after", "delta", "hotspot score"}}
+	for _, file := range result.Files {
+		files.add(file.Path, file.RenamedFrom, itoa(int(file.ComplexityBefore)), itoa(int(file.ComplexityAfter)), itoa(int(file.ComplexityDelta())), ftoa(file.HotspotScore))
+	}
+
+	// CSV can't hold the summary as well, so it only contains the files
+	if f != formatTable {
+		return write(os.Stdout, f, result, files)
+	}
+
+	status := "passed"
+	if !result.Passed() {
+		status = "failed"
+	}
+	summary := table{header: []string{"metric", "value"}}
+	summary.add("status", status)
+	summary.add("range", git.ShortHash(result.Base)+".."+git.ShortHash(result.Head))
+	summary.add("changed files", itoa(len(result.Files)))
+	summary.add("comment ratio", fmt.Sprintf("%.1f%% → %.1f%%", result.CommentRatioBefore, result.CommentRatioAfter))
+
+	violations := table{header: []string{"rule", "path", "message"}}
+	for _, v := range result.Violations {
+		violations.add(v.Rule, v.Path, v.Message)
+	}
+
+	return writeSections(os.Stdout, f, []section{
+		{"Summary", summary},
+		{"Violations", violations},
+		{"Changed files", files},
+	})
+}
+
+// remoteProject derives the project from the URL of the origin remote, which
+// may also be an SSH address like git@github.com:user/repo.git.
+func remoteProject(ctx context.Context, repository git.Repository) (string, error) {
+	url, err := repository.RemoteURL(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	url = strings.TrimSuffix(url, ".git")
+	if !strings.Contains(url, "://") {
+		if host, path, found := strings.Cut(url, ":"); found {
+			url = host[strings.Index(host, "@")+1:] + "/" + path
+		}
+	}
+
+	return internal.SanitizeRepo(url)
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+func importCommand(args []string) error {
+	c := newConfig("import", "<dir>...")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	if c.flags.NArg() == 0 {
+		return usageError{errors.New("no directory specified")}
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, dir := range c.flags.Args() {
+		result, err := db.Import(ctx, dir)
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", dir, err)
+		}
+
+		log.Info().
+			Str("dir", dir).
+			Str("projects", strings.Join(result.Projects, ", ")).
+			Int("commits", result.Commits).
+			Int("skipped", result.Skipped).
+			Int("filestates", result.Filestates).
+			Int("issues", result.Issues).
+			Msg("Imported")
+	}
+
+	return nil
+}
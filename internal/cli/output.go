@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	formatTable    = "table"
+	formatJSON     = "json"
+	formatCSV      = "csv"
+	formatMarkdown = "markdown"
+	formatSARIF    = "sarif"
+	formatArrow    = "arrow"
+)
+
+// formats are the values of the -format flag. Each command supports some of
+// them, see config.formatFlag.
+var formats = []string{formatTable, formatJSON, formatCSV, formatMarkdown, formatSARIF, formatArrow}
+
+// format is the value of the -format flag.
+type format string
+
+func (f *format) String() string {
+	return string(*f)
+}
+
+func (f *format) Set(value string) error {
+	if !slices.Contains(formats, value) {
+		return fmt.Errorf("invalid format %q, use %s", value, strings.Join(formats, ", "))
+	}
+
+	*f = format(value)
+	return nil
+}
+
+// table is the tabular form of a result.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// write writes v as JSON or t as an aligned table, CSV or Markdown table,
+// depending on f.
+func write(w io.Writer, f format, v any, t table) error {
+	switch f {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(t.header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(t.rows); err != nil {
+			return err
+		}
+		return cw.Error()
+	case formatMarkdown:
+		// Pipes and newlines in cells would end them
+		escape := strings.NewReplacer("|", "\\|", "\n", " ")
+		fmt.Fprintf(w, "| %s |\n", strings.Join(t.header, " | "))
+		fmt.Fprintf(w, "|%s\n", strings.Repeat("---|", len(t.header)))
+		for _, row := range t.rows {
+			cells := make([]string, len(row))
+			for i, cell := range row {
+				cells[i] = escape.Replace(cell)
+			}
+			if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			// Tabs and newlines in cells would break the alignment
+			cells := make([]string, len(row))
+			for i, cell := range row {
+				cells[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(cell)
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// section is a titled table of results with several tables.
+type section struct {
+	title string
+	table table
+}
+
+// writeSections writes the sections as aligned tables or Markdown tables
+// with headings, depending on f.
+func writeSections(w io.Writer, f format, sections []section) error {
+	for i, s := range sections {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if f == formatMarkdown {
+			fmt.Fprintf(w, "## %s\n\n", s.title)
+		} else {
+			fmt.Fprintf(w, "%s\n\n", s.title)
+		}
+		if err := write(w, f, nil, s.table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+func date(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
@@ -0,0 +1,349 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func projectsCommand(args []string) error {
+	c := newConfig("projects", "")
+	c.formatFlag()
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	projects, err := db.GetProjectSummaries()
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"project", "commits", "contributors", "first commit", "last commit"}}
+	for _, p := range projects {
+		t.add(p.Name, itoa(p.Commits), itoa(p.Contributors), date(p.FirstCommit), date(p.LastCommit))
+	}
+
+	return write(os.Stdout, c.format, truncate(projects, 0), t)
+}
+
+func deleteCommand(args []string) error {
+	c := newConfig("delete", "<project>...")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	if c.flags.NArg() == 0 {
+		return usageError{errors.New("no project specified")}
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, arg := range c.flags.Args() {
+		project, err := internal.SanitizeRepo(arg)
+		if err != nil {
+			return err
+		}
+
+		if err := db.DeleteProject(project); err != nil {
+			return fmt.Errorf("deleting %s: %w", project, err)
+		}
+		log.Info().Str("project", project).Msg("Deleted project")
+	}
+
+	return nil
+}
+
+func hotspotsCommand(args []string) error {
+	c := newConfig("hotspots", "<project>")
+	c.formatFlag()
+	limit := c.flags.Int("limit", 20, "maximum number of hotspots, 0 for all")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	project, err := c.project()
+	if err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	hotspots, err := db.GetHotspots(project)
+	if err != nil {
+		return err
+	}
+	hotspots = truncate(hotspots, *limit)
+
+	t := table{header: []string{"path", "language", "sloc", "complexity", "revisions", "churn", "authors", "score"}}
+	for _, h := range hotspots {
+		t.add(h.Path, h.Language, itoa(h.Sloc), itoa(h.Complexity), itoa(h.Revisions), itoa(h.Churn), itoa(h.Authors), ftoa(h.Score))
+	}
+
+	return write(os.Stdout, c.format, hotspots, t)
+}
+
+func couplingCommand(args []string) error {
+	c := newConfig("coupling", "<project>")
+	c.formatFlag()
+	level := c.flags.String("level", "file", "level of the coupling: file, component or team")
+	limit := c.flags.Int("limit", 20, "maximum number of coupled pairs, 0 for all")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	project, err := c.project()
+	if err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch *level {
+	case "file":
+		coupling, err := db.GetFileCoupling(project)
+		if err != nil {
+			return err
+		}
+		coupling = truncate(coupling, *limit)
+
+		t := table{header: []string{"path", "coupled", "shared commits", "degree"}}
+		for _, fc := range coupling {
+			t.add(fc.Path, fc.Coupled, itoa(fc.SharedCommits), ftoa(fc.Degree))
+		}
+		return write(os.Stdout, c.format, coupling, t)
+	case "component":
+		coupling, err := db.GetComponentCoupling(project)
+		if err != nil {
+			return err
+		}
+		coupling = truncate(coupling, *limit)
+
+		t := table{header: []string{"component", "coupled", "shared commits", "degree"}}
+		for _, cc := range coupling {
+			t.add(cc.Component, cc.Coupled, itoa(cc.SharedCommits), ftoa(cc.Degree))
+		}
+		return write(os.Stdout, c.format, coupling, t)
+	case "team":
+		coupling, err := db.GetTeamCoupling(project)
+		if err != nil {
+			return err
+		}
+		coupling = truncate(coupling, *limit)
+
+		t := table{header: []string{"path", "team", "coupled", "coupled team", "shared commits", "degree"}}
+		for _, tc := range coupling {
+			t.add(tc.Path, tc.Team, tc.Coupled, tc.CoupledTeam, itoa(tc.SharedCommits), ftoa(tc.Degree))
+		}
+		return write(os.Stdout, c.format, coupling, t)
+	default:
+		return usageError{fmt.Errorf("invalid level %q, use file, component or team", *level)}
+	}
+}
+
+func authorsCommand(args []string) error {
+	c := newConfig("authors", "<project>")
+	c.formatFlag()
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	project, err := c.project()
+	if err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	contributors, err := db.GetContributors(project)
+	if err != nil {
+		return err
+	}
+
+	return write(os.Stdout, c.format, contributors, authorsTable(contributors))
+}
+
+func authorsTable(contributors []database.Contributor) table {
+	t := table{header: []string{"author", "team", "commits", "lines added", "lines deleted", "first commit", "last commit"}}
+	for _, a := range contributors {
+		t.add(a.Contributor, a.Team, itoa(a.Commits), itoa(a.LinesAdded), itoa(a.LinesDeleted), date(a.FirstCommit), date(a.LastCommit))
+	}
+
+	return t
+}
+
+func historyCommand(args []string) error {
+	c := newConfig("history", "<project>")
+	c.formatFlag()
+	author := c.flags.String("author", "", "only commits of this author")
+	category := c.flags.String("category", "", "only commits of this category")
+	since := c.flags.String("since", "", "only commits at or after this date (YYYY-MM-DD or RFC 3339)")
+	until := c.flags.String("until", "", "only commits before this date (YYYY-MM-DD or RFC 3339)")
+	limit := c.flags.Int("limit", 50, "maximum number of commits")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	project, err := c.project()
+	if err != nil {
+		return err
+	}
+
+	filter := database.CommitFilter{
+		Author:   *author,
+		Category: *category,
+		Limit:    *limit,
+	}
+	if filter.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.GetProject(project); err != nil {
+		return err
+	}
+
+	page, err := db.GetCommits(project, filter)
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"hash", "date", "author", "category", "files", "added", "deleted", "message"}}
+	for _, ci := range page.Commits {
+		t.add(ci.Hash, ci.Date.Format(time.RFC3339), ci.Author, ci.Category, itoa(ci.FilesTouched), itoa(ci.LinesAdded), itoa(ci.LinesDeleted), ci.Message)
+	}
+
+	return write(os.Stdout, c.format, page.Commits, t)
+}
+
+// report summarizes the code health of a project.
+type report struct {
+	Project  database.Project        `json:"project"`
+	KPIs     database.KPIs           `json:"kpis"`
+	Hotspots []database.Hotspot      `json:"hotspots"`
+	Coupling []database.FileCoupling `json:"coupling"`
+	Authors  []database.Contributor  `json:"authors"`
+}
+
+func reportCommand(args []string) error {
+	c := newConfig("report", "<project>")
+	c.formatFlag()
+	limit := c.flags.Int("limit", 10, "maximum number of hotspots, coupled pairs and authors")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	project, err := c.project()
+	if err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var r report
+	if r.Project, err = db.GetProject(project); err != nil {
+		return err
+	}
+	if r.KPIs, err = db.GetKPIs(project, database.HotspotThreshold, time.Now().Add(-database.ActiveContributorWindow)); err != nil {
+		return err
+	}
+	if r.Hotspots, err = db.GetHotspots(project); err != nil {
+		return err
+	}
+	if r.Coupling, err = db.GetFileCoupling(project); err != nil {
+		return err
+	}
+	if r.Authors, err = db.GetContributors(project); err != nil {
+		return err
+	}
+	r.Hotspots = truncate(r.Hotspots, *limit)
+	r.Coupling = truncate(r.Coupling, *limit)
+	r.Authors = truncate(r.Authors, *limit)
+
+	summary := table{header: []string{"metric", "value"}}
+	summary.add("project", r.Project.Name)
+	summary.add("commits", itoa(r.Project.Commits))
+	summary.add("contributors", itoa(r.Project.Contributors))
+	summary.add("first commit", date(r.Project.FirstCommit))
+	summary.add("last commit", date(r.Project.LastCommit))
+	summary.add("sloc", itoa(r.KPIs.Sloc))
+	summary.add("complexity", itoa(r.KPIs.Complexity))
+	summary.add("hotspots", itoa(r.KPIs.Hotspots))
+	summary.add("bus factor", itoa(r.KPIs.BusFactor))
+	summary.add("active contributors", itoa(r.KPIs.ActiveContributors))
+
+	// CSV can't hold several tables, so it only contains the summary
+	if c.format != formatTable && c.format != formatMarkdown {
+		return write(os.Stdout, c.format, r, summary)
+	}
+
+	hotspots := table{header: []string{"path", "complexity", "revisions", "score"}}
+	for _, h := range r.Hotspots {
+		hotspots.add(h.Path, itoa(h.Complexity), itoa(h.Revisions), ftoa(h.Score))
+	}
+
+	coupling := table{header: []string{"path", "coupled", "shared commits", "degree"}}
+	for _, fc := range r.Coupling {
+		coupling.add(fc.Path, fc.Coupled, itoa(fc.SharedCommits), ftoa(fc.Degree))
+	}
+
+	return writeSections(os.Stdout, c.format, []section{
+		{"Summary", summary},
+		{"Hotspots", hotspots},
+		{"Change coupling", coupling},
+		{"Authors", authorsTable(r.Authors)},
+	})
+}
+
+// truncate returns the first limit items of s, or all of them, if limit is
+// zero. The result is never nil, so it is encoded as empty JSON array.
+func truncate[T any](s []T, limit int) []T {
+	if s == nil {
+		return []T{}
+	}
+
+	if limit > 0 && len(s) > limit {
+		return s[:limit]
+	}
+
+	return s
+}
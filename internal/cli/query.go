@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/signal"
+
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func queryCommand(args []string) error {
+	c := newConfig("query", "<sql>")
+	c.formatFlag(formatArrow)
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	if c.flags.NArg() != 1 {
+		return usageError{errors.New("expected the query as single argument")}
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := db.RunQuery(ctx, c.flags.Arg(0), 0)
+	if err != nil {
+		return err
+	}
+
+	switch c.format {
+	case formatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case formatCSV:
+		return result.WriteCSV(os.Stdout)
+	case formatArrow:
+		return result.WriteArrow(os.Stdout)
+	default:
+		return write(os.Stdout, c.format, nil, queryTable(result))
+	}
+}
+
+func queryTable(result database.QueryResult) table {
+	t := table{header: result.Columns}
+	for _, row := range result.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = database.FormatValue(v)
+		}
+		t.add(cells...)
+	}
+
+	return t
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/git"
+)
+
+func reviewCommand(args []string) error {
+	c := newConfig("review", "<base>..<head>")
+	c.formatFlag()
+	repo := c.flags.String("repo", ".", "path of the local repository")
+	project := c.flags.String("project", "", "project whose history is used (default derived from the origin remote)")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	if c.flags.NArg() != 1 {
+		return usageError{errors.New("expected the range as single argument")}
+	}
+
+	base, head, err := internal.ParseRange(c.flags.Arg(0))
+	if err != nil {
+		return usageError{err}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *project == "" {
+		if *project, err = remoteProject(ctx, git.Open(*repo, "")); err != nil {
+			return usageError{fmt.Errorf("deriving the project from the origin remote: %w, use -project", err)}
+		}
+	} else if *project, err = internal.SanitizeRepo(*project); err != nil {
+		return usageError{err}
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := internal.Review(ctx, db, git.Open(*repo, *project), *project, base, head)
+	if err != nil {
+		return err
+	}
+
+	files := table{header: []string{"path", "risk", "added", "deleted", "hotspot score", "author commits", "missing coupled"}}
+	for _, f := range result.Files {
+		files.add(f.Path, ftoa(f.Risk), itoa(int(f.LinesAdded)), itoa(int(f.LinesDeleted)), ftoa(f.HotspotScore), itoa(f.AuthorCommits), strings.Join(f.MissingCoupled, " "))
+	}
+
+	// CSV can't hold the summary as well, so it only contains the files
+	if c.format != formatTable && c.format != formatMarkdown {
+		return write(os.Stdout, c.format, result, files)
+	}
+
+	summary := table{header: []string{"metric", "value"}}
+	summary.add("project", result.Project)
+	summary.add("range", git.ShortHash(result.Base)+".."+git.ShortHash(result.Head))
+	summary.add("commits", itoa(result.Commits))
+	summary.add("authors", strings.Join(result.Authors, ", "))
+	summary.add("lines changed", itoa(int(result.LinesChanged)))
+	summary.add("risk", fmt.Sprintf("%s (%s)", ftoa(result.Score), result.Level))
+	summary.add("hotspots", ftoa(result.Factors.Hotspots))
+	summary.add("unfamiliar files", ftoa(result.Factors.Experience))
+	summary.add("missing coupled changes", ftoa(result.Factors.Coupling))
+	summary.add("size", ftoa(result.Factors.Size))
+
+	return writeSections(os.Stdout, c.format, []section{
+		{"Summary", summary},
+		{"Files by risk", files},
+	})
+}
@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal/auth"
+	"github.com/tim-hilt/codescene/internal/server"
+)
+
+type serveConfig struct {
+	addr            string
+	certFile        string
+	keyFile         string
+	cors            string
+	authFile        string
+	webhookSecret   string
+	scheduleFile    string
+	schedule        string
+	scheduleJitter  string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+}
+
+// serveFlags adds the flags configuring the web server.
+func (c *config) serveFlags() *serveConfig {
+	var sc serveConfig
+	c.flags.StringVar(&sc.addr, "addr", env("CODESCENE_ADDR", ":8000"), "listen address ($CODESCENE_ADDR)")
+	c.flags.StringVar(&sc.certFile, "tls-cert", env("CODESCENE_TLS_CERT", ""), "TLS certificate file, serves HTTPS if set together with -tls-key ($CODESCENE_TLS_CERT)")
+	c.flags.StringVar(&sc.keyFile, "tls-key", env("CODESCENE_TLS_KEY", ""), "TLS key file ($CODESCENE_TLS_KEY)")
+	c.flags.StringVar(&sc.cors, "cors", env("CODESCENE_CORS", ""), "comma-separated origins allowed to make cross-origin requests with credentials, * for all without credentials (default disabled) ($CODESCENE_CORS)")
+	c.flags.StringVar(&sc.authFile, "auth", env("CODESCENE_AUTH", ""), "YAML or JSON file configuring tokens, users and OIDC, authentication is disabled if empty ($CODESCENE_AUTH)")
+	c.flags.StringVar(&sc.webhookSecret, "webhook-secret", env("CODESCENE_WEBHOOK_SECRET", ""), "secret for validating webhooks under /hooks/, webhooks are disabled if empty ($CODESCENE_WEBHOOK_SECRET)")
+	c.flags.StringVar(&sc.scheduleFile, "schedule-file", env("CODESCENE_SCHEDULE_FILE", ""), "YAML or JSON file with the re-analysis schedule and per-project overrides ($CODESCENE_SCHEDULE_FILE)")
+	c.flags.StringVar(&sc.schedule, "schedule", env("CODESCENE_SCHEDULE", ""), "interval for re-analyzing all projects like 6h or @daily, overrides the schedule file ($CODESCENE_SCHEDULE)")
+	c.flags.StringVar(&sc.scheduleJitter, "schedule-jitter", env("CODESCENE_SCHEDULE_JITTER", ""), "maximum random delay added to scheduled re-analyses, overrides the schedule file ($CODESCENE_SCHEDULE_JITTER)")
+	c.flags.DurationVar(&sc.readTimeout, "read-timeout", c.envDuration("CODESCENE_READ_TIMEOUT", 15*time.Second), "maximum duration for reading a request ($CODESCENE_READ_TIMEOUT)")
+	c.flags.DurationVar(&sc.writeTimeout, "write-timeout", c.envDuration("CODESCENE_WRITE_TIMEOUT", 60*time.Second), "maximum duration for writing a response, except for progress streams ($CODESCENE_WRITE_TIMEOUT)")
+	c.flags.DurationVar(&sc.idleTimeout, "idle-timeout", c.envDuration("CODESCENE_IDLE_TIMEOUT", 120*time.Second), "maximum duration to keep idle connections open ($CODESCENE_IDLE_TIMEOUT)")
+	c.flags.DurationVar(&sc.shutdownTimeout, "shutdown-timeout", c.envDuration("CODESCENE_SHUTDOWN_TIMEOUT", 5*time.Minute), "maximum duration to wait for running analyses on shutdown ($CODESCENE_SHUTDOWN_TIMEOUT)")
+
+	return &sc
+}
+
+func (sc *serveConfig) loadSchedule() (server.Schedule, error) {
+	var schedule server.Schedule
+	if sc.scheduleFile != "" {
+		var err error
+		schedule, err = server.LoadSchedule(sc.scheduleFile)
+		if err != nil {
+			return server.Schedule{}, err
+		}
+	}
+
+	if sc.schedule != "" {
+		interval, err := server.ParseInterval(sc.schedule)
+		if err != nil {
+			return server.Schedule{}, err
+		}
+		schedule.Interval = interval
+	}
+
+	if sc.scheduleJitter != "" {
+		jitter, err := server.ParseInterval(sc.scheduleJitter)
+		if err != nil {
+			return server.Schedule{}, err
+		}
+		schedule.Jitter = jitter
+	}
+
+	return schedule, nil
+}
+
+func serveCommand(args []string) error {
+	c := newConfig("serve", "")
+	c.analysisFlags()
+	sc := c.serveFlags()
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	if (sc.certFile == "") != (sc.keyFile == "") {
+		return usageError{errors.New("both -tls-cert and -tls-key must be set to serve HTTPS")}
+	}
+
+	if sc.cors != "" {
+		server.AllowedOrigins = strings.Split(sc.cors, ",")
+	}
+
+	server.WebhookSecret = sc.webhookSecret
+
+	schedule, err := sc.loadSchedule()
+	if err != nil {
+		return fmt.Errorf("loading schedule: %w", err)
+	}
+	server.AnalysisSchedule = schedule
+
+	if sc.authFile != "" {
+		authenticator, err := auth.Load(sc.authFile)
+		if err != nil {
+			return fmt.Errorf("loading authentication config: %w", err)
+		}
+		server.Authenticator = authenticator
+	} else {
+		log.Warn().Msg("Authentication is disabled, everyone can analyze and delete projects")
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s := server.New(db)
+	httpServer := &http.Server{
+		Addr:         sc.addr,
+		Handler:      s,
+		ReadTimeout:  sc.readTimeout,
+		WriteTimeout: sc.writeTimeout,
+		IdleTimeout:  sc.idleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Info().Str("addr", sc.addr).Bool("tls", sc.certFile != "").Msg("Listening")
+		if sc.certFile != "" {
+			errs <- httpServer.ListenAndServeTLS(sc.certFile, sc.keyFile)
+		} else {
+			errs <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		s.Close()
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Info().Dur("timeout", sc.shutdownTimeout).Msg("Shutting down, waiting for running analyses")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), sc.shutdownTimeout)
+	defer cancel()
+
+	// Analyses are drained first, so progress streams receive their final event
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		log.Warn().Err(err).Msg("Canceled running analyses")
+	}
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Err(err).Msg("Failed to shut down server gracefully")
+		httpServer.Close()
+	}
+
+	// Requests are done, so the repositories of reviews can be removed
+	s.Close()
+
+	log.Info().Msg("Server stopped")
+
+	return nil
+}
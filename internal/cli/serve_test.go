@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeFlags(t *testing.T) {
+	t.Setenv("CODESCENE_ADDR", "127.0.0.1:9000")
+	t.Setenv("CODESCENE_IDLE_TIMEOUT", "30s")
+
+	c := newConfig("serve", "")
+	sc := c.serveFlags()
+	if err := c.parse([]string{"-read-timeout", "5s", "-tls-cert", "cert.pem", "-tls-key", "key.pem"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := serveConfig{
+		addr:            "127.0.0.1:9000",
+		certFile:        "cert.pem",
+		keyFile:         "key.pem",
+		readTimeout:     5 * time.Second,
+		writeTimeout:    time.Minute,
+		idleTimeout:     30 * time.Second,
+		shutdownTimeout: 5 * time.Minute,
+	}
+	if *sc != want {
+		t.Errorf("got %+v, want %+v", *sc, want)
+	}
+}
+
+func TestServeRequiresTLSKeyPair(t *testing.T) {
+	for _, args := range [][]string{{"-tls-cert", "cert.pem"}, {"-tls-key", "key.pem"}} {
+		err := serveCommand(args)
+		if !errors.As(err, new(usageError)) || !strings.Contains(err.Error(), "-tls-cert and -tls-key") {
+			t.Errorf("%v: got error %v, want usage error about the key pair", args, err)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+	_ "time/tzdata"
+)
+
+type Granularity string
+
+const (
+	Day   Granularity = "day"
+	Week  Granularity = "week"
+	Month Granularity = "month"
+)
+
+var (
+	ErrGranularity = errors.New("granularity must be one of day, week or month")
+	ErrTimezone    = errors.New("unknown timezone")
+)
+
+func (g Granularity) Validate() error {
+	if !slices.Contains([]Granularity{Day, Week, Month}, g) {
+		return ErrGranularity
+	}
+
+	return nil
+}
+
+// directoryExpr evaluates to the directory of f.path, or "." for files in the
+// repository root.
+const directoryExpr = `CASE WHEN contains(f.path, '/') THEN regexp_replace(f.path, '/[^/]*$', '') ELSE '.' END`
+
+type Activity struct {
+	Period         string `json:"period"`
+	Authors        int    `json:"authors"`
+	NewAuthors     int    `json:"newAuthors"`
+	LeavingAuthors int    `json:"leavingAuthors"`
+	Commits        int    `json:"commits"`
+	LinesAdded     int    `json:"linesAdded"`
+	LinesDeleted   int    `json:"linesDeleted"`
+	FilesTouched   int    `json:"filesTouched"`
+}
+
+type AreaActivity struct {
+	Period  string `json:"period"`
+	Area    string `json:"area"`
+	Authors int    `json:"authors"`
+}
+
+type ProjectActivity struct {
+	Activity     []Activity     `json:"activity"`
+	AreaActivity []AreaActivity `json:"areaActivity"`
+}
+
+// GetActivity aggregates the development activity of project per period of
+// the given granularity. New and leaving authors are those, whose first or
+// last commit falls into the period. AreaActivity lists the number of authors
+// that concurrently changed each directory within a period.
+func (db DB) GetActivity(project string, granularity Granularity) (ProjectActivity, error) {
+	if err := granularity.Validate(); err != nil {
+		return ProjectActivity{}, err
+	}
+
+	rows, err := db.Query(`
+	WITH periods AS (
+		SELECT
+			c.hash,
+			c.contributor,
+			date_trunc(?, c.author_date) AS period,
+			date_trunc(?, min(c.author_date) OVER (PARTITION BY c.contributor)) AS first_period,
+			date_trunc(?, max(c.author_date) OVER (PARTITION BY c.contributor)) AS last_period
+		FROM commits c
+		WHERE c.project = ?
+	), changes AS (
+		SELECT
+			p.period,
+			COUNT(DISTINCT f.path) AS files_touched,
+			SUM(f.lines_added) AS lines_added,
+			SUM(f.lines_deleted) AS lines_deleted
+		FROM periods p
+		JOIN filestates f ON f.commit_hash = p.hash
+		WHERE f.lines_added + f.lines_deleted > 0
+		GROUP BY p.period
+	)
+	SELECT
+		strftime(p.period, '%Y-%m-%d'),
+		COUNT(DISTINCT p.contributor),
+		COUNT(DISTINCT p.contributor) FILTER (WHERE p.period = p.first_period),
+		COUNT(DISTINCT p.contributor) FILTER (WHERE p.period = p.last_period),
+		COUNT(*),
+		COALESCE(ch.lines_added, 0),
+		COALESCE(ch.lines_deleted, 0),
+		COALESCE(ch.files_touched, 0)
+	FROM periods p
+	LEFT JOIN changes ch ON ch.period = p.period
+	GROUP BY p.period, ch.lines_added, ch.lines_deleted, ch.files_touched
+	ORDER BY p.period`, string(granularity), string(granularity), string(granularity), project)
+	if err != nil {
+		return ProjectActivity{}, err
+	}
+	defer rows.Close()
+
+	var activity []Activity
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(
+			&a.Period,
+			&a.Authors,
+			&a.NewAuthors,
+			&a.LeavingAuthors,
+			&a.Commits,
+			&a.LinesAdded,
+			&a.LinesDeleted,
+			&a.FilesTouched,
+		); err != nil {
+			return ProjectActivity{}, err
+		}
+		activity = append(activity, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return ProjectActivity{}, err
+	}
+
+	if len(activity) == 0 {
+		return ProjectActivity{}, ErrProjectNotFound
+	}
+
+	rows, err = db.Query(`
+	SELECT
+		strftime(date_trunc(?, c.author_date), '%Y-%m-%d') AS period,
+		`+directoryExpr+` AS area,
+		COUNT(DISTINCT c.contributor) AS authors
+	FROM filestates f
+	JOIN commits c ON c.hash = f.commit_hash
+	WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+	GROUP BY period, area
+	ORDER BY period, authors DESC, area`, string(granularity), project)
+	if err != nil {
+		return ProjectActivity{}, err
+	}
+	defer rows.Close()
+
+	var areaActivity []AreaActivity
+	for rows.Next() {
+		var aa AreaActivity
+		if err := rows.Scan(&aa.Period, &aa.Area, &aa.Authors); err != nil {
+			return ProjectActivity{}, err
+		}
+		areaActivity = append(areaActivity, aa)
+	}
+
+	if err := rows.Err(); err != nil {
+		return ProjectActivity{}, err
+	}
+
+	return ProjectActivity{
+		Activity:     activity,
+		AreaActivity: areaActivity,
+	}, nil
+}
+
+// GetCommitFrequency counts the commits of project per period of the given
+// granularity, from the period of the first commit up to the current one.
+// Periods are determined in the IANA timezone, e.g. "Europe/Berlin".
+func (db DB) GetCommitFrequency(project string, granularity Granularity, timezone string) ([]CommitFrequency, error) {
+	if err := granularity.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, ErrTimezone
+	}
+
+	rows, err := db.Query(`
+	WITH local AS (
+		SELECT date_trunc($granularity, timezone($timezone, author_date AT TIME ZONE 'UTC')) AS period
+		FROM commits
+		WHERE project = $project
+	), periods AS (
+		SELECT generate_series AS period
+		FROM generate_series(
+			(SELECT min(period) FROM local),
+			date_trunc($granularity, timezone($timezone, now())),
+			('1 ' || $granularity)::INTERVAL
+		)
+	)
+	SELECT strftime(p.period, '%Y-%m-%d'), COUNT(l.period)
+	FROM periods p
+	LEFT JOIN local l ON l.period = p.period
+	GROUP BY p.period
+	ORDER BY p.period`,
+		sql.Named("granularity", string(granularity)),
+		sql.Named("timezone", timezone),
+		sql.Named("project", project),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commitFrequency []CommitFrequency
+	for rows.Next() {
+		var cf CommitFrequency
+		if err := rows.Scan(&cf.Day, &cf.Commits); err != nil {
+			return nil, err
+		}
+		commitFrequency = append(commitFrequency, cf)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return commitFrequency, nil
+}
+
+var (
+	// WorkdayStart and WorkdayEnd define the regular working hours in the
+	// author's local time. Commits outside of them count as after hours.
+	WorkdayStart = 8
+	WorkdayEnd   = 18
+)
+
+type HeatmapCell struct {
+	Weekday int `json:"weekday"`
+	Hour    int `json:"hour"`
+	Commits int `json:"commits"`
+}
+
+type OvertimeTrend struct {
+	Month             string `json:"month"`
+	Commits           int    `json:"commits"`
+	WeekendCommits    int    `json:"weekendCommits"`
+	AfterHoursCommits int    `json:"afterHoursCommits"`
+}
+
+type WorkingHours struct {
+	Heatmap []HeatmapCell   `json:"heatmap"`
+	Trend   []OvertimeTrend `json:"trend"`
+}
+
+// GetWorkingHours counts the commits of project per weekday (1 = Monday) and
+// hour of day in the author's local time, as well as the weekend and after
+// hours commits per month. The commits can optionally be restricted to an
+// author or a team; empty values don't filter. Commits that were analyzed
+// before the UTC offset was recorded are treated as UTC.
+func (db DB) GetWorkingHours(project, author, team string) (WorkingHours, error) {
+	localCommitsCTE := `
+	WITH local_commits AS (
+		SELECT c.author_date + to_minutes(COALESCE(c.utc_offset_minutes, 0)) AS local_date
+		FROM commits c
+		LEFT JOIN team_members tm ON tm.contributor = c.contributor
+		WHERE c.project = $project
+			AND ($author = '' OR c.contributor = $author)
+			AND ($team = '' OR COALESCE(tm.team, $unassigned) = $team)
+	)`
+	args := []any{
+		sql.Named("project", project),
+		sql.Named("author", author),
+		sql.Named("team", team),
+		sql.Named("unassigned", UnassignedTeam),
+	}
+
+	rows, err := db.Query(localCommitsCTE+`
+	SELECT isodow(local_date) AS weekday, hour(local_date) AS hour, COUNT(*)
+	FROM local_commits
+	GROUP BY weekday, hour
+	ORDER BY weekday, hour`, args...)
+	if err != nil {
+		return WorkingHours{}, err
+	}
+	defer rows.Close()
+
+	var heatmap []HeatmapCell
+	for rows.Next() {
+		var hc HeatmapCell
+		if err := rows.Scan(&hc.Weekday, &hc.Hour, &hc.Commits); err != nil {
+			return WorkingHours{}, err
+		}
+		heatmap = append(heatmap, hc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return WorkingHours{}, err
+	}
+
+	if len(heatmap) == 0 {
+		return WorkingHours{}, ErrProjectNotFound
+	}
+
+	rows, err = db.Query(localCommitsCTE+`
+	SELECT
+		strftime(date_trunc('month', local_date), '%Y-%m') AS month,
+		COUNT(*),
+		COUNT(*) FILTER (WHERE isodow(local_date) >= 6),
+		COUNT(*) FILTER (WHERE isodow(local_date) < 6 AND (hour(local_date) < $start OR hour(local_date) >= $end))
+	FROM local_commits
+	GROUP BY month
+	ORDER BY month`, append(args, sql.Named("start", WorkdayStart), sql.Named("end", WorkdayEnd))...)
+	if err != nil {
+		return WorkingHours{}, err
+	}
+	defer rows.Close()
+
+	var trend []OvertimeTrend
+	for rows.Next() {
+		var ot OvertimeTrend
+		if err := rows.Scan(&ot.Month, &ot.Commits, &ot.WeekendCommits, &ot.AfterHoursCommits); err != nil {
+			return WorkingHours{}, err
+		}
+		trend = append(trend, ot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return WorkingHours{}, err
+	}
+
+	return WorkingHours{
+		Heatmap: heatmap,
+		Trend:   trend,
+	}, nil
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/boyter/scc/v3/processor"
+)
+
+func TestGetActivity(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	type change struct {
+		path           string
+		added, deleted int64
+	}
+	commits := []struct {
+		author, date string
+		changes      []change
+	}{
+		// Sunday, so the week started on Monday, December 30
+		{"Alice", "2025-01-05T12:00:00Z", []change{{"a/x.go", 10, 0}}},
+		{"Bob", "2025-01-20T12:00:00Z", []change{{"a/y.go", 5, 2}, {"a/x.go", 0, 0}}},
+		{"Alice", "2025-02-03T12:00:00Z", []change{{"b/z.go", 3, 0}, {"README.md", 1, 0}}},
+		{"Carol", "2025-03-10T12:00:00Z", []change{{"a/x.go", 1, 0}}},
+	}
+
+	stored := testCommits("p", len(commits))
+	var filestates []FileState
+	for i, commit := range commits {
+		stored[i].Author = commit.author
+		stored[i].Date = commit.date
+		for _, c := range commit.changes {
+			filestates = append(filestates, FileState{
+				CommitHash:   stored[i].Hash,
+				LinesAdded:   c.added,
+				LinesDeleted: c.deleted,
+				FileJob:      &processor.FileJob{Filename: c.path, Language: "Go"},
+			})
+		}
+	}
+	if err := db.PersistCommits(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PersistFileStates(ctx, filestates, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		granularity Granularity
+		activity    []Activity
+		areas       string
+	}{
+		{
+			Month,
+			[]Activity{
+				{Period: "2025-01-01", Authors: 2, NewAuthors: 2, LeavingAuthors: 1, Commits: 2, LinesAdded: 15, LinesDeleted: 2, FilesTouched: 2},
+				{Period: "2025-02-01", Authors: 1, NewAuthors: 0, LeavingAuthors: 1, Commits: 1, LinesAdded: 4, FilesTouched: 2},
+				{Period: "2025-03-01", Authors: 1, NewAuthors: 1, LeavingAuthors: 1, Commits: 1, LinesAdded: 1, FilesTouched: 1},
+			},
+			"[{2025-01-01 a 2} {2025-02-01 . 1} {2025-02-01 b 1} {2025-03-01 a 1}]",
+		},
+		{
+			Week,
+			[]Activity{
+				{Period: "2024-12-30", Authors: 1, NewAuthors: 1, LeavingAuthors: 0, Commits: 1, LinesAdded: 10, FilesTouched: 1},
+				{Period: "2025-01-20", Authors: 1, NewAuthors: 1, LeavingAuthors: 1, Commits: 1, LinesAdded: 5, LinesDeleted: 2, FilesTouched: 1},
+				{Period: "2025-02-03", Authors: 1, NewAuthors: 0, LeavingAuthors: 1, Commits: 1, LinesAdded: 4, FilesTouched: 2},
+				{Period: "2025-03-10", Authors: 1, NewAuthors: 1, LeavingAuthors: 1, Commits: 1, LinesAdded: 1, FilesTouched: 1},
+			},
+			"[{2024-12-30 a 1} {2025-01-20 a 1} {2025-02-03 . 1} {2025-02-03 b 1} {2025-03-10 a 1}]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.granularity), func(t *testing.T) {
+			activity, err := db.GetActivity("p", test.granularity)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(activity.Activity) != fmt.Sprint(test.activity) {
+				t.Errorf("got activity\n%+v\nwant\n%+v", activity.Activity, test.activity)
+			}
+			if got := fmt.Sprint(activity.AreaActivity); got != test.areas {
+				t.Errorf("got area activity %s, want %s", got, test.areas)
+			}
+		})
+	}
+
+	if _, err := db.GetActivity("p", "year"); !errors.Is(err, ErrGranularity) {
+		t.Errorf("got error %v, want %v", err, ErrGranularity)
+	}
+	if _, err := db.GetActivity("q", Month); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v for an unknown project, want %v", err, ErrProjectNotFound)
+	}
+}
+
+func TestGetWorkingHours(t *testing.T) {
+	db := openTestDB(t)
+
+	dates := []string{
+		// 07:30 UTC, but 09:30 for the author
+		"2025-01-06T09:30:00+02:00",
+		// Tuesday in UTC, but Monday evening for the author
+		"2025-01-06T23:30:00-05:00",
+		"2025-01-11T10:00:00+05:30",
+		// Saturday in February in UTC, but Friday evening in January for
+		// the author
+		"2025-01-31T23:30:00-05:00",
+		// Analyzed before offsets were recorded, so 07:30 counts
+		"2025-02-03T08:30:00+01:00",
+		"2025-02-04T12:00:00Z",
+	}
+	commits := testCommits("p", len(dates))
+	for i, date := range dates {
+		commits[i].Date = date
+	}
+	commits[5].Author = "Bob"
+	if err := db.PersistCommits(context.Background(), commits); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE commits SET utc_offset_minutes = NULL WHERE hash = ?", commits[4].Hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTeams([]Team{{Name: "Apps", Members: []string{"Bob"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, author, team string
+		heatmap, trend     string
+	}{
+		{
+			"all", "", "",
+			"[{1 7 1} {1 9 1} {1 23 1} {2 12 1} {5 23 1} {6 10 1}]",
+			"[{2025-01 4 1 2} {2025-02 2 0 1}]",
+		},
+		{"author", "Bob", "", "[{2 12 1}]", "[{2025-02 1 0 0}]"},
+		{"team", "", "Apps", "[{2 12 1}]", "[{2025-02 1 0 0}]"},
+		{
+			"unassigned", "", UnassignedTeam,
+			"[{1 7 1} {1 9 1} {1 23 1} {5 23 1} {6 10 1}]",
+			"[{2025-01 4 1 2} {2025-02 1 0 1}]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hours, err := db.GetWorkingHours("p", test.author, test.team)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := fmt.Sprint(hours.Heatmap); got != test.heatmap {
+				t.Errorf("got heatmap %s, want %s", got, test.heatmap)
+			}
+			if got := fmt.Sprint(hours.Trend); got != test.trend {
+				t.Errorf("got trend %s, want %s", got, test.trend)
+			}
+		})
+	}
+
+	if _, err := db.GetWorkingHours("p", "Carol", ""); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v for an unknown author, want %v", err, ErrProjectNotFound)
+	}
+}
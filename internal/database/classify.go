@@ -0,0 +1,153 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	CategoryBugfix   = "bugfix"
+	CategoryFeature  = "feature"
+	CategoryRefactor = "refactor"
+	CategoryChore    = "chore"
+	CategoryOther    = "other"
+)
+
+// ClassificationRule assigns Category to every commit whose message matches
+// Pattern. Rules are evaluated in order and the first match wins.
+type ClassificationRule struct {
+	Category string `json:"category"`
+	Pattern  string `json:"pattern"`
+}
+
+var DefaultClassificationRules = []ClassificationRule{
+	{CategoryChore, `^Merge (pull request|branch|remote-tracking branch)`},
+	{CategoryBugfix, `(?i)\b(fix(e[sd])?|bugs?|bugfix|hotfix|defect|regression|crash(es)?)\b`},
+	{CategoryRefactor, `(?i)\b(refactor(s|ed|ing)?|clean ?up|restructure[sd]?|simplif(y|ies|ied))\b`},
+	{CategoryChore, `(?i)^(chore|build|ci|docs?|style|tests?)(\(.*\))?!?:|\b(bump(s|ed)?|release|version)\b`},
+	{CategoryFeature, `(?i)^feat(\(.*\))?!?:|\b(add(s|ed)?|implement(s|ed)?|introduce[sd]?|support(s|ed)?|feature)\b`},
+}
+
+// LoadClassificationRules reads a JSON array of classification rules from
+// path.
+func LoadClassificationRules(path string) ([]ClassificationRule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ClassificationRule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, err
+	}
+
+	if err := validateClassificationRules(rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func validateClassificationRules(rules []ClassificationRule) error {
+	for _, rule := range rules {
+		if rule.Category == "" {
+			return fmt.Errorf("classification rule %q has no category", rule.Pattern)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("classification rule for %q: %w", rule.Category, err)
+		}
+	}
+
+	return nil
+}
+
+// ClassifyCommits (re-)assigns a category to every commit of project based on
+// its message. Commits that match no rule are categorized as "other".
+func (db *DB) ClassifyCommits(project string, rules []ClassificationRule) error {
+	if err := validateClassificationRules(rules); err != nil {
+		return err
+	}
+
+	var (
+		cases strings.Builder
+		args  []any
+	)
+
+	// CASE needs at least one WHEN, without rules all commits are "other"
+	if len(rules) > 0 {
+		cases.WriteString("CASE")
+		for _, rule := range rules {
+			cases.WriteString(" WHEN regexp_matches(message, ?) THEN ?")
+			args = append(args, rule.Pattern, rule.Category)
+		}
+		cases.WriteString(" ELSE ? END")
+	} else {
+		cases.WriteString("?")
+	}
+	args = append(args, CategoryOther, project)
+
+	_, err := db.Exec("UPDATE commits SET category = "+cases.String()+" WHERE project = ?", args...)
+
+	return err
+}
+
+type FileDefects struct {
+	Path          string  `json:"path"`
+	Sloc          int     `json:"sloc"`
+	Commits       int     `json:"commits"`
+	Bugfixes      int     `json:"bugfixes"`
+	DefectDensity float64 `json:"defectDensity"`
+}
+
+// GetDefectDensity returns the number of commits and bugfix commits that
+// touched each file present in the latest commit of project. DefectDensity
+// is the number of bugfixes per 1000 lines of code.
+func (db DB) GetDefectDensity(project string) ([]FileDefects, error) {
+	rows, err := db.Query(`
+	WITH`+latestFilesCTE+`,
+	changes AS (
+		SELECT
+			f.path,
+			COUNT(*) AS commits,
+			COUNT(*) FILTER (WHERE c.category = ?) AS bugfixes
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+		GROUP BY f.path
+	)
+	SELECT
+		l.path,
+		l.sloc,
+		COALESCE(ch.commits, 0) AS commits,
+		COALESCE(ch.bugfixes, 0) AS bugfixes,
+		CASE WHEN l.sloc > 0 THEN COALESCE(ch.bugfixes, 0) * 1000.0 / l.sloc ELSE 0 END AS defect_density
+	FROM latest_files l
+	LEFT JOIN changes ch ON ch.path = l.path
+	ORDER BY bugfixes DESC, defect_density DESC, l.path`, project, CategoryBugfix, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var defects []FileDefects
+	for rows.Next() {
+		var fd FileDefects
+		if err := rows.Scan(&fd.Path, &fd.Sloc, &fd.Commits, &fd.Bugfixes, &fd.DefectDensity); err != nil {
+			return nil, err
+		}
+		defects = append(defects, fd)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(defects) == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return defects, nil
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/boyter/scc/v3/processor"
+)
+
+// persistMessages stores a commit of project per message.
+func persistMessages(t *testing.T, db *DB, project string, messages ...string) []Commit {
+	t.Helper()
+
+	commits := testCommits(project, len(messages))
+	for i, message := range messages {
+		commits[i].Message = message
+	}
+	if err := db.PersistCommits(context.Background(), commits); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return commits
+}
+
+func category(t *testing.T, db *DB, hash string) string {
+	t.Helper()
+
+	var category string
+	if err := db.QueryRow("SELECT COALESCE(category, '') FROM commits WHERE hash = ?", hash).Scan(&category); err != nil {
+		t.Fatal(err)
+	}
+
+	return category
+}
+
+func TestClassifyCommits(t *testing.T) {
+	custom := []ClassificationRule{
+		{CategoryFeature, `(?i)\badd`},
+		{CategoryBugfix, `fix`},
+	}
+
+	tests := []struct {
+		name     string
+		rules    []ClassificationRule
+		message  string
+		category string
+	}{
+		{"bugfix", DefaultClassificationRules, "Fix crash on startup", CategoryBugfix},
+		{"feature", DefaultClassificationRules, "Add export to CSV", CategoryFeature},
+		{"refactor", DefaultClassificationRules, "Simplify the parser", CategoryRefactor},
+		{"conventional chore", DefaultClassificationRules, "ci: cache modules", CategoryChore},
+		{"conventional feature", DefaultClassificationRules, "feat(ui): dark mode", CategoryFeature},
+		{"merge before bugfix", DefaultClassificationRules, "Merge pull request #12 from o/fix-crash", CategoryChore},
+		{"bugfix before refactor", DefaultClassificationRules, "Refactor to fix the regression", CategoryBugfix},
+		{"bugfix before feature", DefaultClassificationRules, "Add missing nil check to fix crash", CategoryBugfix},
+		{"chore before feature", DefaultClassificationRules, "docs: add usage", CategoryChore},
+		{"upper case", DefaultClassificationRules, "HOTFIX LOGIN", CategoryBugfix},
+		{"case-sensitive merge", DefaultClassificationRules, "merge branch 'main'", CategoryOther},
+		{"no word boundary", DefaultClassificationRules, "Prefix the table names", CategoryOther},
+		{"default", DefaultClassificationRules, "Update dependencies", CategoryOther},
+		{"custom order", custom, "Add fix", CategoryFeature},
+		{"custom case-sensitive", custom, "Fix typo", CategoryOther},
+		{"custom case-insensitive", custom, "ADDRESS typo fix", CategoryFeature},
+		{"no rules", nil, "Fix crash", CategoryOther},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openTestDB(t)
+			commits := persistMessages(t, db, "p", test.message)
+			other := persistMessages(t, db, "q", test.message)
+
+			if err := db.ClassifyCommits("p", test.rules); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := category(t, db, commits[0].Hash); got != test.category {
+				t.Errorf("got category %q, want %q", got, test.category)
+			}
+			if got := category(t, db, other[0].Hash); got != "" {
+				t.Errorf("got category %q for another project, want it unclassified", got)
+			}
+		})
+	}
+}
+
+func TestClassifyCommitsValidatesRules(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, rules := range [][]ClassificationRule{
+		{{CategoryBugfix, `fix(`}},
+		{{"", `fix`}},
+	} {
+		if err := db.ClassifyCommits("p", rules); err == nil {
+			t.Errorf("got no error for rules %v", rules)
+		}
+	}
+}
+
+func TestGetDefectDensity(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	commits := persistMessages(t, db, "p", "Add main", "Fix crash in main", "Add util", "fix bug in util and main")
+
+	// Every commit lists all files, unchanged ones without added or
+	// deleted lines
+	changes := []map[string]int64{
+		{"main.go": 100},
+		{"main.go": 5},
+		{"util.go": 50},
+		{"main.go": 1, "util.go": 2},
+	}
+	sloc := map[string]int64{"main.go": 500, "util.go": 50, "empty.go": 0}
+	var filestates []FileState
+	for i, commit := range commits {
+		for path, code := range sloc {
+			filestates = append(filestates, FileState{
+				CommitHash: commit.Hash,
+				LinesAdded: changes[i][path],
+				FileJob:    &processor.FileJob{Filename: path, Language: "Go", Code: code},
+			})
+		}
+	}
+	if err := db.PersistFileStates(ctx, filestates, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ClassifyCommits("p", DefaultClassificationRules); err != nil {
+		t.Fatal(err)
+	}
+
+	defects, err := db.GetDefectDensity("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FileDefects{
+		{Path: "main.go", Sloc: 500, Commits: 3, Bugfixes: 2, DefectDensity: 4},
+		{Path: "util.go", Sloc: 50, Commits: 2, Bugfixes: 1, DefectDensity: 20},
+		{Path: "empty.go", Sloc: 0, Commits: 0, Bugfixes: 0, DefectDensity: 0},
+	}
+	if len(defects) != len(want) {
+		t.Fatalf("got %+v, want %+v", defects, want)
+	}
+	for i := range want {
+		if defects[i] != want[i] {
+			t.Errorf("got %+v, want %+v", defects[i], want[i])
+		}
+	}
+
+	if _, err := db.GetDefectDensity("q"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v for an unknown project, want %v", err, ErrProjectNotFound)
+	}
+}
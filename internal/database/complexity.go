@@ -0,0 +1,53 @@
+package database
+
+import "time"
+
+type ComplexityChange struct {
+	Path     string `json:"path"`
+	Language string `json:"language"`
+	Before   int    `json:"before"`
+	After    int    `json:"after"`
+}
+
+// GetComplexityIncreases returns the files of the latest commit of project
+// whose complexity increased since the last commit before since, ordered by
+// the increase. Files added after that commit aren't included.
+func (db DB) GetComplexityIncreases(project string, since time.Time) ([]ComplexityChange, error) {
+	rows, err := db.Query(`
+	WITH`+latestFilesCTE+`,
+	baseline AS (
+		SELECT f.path, f.complexity
+		FROM filestates f
+		WHERE f.commit_hash = (
+			SELECT hash
+			FROM commits
+			WHERE project = ? AND author_date < ?::TIMESTAMP
+			ORDER BY author_date DESC, id DESC
+			LIMIT 1
+		)
+	)
+	SELECT l.path, l.language, b.complexity, l.complexity
+	FROM latest_files l
+	JOIN baseline b ON b.path = l.path
+	WHERE l.complexity > b.complexity
+	ORDER BY l.complexity - b.complexity DESC, l.path`, project, project, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []ComplexityChange{}
+	for rows.Next() {
+		var c ComplexityChange
+		if err := rows.Scan(&c.Path, &c.Language, &c.Before, &c.After); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
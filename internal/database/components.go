@@ -0,0 +1,395 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ErrNoComponents = errors.New("no components defined for project")
+
+// Component maps the files matching any of Paths to a logical architecture
+// component. Paths are globs, where * matches within a path segment and **
+// across segments. A path without wildcards matches the file or directory
+// itself. Components are matched in order and the first match wins.
+type Component struct {
+	Name  string   `json:"name" yaml:"name"`
+	Paths []string `json:"paths" yaml:"paths"`
+}
+
+// LoadComponents reads component definitions from a YAML or JSON file of the
+// form {"components": [{"name": "Persistence", "paths": ["internal/database/**"]}]}.
+func LoadComponents(path string) ([]Component, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON, so this handles both formats
+	var definition struct {
+		Components []Component `yaml:"components"`
+	}
+	if err := yaml.UnmarshalStrict(content, &definition); err != nil {
+		return nil, err
+	}
+
+	for _, component := range definition.Components {
+		if component.Name == "" {
+			return nil, fmt.Errorf("component with paths %v has no name", component.Paths)
+		}
+	}
+
+	return definition.Components, nil
+}
+
+// globToRegex converts glob to an anchored regular expression. Only globs
+// without wildcards match the subtree below them, so "src/*" matches the
+// entries of src but not their children.
+func globToRegex(glob string) string {
+	var re strings.Builder
+	re.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			re.WriteString("[^/]*")
+		case glob[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	if !strings.ContainsAny(glob, "*?") {
+		re.WriteString("(/.*)?")
+	}
+	re.WriteString("$")
+
+	return re.String()
+}
+
+// SetComponents replaces the component definitions of project.
+func (db *DB) SetComponents(project string, components []Component) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM components WHERE project = ?", project); err != nil {
+		return err
+	}
+
+	position := 0
+	for _, component := range components {
+		for _, path := range component.Paths {
+			path = strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/")
+			if _, err := tx.Exec(
+				"INSERT INTO components VALUES (?, ?, ?, ?, ?)",
+				project, component.Name, path, globToRegex(path), position,
+			); err != nil {
+				return err
+			}
+			position++
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db DB) GetComponentDefinitions(project string) ([]Component, error) {
+	rows, err := db.Query("SELECT name, path_glob FROM components WHERE project = ? ORDER BY position", project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var components []Component
+	for rows.Next() {
+		var name, glob string
+		if err := rows.Scan(&name, &glob); err != nil {
+			return nil, err
+		}
+
+		i := slices.IndexFunc(components, func(c Component) bool { return c.Name == name })
+		if i == -1 {
+			components = append(components, Component{Name: name})
+			i = len(components) - 1
+		}
+		components[i].Paths = append(components[i].Paths, glob)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return components, nil
+}
+
+// fileComponentsCTE maps every path of a project to its component. It expects
+// the project as its only parameter.
+const fileComponentsCTE = `
+	file_components AS (
+		SELECT p.path, arg_min(cm.name, cm.position) AS component
+		FROM (
+			SELECT DISTINCT f.path, c.project
+			FROM filestates f
+			JOIN commits c ON c.hash = f.commit_hash
+			WHERE c.project = ?
+		) p
+		JOIN components cm ON cm.project = p.project AND regexp_matches(p.path, cm.pattern)
+		GROUP BY p.path
+	)`
+
+type ComponentData struct {
+	Component      string  `json:"component"`
+	Files          int     `json:"files"`
+	Sloc           int     `json:"sloc"`
+	Complexity     int     `json:"complexity"`
+	Commits        int     `json:"commits"`
+	Churn          int     `json:"churn"`
+	Contributors   int     `json:"contributors"`
+	MainAuthor     string  `json:"mainAuthor"`
+	OwnershipShare float64 `json:"ownershipShare"`
+}
+
+// GetComponents aggregates the latest size and complexity as well as the
+// churn and ownership over the whole history per component of project. The
+// main author is the contributor with the most churn in the component.
+func (db DB) GetComponents(project string) ([]ComponentData, error) {
+	if err := db.ensureComponents(project); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+	WITH`+fileComponentsCTE+`,`+latestFilesCTE+`,
+	latest AS (
+		SELECT fc.component, COUNT(*) AS files, SUM(lf.sloc) AS sloc, SUM(lf.complexity) AS complexity
+		FROM latest_files lf
+		JOIN file_components fc ON fc.path = lf.path
+		GROUP BY fc.component
+	), changes AS (
+		SELECT fc.component, c.contributor, c.hash, f.lines_added + f.lines_deleted AS churn
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		JOIN file_components fc ON fc.path = f.path
+		WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+	), authors AS (
+		SELECT component, contributor, SUM(churn) AS churn
+		FROM changes
+		GROUP BY component, contributor
+	), ownership AS (
+		SELECT
+			component,
+			arg_max(contributor, churn) AS main_author,
+			max(churn) / SUM(churn) AS ownership_share,
+			COUNT(*) AS contributors
+		FROM authors
+		GROUP BY component
+	), history AS (
+		SELECT component, COUNT(DISTINCT hash) AS commits, SUM(churn) AS churn
+		FROM changes
+		GROUP BY component
+	)
+	SELECT
+		l.component,
+		l.files,
+		l.sloc,
+		l.complexity,
+		COALESCE(h.commits, 0),
+		COALESCE(h.churn, 0),
+		COALESCE(o.contributors, 0),
+		COALESCE(o.main_author, ''),
+		COALESCE(o.ownership_share, 0)
+	FROM latest l
+	LEFT JOIN history h ON h.component = l.component
+	LEFT JOIN ownership o ON o.component = l.component
+	ORDER BY l.complexity DESC, l.component`, project, project, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var components []ComponentData
+	for rows.Next() {
+		var cd ComponentData
+		if err := rows.Scan(
+			&cd.Component,
+			&cd.Files,
+			&cd.Sloc,
+			&cd.Complexity,
+			&cd.Commits,
+			&cd.Churn,
+			&cd.Contributors,
+			&cd.MainAuthor,
+			&cd.OwnershipShare,
+		); err != nil {
+			return nil, err
+		}
+		components = append(components, cd)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return components, nil
+}
+
+type ComponentTrend struct {
+	Component  string `json:"component"`
+	Month      string `json:"month"`
+	Sloc       int    `json:"sloc"`
+	Complexity int    `json:"complexity"`
+	Churn      int    `json:"churn"`
+	Commits    int    `json:"commits"`
+}
+
+// GetComponentTrends returns the size and complexity at the last commit of
+// each month together with the churn within that month per component.
+func (db DB) GetComponentTrends(project string) ([]ComponentTrend, error) {
+	if err := db.ensureComponents(project); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+	WITH`+fileComponentsCTE+`,
+	monthly AS (
+		SELECT
+			date_trunc('month', c.author_date) AS month,
+			arg_max(c.hash, (c.author_date, c.id)) AS last_hash
+		FROM commits c
+		WHERE c.project = ?
+		GROUP BY month
+	), size AS (
+		SELECT m.month, fc.component, SUM(f.sloc) AS sloc, SUM(f.complexity) AS complexity
+		FROM monthly m
+		JOIN filestates f ON f.commit_hash = m.last_hash
+		JOIN file_components fc ON fc.path = f.path
+		GROUP BY m.month, fc.component
+	), changes AS (
+		SELECT
+			date_trunc('month', c.author_date) AS month,
+			fc.component,
+			SUM(f.lines_added + f.lines_deleted) AS churn,
+			COUNT(DISTINCT c.hash) AS commits
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		JOIN file_components fc ON fc.path = f.path
+		WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+		GROUP BY month, fc.component
+	)
+	SELECT
+		s.component,
+		strftime(s.month, '%Y-%m'),
+		s.sloc,
+		s.complexity,
+		COALESCE(ch.churn, 0),
+		COALESCE(ch.commits, 0)
+	FROM size s
+	LEFT JOIN changes ch ON ch.month = s.month AND ch.component = s.component
+	ORDER BY s.component, s.month`, project, project, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trends []ComponentTrend
+	for rows.Next() {
+		var ct ComponentTrend
+		if err := rows.Scan(&ct.Component, &ct.Month, &ct.Sloc, &ct.Complexity, &ct.Churn, &ct.Commits); err != nil {
+			return nil, err
+		}
+		trends = append(trends, ct)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return trends, nil
+}
+
+type ComponentCoupling struct {
+	Component     string  `json:"component"`
+	Coupled       string  `json:"coupled"`
+	SharedCommits int     `json:"sharedCommits"`
+	Degree        float64 `json:"degree"`
+}
+
+// GetComponentCoupling returns pairs of components that changed in the same
+// commits. Degree is the number of shared commits divided by the average
+// number of commits of both components.
+func (db DB) GetComponentCoupling(project string) ([]ComponentCoupling, error) {
+	if err := db.ensureComponents(project); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+	WITH`+fileComponentsCTE+`,
+	changes AS (
+		SELECT DISTINCT c.hash, fc.component
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		JOIN file_components fc ON fc.path = f.path
+		WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+	), commits_per_component AS (
+		SELECT component, COUNT(*) AS commits
+		FROM changes
+		GROUP BY component
+	)
+	SELECT
+		a.component,
+		b.component,
+		COUNT(*) AS shared_commits,
+		COUNT(*) / ((ca.commits + cb.commits) / 2) AS degree
+	FROM changes a
+	JOIN changes b ON a.hash = b.hash AND a.component < b.component
+	JOIN commits_per_component ca ON ca.component = a.component
+	JOIN commits_per_component cb ON cb.component = b.component
+	GROUP BY a.component, b.component, ca.commits, cb.commits
+	ORDER BY degree DESC, shared_commits DESC`, project, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var coupling []ComponentCoupling
+	for rows.Next() {
+		var cc ComponentCoupling
+		if err := rows.Scan(&cc.Component, &cc.Coupled, &cc.SharedCommits, &cc.Degree); err != nil {
+			return nil, err
+		}
+		coupling = append(coupling, cc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return coupling, nil
+}
+
+func (db DB) ensureComponents(project string) error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM components WHERE project = ?", project).Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrNoComponents
+	}
+
+	return nil
+}
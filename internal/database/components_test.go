@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/boyter/scc/v3/processor"
+)
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"src", "src", true},
+		{"src", "src/main.go", true},
+		{"src", "src/a/main.go", true},
+		{"src", "srcs/main.go", false},
+		{"src/main.go", "src/main.go", true},
+		{"src/main.go", "src/main.go.orig", false},
+		{"src/*", "src/main.go", true},
+		{"src/*", "src/a/main.go", false},
+		{"src/*", "src", false},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/a/main.go", false},
+		{"src/*.go", "src/main.gox", false},
+		{"src/**", "src/main.go", true},
+		{"src/**", "src/a/b/main.go", true},
+		{"src/**", "lib/main.go", false},
+		{"**/*_test.go", "main_test.go", true},
+		{"**/*_test.go", "a/b/main_test.go", true},
+		{"**/*_test.go", "a/b/main.go", false},
+		{"src/**/db", "src/db", true},
+		{"src/**/db", "src/a/b/db", true},
+		{"src/**/db", "src/a/b/db/main.go", false},
+		{"src/?.go", "src/a.go", true},
+		{"src/?.go", "src/ab.go", false},
+		{"src/?.go", "src//.go", false},
+		{"a.b", "axb", false},
+		{"a+b/(c)", "a+b/(c)/d.go", true},
+	}
+
+	for _, test := range tests {
+		re := regexp.MustCompile(globToRegex(test.glob))
+		if got := re.MatchString(test.path); got != test.match {
+			t.Errorf("%q matches %q: got %v, want %v (regex %s)", test.glob, test.path, got, test.match, re)
+		}
+	}
+}
+
+// seedComponents stores two commits of project "p". Alice adds all files,
+// then Bob changes the test and the server.
+func seedComponents(t *testing.T, db *DB) {
+	t.Helper()
+	ctx := context.Background()
+
+	commits := testCommits("p", 2)
+	commits[1].Author = "Bob"
+	if err := db.PersistCommits(ctx, commits); err != nil {
+		t.Fatal(err)
+	}
+
+	type file struct {
+		path             string
+		code, complexity int64
+		added            [2]int64
+	}
+	files := []file{
+		{"internal/database/db.go", 100, 10, [2]int64{100, 0}},
+		{"internal/database/db_test.go", 60, 2, [2]int64{50, 10}},
+		{"internal/server/server.go", 100, 8, [2]int64{80, 20}},
+		{"README.md", 10, 0, [2]int64{10, 0}},
+	}
+
+	var filestates []FileState
+	for i, commit := range commits {
+		for _, f := range files {
+			filestates = append(filestates, FileState{
+				CommitHash: commit.Hash,
+				LinesAdded: f.added[i],
+				FileJob:    &processor.FileJob{Filename: f.path, Language: "Go", Code: f.code, Complexity: f.complexity},
+			})
+		}
+	}
+	if err := db.PersistFileStates(ctx, filestates, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetComponents(t *testing.T) {
+	db := openTestDB(t)
+	seedComponents(t, db)
+
+	if _, err := db.GetComponents("p"); !errors.Is(err, ErrNoComponents) {
+		t.Fatalf("got error %v without components, want %v", err, ErrNoComponents)
+	}
+
+	tests := []struct {
+		name       string
+		components []Component
+		want       []ComponentData
+	}{
+		{
+			// db_test.go matches Tests and Persistence, the first match wins
+			"tests first",
+			[]Component{
+				{Name: "Tests", Paths: []string{"**/*_test.go"}},
+				{Name: "Persistence", Paths: []string{"internal/database"}},
+				{Name: "Server", Paths: []string{"internal/server/**"}},
+			},
+			[]ComponentData{
+				{Component: "Persistence", Files: 1, Sloc: 100, Complexity: 10, Commits: 1, Churn: 100, Contributors: 1, MainAuthor: "Alice", OwnershipShare: 1},
+				{Component: "Server", Files: 1, Sloc: 100, Complexity: 8, Commits: 2, Churn: 100, Contributors: 2, MainAuthor: "Alice", OwnershipShare: 0.8},
+				{Component: "Tests", Files: 1, Sloc: 60, Complexity: 2, Commits: 2, Churn: 60, Contributors: 2, MainAuthor: "Alice", OwnershipShare: 50.0 / 60},
+			},
+		},
+		{
+			"persistence first",
+			[]Component{
+				{Name: "Persistence", Paths: []string{"/internal/database/"}},
+				{Name: "Tests", Paths: []string{"**/*_test.go"}},
+				{Name: "Server", Paths: []string{"internal/server/*.go", "cmd/server"}},
+			},
+			[]ComponentData{
+				{Component: "Persistence", Files: 2, Sloc: 160, Complexity: 12, Commits: 2, Churn: 160, Contributors: 2, MainAuthor: "Alice", OwnershipShare: 150.0 / 160},
+				{Component: "Server", Files: 1, Sloc: 100, Complexity: 8, Commits: 2, Churn: 100, Contributors: 2, MainAuthor: "Alice", OwnershipShare: 0.8},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Each definition replaces the one before
+			if err := db.SetComponents("p", test.components); err != nil {
+				t.Fatal(err)
+			}
+
+			definitions, err := db.GetComponentDefinitions("p")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(definitions) != len(test.components) {
+				t.Errorf("got definitions %v, want %v", definitions, test.components)
+			}
+
+			components, err := db.GetComponents("p")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(components) != len(test.want) {
+				t.Fatalf("got %+v, want %+v", components, test.want)
+			}
+			for i, want := range test.want {
+				got := components[i]
+				if math.Abs(got.OwnershipShare-want.OwnershipShare) < 1e-9 {
+					got.OwnershipShare = want.OwnershipShare
+				}
+				if got != want {
+					t.Errorf("got %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+
+	// Paths are stored without leading and trailing slashes
+	definitions, err := db.GetComponentDefinitions("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(definitions); got != "[{Persistence [internal/database]} {Tests [**/*_test.go]} {Server [internal/server/*.go cmd/server]}]" {
+		t.Errorf("got definitions %s", got)
+	}
+
+	// Components are defined per project
+	if _, err := db.GetComponents("q"); !errors.Is(err, ErrNoComponents) {
+		t.Errorf("got error %v for another project, want %v", err, ErrNoComponents)
+	}
+}
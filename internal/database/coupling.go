@@ -0,0 +1,68 @@
+package database
+
+type FileCoupling struct {
+	Path          string  `json:"path"`
+	Coupled       string  `json:"coupled"`
+	SharedCommits int     `json:"sharedCommits"`
+	Degree        float64 `json:"degree"`
+}
+
+// GetFileCoupling returns pairs of files of the latest commit of project that
+// changed in the same commits. Degree is the number of shared commits divided
+// by the average number of commits of both files. Commits touching more than
+// MaxChangesetSize files are ignored.
+func (db DB) GetFileCoupling(project string) ([]FileCoupling, error) {
+	rows, err := db.Query(`
+	WITH`+latestFilesCTE+`,
+	file_changes AS (
+		SELECT f.path, c.hash
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+	), changes AS (
+		SELECT fc.hash, fc.path
+		FROM file_changes fc
+		JOIN latest_files lf ON lf.path = fc.path
+		WHERE fc.hash IN (
+			SELECT hash
+			FROM file_changes
+			GROUP BY hash
+			HAVING COUNT(*) <= ?
+		)
+	), commits_per_file AS (
+		SELECT path, COUNT(*) AS commits
+		FROM changes
+		GROUP BY path
+	)
+	SELECT
+		a.path,
+		b.path,
+		COUNT(*) AS shared_commits,
+		COUNT(*) / ((ca.commits + cb.commits) / 2) AS degree
+	FROM changes a
+	JOIN changes b ON a.hash = b.hash AND a.path < b.path
+	JOIN commits_per_file ca ON ca.path = a.path
+	JOIN commits_per_file cb ON cb.path = b.path
+	GROUP BY a.path, b.path, ca.commits, cb.commits
+	HAVING COUNT(*) >= ?
+	ORDER BY degree DESC, shared_commits DESC, a.path, b.path`, project, project, MaxChangesetSize, MinSharedCommits)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	coupling := []FileCoupling{}
+	for rows.Next() {
+		var fc FileCoupling
+		if err := rows.Scan(&fc.Path, &fc.Coupled, &fc.SharedCommits, &fc.Degree); err != nil {
+			return nil, err
+		}
+		coupling = append(coupling, fc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return coupling, nil
+}
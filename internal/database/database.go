@@ -6,14 +6,21 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"os"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/boyter/scc/v3/processor"
 	"github.com/marcboeker/go-duckdb/v2"
+
+	"github.com/tim-hilt/codescene/internal/metrics"
 )
 
-var ErrProjectNotFound = errors.New("project not found")
+var (
+	ErrProjectNotFound = errors.New("project not found")
+	ErrCommitNotFound  = errors.New("commit not found")
+)
 
 type FileState struct {
 	CommitHash   string
@@ -36,6 +43,11 @@ type DB struct {
 	filestatesAppender *duckdb.Appender
 	commitsAppender    *duckdb.Appender
 	driver.Conn
+
+	// appenderMu serializes access to the appenders, which are shared by all
+	// concurrently running analyses
+	appenderMu   *sync.Mutex
+	nextCommitID int32
 }
 
 func (db *DB) Close() error {
@@ -58,8 +70,11 @@ func (db *DB) Close() error {
 	return nil
 }
 
+// File is the path of the database.
+const File = "codescene.db"
+
 func Init() (*DB, error) {
-	c, err := duckdb.NewConnector("codescene.db", nil)
+	c, err := duckdb.NewConnector(File, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +95,8 @@ func Init() (*DB, error) {
 					author_date TIMESTAMP_S NOT NULL,
 					project TEXT NOT NULL,
 					message TEXT NOT NULL,
+					category TEXT,
+					utc_offset_minutes INTEGER,
 				);
 				CREATE TABLE IF NOT EXISTS filestates (
 					commit_hash TEXT NOT NULL REFERENCES commits(hash),
@@ -92,12 +109,57 @@ func Init() (*DB, error) {
 					complexity INTEGER NOT NULL,
 					lines_added INTEGER NOT NULL,
 					lines_deleted INTEGER NOT NULL,
+				);
+				CREATE TABLE IF NOT EXISTS commit_issues (
+					commit_hash TEXT NOT NULL REFERENCES commits(hash),
+					issue TEXT NOT NULL,
+				);
+				CREATE TABLE IF NOT EXISTS issues (
+					project TEXT NOT NULL,
+					key TEXT NOT NULL,
+					type TEXT,
+					estimate DOUBLE,
+					PRIMARY KEY (project, key),
+				);
+				CREATE TABLE IF NOT EXISTS components (
+					project TEXT NOT NULL,
+					name TEXT NOT NULL,
+					path_glob TEXT NOT NULL,
+					pattern TEXT NOT NULL,
+					position INTEGER NOT NULL,
+				);
+				CREATE TABLE IF NOT EXISTS team_members (
+					contributor TEXT PRIMARY KEY,
+					team TEXT NOT NULL,
+				);
+				CREATE TABLE IF NOT EXISTS analysis_runs (
+					id TEXT PRIMARY KEY,
+					project TEXT NOT NULL,
+					trigger TEXT NOT NULL,
+					status TEXT NOT NULL,
+					error TEXT NOT NULL,
+					started_at TIMESTAMP NOT NULL,
+					finished_at TIMESTAMP NOT NULL,
 				);`
 
 	if _, err = db.Exec(createTablesStmt); err != nil {
 		return nil, err
 	}
 
+	// Columns added after the initial schema are appended to existing tables,
+	// so that the column order expected by the appenders stays the same.
+	alterTablesStmt := `
+				ALTER TABLE commits ADD COLUMN IF NOT EXISTS category TEXT;
+				ALTER TABLE commits ADD COLUMN IF NOT EXISTS utc_offset_minutes INTEGER;`
+
+	if _, err = db.Exec(alterTablesStmt); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.Exec(createViewsStmt); err != nil {
+		return nil, err
+	}
+
 	filestatesAppender, err := duckdb.NewAppenderFromConn(con, "", "filestates")
 	if err != nil {
 		return nil, err
@@ -108,25 +170,84 @@ func Init() (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{db, filestatesAppender, commitsAppender, con}, nil
+	var nextCommitID int32
+	if err := db.QueryRow("SELECT COALESCE(MAX(id) + 1, 0) FROM commits").Scan(&nextCommitID); err != nil {
+		return nil, err
+	}
+
+	return &DB{db, filestatesAppender, commitsAppender, con, &sync.Mutex{}, nextCommitID}, nil
 }
 
+// Clean removes the commits of repo together with their filestates and
+// issues, so repo can be analyzed from scratch.
 func (db *DB) Clean(repo string) error {
+	if err := db.deleteCommits(repo, -1); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM issues WHERE project = ?", repo); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LastCommitID returns the highest id of the commits of repo, or -1 if repo
+// has no commits.
+func (db DB) LastCommitID(repo string) (int32, error) {
+	var id int32
+	err := db.QueryRow("SELECT COALESCE(MAX(id), -1) FROM commits WHERE project = ?", repo).Scan(&id)
+	return id, err
+}
+
+// DeleteCommitsAfter removes the commits of repo with an id greater than id
+// together with their filestates and issues. Rows still buffered in the
+// appenders are flushed first, so none of them is written afterwards.
+func (db *DB) DeleteCommitsAfter(repo string, id int32) error {
+	db.appenderMu.Lock()
+	defer db.appenderMu.Unlock()
+
+	// Filestates reference commits, so the commits are flushed first
+	if err := db.commitsAppender.Flush(); err != nil {
+		return err
+	}
+
+	if err := db.filestatesAppender.Flush(); err != nil {
+		return err
+	}
+
+	return db.deleteCommits(repo, id)
+}
+
+// deleteCommits doesn't use a transaction, as DuckDB rejects deleting rows
+// that are referenced by rows deleted in the same transaction.
+func (db *DB) deleteCommits(repo string, id int32) error {
 	deleteFileStatesStmt := `
     DELETE FROM filestates
     WHERE commit_hash IN (
         SELECT hash
         FROM commits
-        WHERE project = ?
+        WHERE project = ? AND id > ?
+    );`
+	if _, err := db.Exec(deleteFileStatesStmt, repo, id); err != nil {
+		return err
+	}
+
+	deleteCommitIssuesStmt := `
+    DELETE FROM commit_issues
+    WHERE commit_hash IN (
+        SELECT hash
+        FROM commits
+        WHERE project = ? AND id > ?
     );`
-	if _, err := db.Exec(deleteFileStatesStmt, repo); err != nil {
+	if _, err := db.Exec(deleteCommitIssuesStmt, repo, id); err != nil {
 		return err
 	}
 
 	deleteCommitsStmt := `
     DELETE FROM commits
-    WHERE project = ?;`
-	if _, err := db.Exec(deleteCommitsStmt, repo); err != nil {
+    WHERE project = ? AND id > ?;`
+	if _, err := db.Exec(deleteCommitsStmt, repo, id); err != nil {
 		return err
 	}
 
@@ -190,7 +311,7 @@ type ProjectMetadata struct {
 	CommitFrequency []CommitFrequency `json:"commitFrequency"`
 }
 
-func (db DB) GetProjectMetadata(project string) (ProjectMetadata, error) {
+func (db DB) GetProjectMetadata(project string, granularity Granularity, timezone string) (ProjectMetadata, error) {
 	rows, err := db.Query(`
 	SELECT 
 		c.author_date,
@@ -249,23 +370,9 @@ func (db DB) GetProjectMetadata(project string) (ProjectMetadata, error) {
 		return ProjectMetadata{}, err
 	}
 
-	var commitFrequency []CommitFrequency
-	firstCommit, err := time.Parse(time.RFC3339, commitData[0].CommitDate)
+	commitFrequency, err := db.GetCommitFrequency(project, granularity, timezone)
 	if err != nil {
-		return ProjectMetadata{}, nil
-	}
-	firstCommit = firstCommit.Truncate(24 * time.Hour)
-	today := time.Now().Truncate(24 * time.Hour)
-	for d := firstCommit; !d.After(today); d = d.AddDate(0, 0, 1) {
-		commits, err := countTimestampsOnDay(commitData, d)
-		if err != nil {
-			return ProjectMetadata{}, err
-		}
-		cf := CommitFrequency{
-			Day:     d.Format(time.RFC3339),
-			Commits: commits,
-		}
-		commitFrequency = append(commitFrequency, cf)
+		return ProjectMetadata{}, err
 	}
 
 	return ProjectMetadata{
@@ -275,72 +382,118 @@ func (db DB) GetProjectMetadata(project string) (ProjectMetadata, error) {
 	}, nil
 }
 
-func (db *DB) PersistCommits(commits chan Commit, errs chan error) {
-	var id int32 = 0 // TODO: Infer id from db table
+func (db *DB) PersistCommits(ctx context.Context, commits []Commit) error {
+	for _, commit := range commits {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-	for commit := range commits {
 		date, err := time.Parse(time.RFC3339, commit.Date)
 		if err != nil {
-			errs <- err
-			return
+			return err
 		}
-		if err = db.commitsAppender.AppendRow(id, commit.Hash, commit.Author, date, commit.Project, commit.Message); err != nil {
-			errs <- err
-			return
+		_, offset := date.Zone()
+
+		db.appenderMu.Lock()
+		err = db.commitsAppender.AppendRow(db.nextCommitID, commit.Hash, commit.Author, date, commit.Project, commit.Message, nil, int32(offset/60))
+		if err == nil {
+			db.nextCommitID++
 		}
+		db.appenderMu.Unlock()
 
-		id++
+		if err != nil {
+			return err
+		}
 	}
 
-	if err := db.commitsAppender.Flush(); err != nil {
-		errs <- err
-		return
-	}
+	db.appenderMu.Lock()
+	defer db.appenderMu.Unlock()
+
+	return db.commitsAppender.Flush()
 }
 
-func (db *DB) PersistFileStates(filestates chan FileState, numFilestates int, filestateProcessedCallback func(curr, total int), errs chan error) {
-	var (
-		err error
-		i   int
-	)
+// PersistFileStates appends filestates. progress is called after each
+// filestate.
+func (db *DB) PersistFileStates(ctx context.Context, filestates []FileState, progress func(curr, total int)) error {
+	for i, filestate := range filestates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-	for filestate := range filestates {
-		err = db.filestatesAppender.AppendRow(
-			filestate.CommitHash,
-			filestate.Filename,
-			filestate.RenameFrom,
-			filestate.Language,
-			int32(filestate.Code),
-			int32(filestate.Comment),
-			int32(filestate.Blank),
-			int32(filestate.Complexity),
-			int32(filestate.LinesAdded),
-			int32(filestate.LinesDeleted),
-		)
-		if err != nil {
-			errs <- err
-			return
+		if err := db.appendFilestate(filestate.CommitHash, filestate); err != nil {
+			return err
 		}
-		i++
-		filestateProcessedCallback(i, numFilestates)
+		progress(i+1, len(filestates))
 	}
+
+	return nil
+}
+
+func (db *DB) appendFilestate(hash string, filestate FileState) error {
+	db.appenderMu.Lock()
+	defer db.appenderMu.Unlock()
+
+	return db.filestatesAppender.AppendRow(
+		hash,
+		filestate.Filename,
+		filestate.RenameFrom,
+		filestate.Language,
+		int32(filestate.Code),
+		int32(filestate.Comment),
+		int32(filestate.Blank),
+		int32(filestate.Complexity),
+		int32(filestate.LinesAdded),
+		int32(filestate.LinesDeleted),
+	)
 }
 
 func (db *DB) Flush() error {
+	db.appenderMu.Lock()
+	defer db.appenderMu.Unlock()
+
 	if err := db.filestatesAppender.Flush(); err != nil {
 		return err
 	}
 
 	return nil
 }
-func (db *DB) FillFilestates(repo string, removedFiles map[string][]string) error {
+
+// FillFilestates carries the state of files that weren't touched by one of
+// commits over from its predecessor. Commits analyzed before are already
+// complete. progress is called after each of commits.
+func (db *DB) FillFilestates(ctx context.Context, repo string, commits []Commit, removedFiles map[string][]string, progress func(curr, total int)) error {
 	hashes, err := db.GetCommitHashes(repo)
 	if err != nil {
 		return err
 	}
 
-	var filestatesLastCommit []FileState
+	isNew := make(map[string]bool, len(commits))
+	for _, c := range commits {
+		isNew[c.Hash] = true
+	}
+
+	var (
+		filestatesLastCommit []FileState
+		lastKnownHash        string
+		filled               int
+	)
 	for _, hash := range hashes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !isNew[hash] {
+			lastKnownHash = hash
+			continue
+		}
+
+		if filled == 0 && lastKnownHash != "" {
+			filestatesLastCommit, err = db.GetFilestatesWithHash(lastKnownHash)
+			if err != nil {
+				return err
+			}
+		}
+
 		filestatesCurrentCommit, err := db.GetFilestatesWithHash(hash)
 		if err != nil {
 			return err
@@ -365,30 +518,45 @@ func (db *DB) FillFilestates(repo string, removedFiles map[string][]string) erro
 				}
 			}
 
-			if err := db.filestatesAppender.AppendRow(
-				hash,
-				filestateLastCommit.Filename,
-				filestateLastCommit.RenameFrom,
-				filestateLastCommit.Language,
-				int32(filestateLastCommit.Code),
-				int32(filestateLastCommit.Comment),
-				int32(filestateLastCommit.Blank),
-				int32(filestateLastCommit.Complexity),
-				int32(filestateLastCommit.LinesAdded),
-				int32(filestateLastCommit.LinesDeleted),
-			); err != nil {
+			// The file wasn't touched in this commit, so no lines changed
+			filestateLastCommit.LinesAdded = 0
+			filestateLastCommit.LinesDeleted = 0
+
+			if err := db.appendFilestate(hash, filestateLastCommit); err != nil {
 				return err
 			}
 
 			relevantFilestatesLastCommit = append(relevantFilestatesLastCommit, filestateLastCommit)
 		}
 
+		metrics.CacheHits.WithLabelValues("filestate").Add(float64(len(relevantFilestatesLastCommit)))
+
 		filestatesLastCommit = append(filestatesCurrentCommit, relevantFilestatesLastCommit...)
+		filled++
+		progress(filled, len(commits))
 	}
 
 	return db.Flush()
 }
 
+// Size returns the size of the database file and its write-ahead log in
+// bytes.
+func (db DB) Size() (int64, error) {
+	var size int64
+	for _, file := range []string{File, File + ".wal"} {
+		info, err := os.Stat(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
 func (db DB) GetCommitHashes(repo string) ([]string, error) {
 	query := "SELECT hash from commits WHERE project = ? ORDER BY id"
 	rows, err := db.Query(query, repo)
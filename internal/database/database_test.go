@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// openTestDB opens a new database in a temporary working directory.
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	db, err := Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func testCommits(project string, n int) []Commit {
+	commits := make([]Commit, n)
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := range commits {
+		commits[i] = Commit{
+			Hash:    fmt.Sprintf("%s-%d", project, i),
+			Author:  "Alice",
+			Message: "Change",
+			Date:    start.AddDate(0, 0, i).Format(time.RFC3339),
+			Project: project,
+		}
+	}
+	return commits
+}
+
+func TestPersistCommitsContinuesIDs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	ctx := context.Background()
+
+	db, err := Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PersistCommits(ctx, testCommits("a", 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process starts counting from the stored commits
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.PersistCommits(ctx, testCommits("b", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PersistCommits(ctx, testCommits("a", 5)[3:]); err != nil {
+		t.Fatal(err)
+	}
+
+	var commits, ids int
+	if err := db.QueryRow("SELECT COUNT(*), COUNT(DISTINCT id) FROM commits").Scan(&commits, &ids); err != nil {
+		t.Fatal(err)
+	}
+	if commits != 7 || ids != 7 {
+		t.Errorf("got %d commits with %d distinct ids, want 7", commits, ids)
+	}
+
+	hashes, err := db.GetCommitHashes("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a-0", "a-1", "a-2", "a-3", "a-4"}
+	if fmt.Sprint(hashes) != fmt.Sprint(want) {
+		t.Errorf("got hashes %v, want %v", hashes, want)
+	}
+}
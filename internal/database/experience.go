@@ -0,0 +1,42 @@
+package database
+
+import "time"
+
+type FileExperience struct {
+	Path        string `json:"path"`
+	Contributor string `json:"contributor"`
+	Commits     int    `json:"commits"`
+}
+
+// GetFileExperience returns how many commits of project before the time
+// before each contributor made to each file.
+func (db DB) GetFileExperience(project string, before time.Time) ([]FileExperience, error) {
+	rows, err := db.Query(`
+	SELECT f.path, c.contributor, COUNT(*)
+	FROM filestates f
+	JOIN commits c ON c.hash = f.commit_hash
+	WHERE c.project = ?
+		AND c.author_date < ?::TIMESTAMP
+		AND f.lines_added + f.lines_deleted > 0
+	GROUP BY f.path, c.contributor
+	ORDER BY f.path, c.contributor`, project, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	experience := []FileExperience{}
+	for rows.Next() {
+		var e FileExperience
+		if err := rows.Scan(&e.Path, &e.Contributor, &e.Commits); err != nil {
+			return nil, err
+		}
+		experience = append(experience, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return experience, nil
+}
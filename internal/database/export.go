@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportFormat is the file format of exported datasets.
+type ExportFormat string
+
+const (
+	Parquet ExportFormat = "parquet"
+	CSV     ExportFormat = "csv"
+	JSONL   ExportFormat = "jsonl"
+)
+
+var ErrExportFormat = errors.New("invalid export format, use parquet, csv or jsonl")
+
+func (f ExportFormat) Validate() error {
+	switch f {
+	case Parquet, CSV, JSONL:
+		return nil
+	default:
+		return ErrExportFormat
+	}
+}
+
+// copyOptions are the options of COPY ... TO writing f.
+func (f ExportFormat) copyOptions() string {
+	switch f {
+	case CSV:
+		return "FORMAT csv, HEADER"
+	case JSONL:
+		return "FORMAT json"
+	default:
+		return "FORMAT parquet"
+	}
+}
+
+// exportDatasets are the datasets written by Export. Each query expects the
+// project as its only parameter. Commits are written in the order they were
+// analyzed, so imports can restore it.
+var exportDatasets = []struct {
+	name  string
+	query string
+}{
+	{"commits", `
+		SELECT hash, contributor, author_date, project, message, category, utc_offset_minutes
+		FROM commits
+		WHERE project = ?
+		ORDER BY id`},
+	{"filestates", `
+		SELECT f.*
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		WHERE c.project = ?
+		ORDER BY c.id, f.path`},
+	{"commit_issues", `
+		SELECT i.*
+		FROM commit_issues i
+		JOIN commits c ON c.hash = i.commit_hash
+		WHERE c.project = ?
+		ORDER BY c.id, i.issue`},
+	{"issues", `
+		SELECT *
+		FROM issues
+		WHERE project = ?
+		ORDER BY key`},
+	{"hotspots", `
+		SELECT *
+		FROM v_hotspots
+		WHERE project = ?
+		ORDER BY score DESC, path`},
+	{"trends", `
+		SELECT
+			c.hash AS commit_hash,
+			c.author_date,
+			SUM(f.sloc) AS sloc,
+			SUM(f.cloc) AS comments,
+			SUM(f.complexity) AS complexity,
+			COUNT(f.path) AS files
+		FROM commits c
+		LEFT JOIN filestates f ON f.commit_hash = c.hash
+		WHERE c.project = ?
+		GROUP BY c.id, c.hash, c.author_date
+		ORDER BY c.id`},
+}
+
+// Export writes the commits, filestates and issues of project together with
+// its hotspots and the size and complexity after each commit to one file per
+// dataset in dir. It returns the paths of the written files.
+func (db DB) Export(ctx context.Context, project string, format ExportFormat, dir string) ([]string, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.GetProject(project); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(exportDatasets))
+	for _, dataset := range exportDatasets {
+		file := filepath.Join(dir, dataset.name+"."+string(format))
+
+		// The target of COPY can't be a parameter
+		stmt := fmt.Sprintf("COPY (%s) TO %s (%s)", dataset.query, quoteLiteral(file), format.copyOptions())
+		if _, err := db.ExecContext(ctx, stmt, project); err != nil {
+			return nil, fmt.Errorf("exporting %s: %w", dataset.name, err)
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
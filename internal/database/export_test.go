@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	readers := map[ExportFormat]string{Parquet: "read_parquet", CSV: "read_csv", JSONL: "read_json"}
+
+	for _, format := range []ExportFormat{Parquet, CSV, JSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			db := openTestDB(t)
+			seedProject(t, db, "p", 3)
+			seedProject(t, db, "q", 1)
+			for _, project := range []string{"p", "q"} {
+				if err := db.ImportIssues(project, []Issue{{Key: "PROJ-1", Type: "Bug"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The target of COPY is quoted
+			dir := filepath.Join(t.TempDir(), "it's")
+			files, err := db.Export(context.Background(), "p", format, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the datasets of p are exported, in the order they were
+			// analyzed
+			want := []struct {
+				dataset, first string
+				rows           int
+			}{
+				{"commits", "hash", 3},
+				{"filestates", "commit_hash", 6},
+				{"commit_issues", "commit_hash", 1},
+				{"issues", "key", 1},
+				{"hotspots", "path", 2},
+				{"trends", "commit_hash", 3},
+			}
+			if len(files) != len(want) {
+				t.Fatalf("got files %v, want %d", files, len(want))
+			}
+			for i, w := range want {
+				if files[i] != filepath.Join(dir, w.dataset+"."+string(format)) {
+					t.Errorf("got file %s, want %s.%s in %s", files[i], w.dataset, format, dir)
+				}
+
+				var rows int
+				var first string
+				err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*), first(%s) FROM %s(%s)", w.first, readers[format], quoteLiteral(files[i]))).Scan(&rows, &first)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rows != w.rows {
+					t.Errorf("got %d rows in %s, want %d", rows, w.dataset, w.rows)
+				}
+				if w.dataset == "commits" && first != "p-0" {
+					t.Errorf("got first commit %s, want p-0", first)
+				}
+			}
+
+			// Derived metrics are computed per commit
+			var sloc, complexity, filesTouched int
+			err = db.QueryRow(fmt.Sprintf("SELECT sloc, complexity, files FROM %s(%s) WHERE commit_hash = 'p-2'", readers[format], quoteLiteral(files[5]))).
+				Scan(&sloc, &complexity, &filesTouched)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sloc != 60 || complexity != 4 || filesTouched != 2 {
+				t.Errorf("got sloc %d, complexity %d and %d files after p-2, want 60, 4 and 2", sloc, complexity, filesTouched)
+			}
+
+			content, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, _, _ := strings.Cut(string(content), "\n")
+			switch format {
+			case CSV:
+				if want := "hash,contributor,author_date,project,message,category,utc_offset_minutes"; first != want {
+					t.Errorf("got header %q, want %q", first, want)
+				}
+			case JSONL:
+				var commit map[string]any
+				if err := json.Unmarshal([]byte(first), &commit); err != nil || commit["hash"] != "p-0" || commit["message"] != "Fix PROJ-1" {
+					t.Errorf("got first line %s and error %v, want the first commit", first, err)
+				}
+			case Parquet:
+				if !strings.HasPrefix(string(content), "PAR1") {
+					t.Errorf("got file starting with %q, want a Parquet file", content[:min(len(content), 4)])
+				}
+			}
+		})
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	db := openTestDB(t)
+	seedProject(t, db, "p", 1)
+
+	tests := []struct {
+		project string
+		format  ExportFormat
+		err     error
+	}{
+		{"p", "xlsx", ErrExportFormat},
+		{"p", "", ErrExportFormat},
+		{"q", CSV, ErrProjectNotFound},
+	}
+
+	for _, test := range tests {
+		dir := filepath.Join(t.TempDir(), "export")
+		if _, err := db.Export(context.Background(), test.project, test.format, dir); !errors.Is(err, test.err) {
+			t.Errorf("%s as %q: got error %v, want %v", test.project, test.format, err, test.err)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s as %q: got directory %s, want nothing written", test.project, test.format, dir)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrImportConflict = errors.New("conflicting commits")
+	ErrNoDump         = errors.New("no commits.parquet or commits.jsonl found")
+)
+
+type ImportResult struct {
+	Projects   []string `json:"projects"`
+	Commits    int      `json:"commits"`
+	Skipped    int      `json:"skipped"`
+	Filestates int      `json:"filestates"`
+	Issues     int      `json:"issues"`
+}
+
+// importColumns are the columns read from the datasets written by Export.
+// JSON Lines files don't carry a schema, so their types are given here.
+var importColumns = map[string]string{
+	"commits": `{
+		hash: 'VARCHAR', contributor: 'VARCHAR', author_date: 'TIMESTAMP', project: 'VARCHAR',
+		message: 'VARCHAR', category: 'VARCHAR', utc_offset_minutes: 'INTEGER'}`,
+	"filestates": `{
+		commit_hash: 'VARCHAR', path: 'VARCHAR', rename_from: 'VARCHAR', language: 'VARCHAR',
+		sloc: 'INTEGER', cloc: 'INTEGER', blank: 'INTEGER', complexity: 'INTEGER',
+		lines_added: 'INTEGER', lines_deleted: 'INTEGER'}`,
+	"commit_issues": `{commit_hash: 'VARCHAR', issue: 'VARCHAR'}`,
+	"issues":        `{project: 'VARCHAR', key: 'VARCHAR', type: 'VARCHAR', estimate: 'DOUBLE'}`,
+}
+
+// Import loads the commits, filestates and issues exported to dir in Parquet
+// or JSON Lines format. Commits that are already stored are skipped together
+// with their filestates, new commits are appended in the order of the dump.
+// Issues keep their stored values. The import fails without changes if a
+// commit is stored for another project.
+func (db *DB) Import(ctx context.Context, dir string) (ImportResult, error) {
+	format, err := dumpFormat(dir)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	source := func(name string) (string, bool, error) {
+		file := filepath.Join(dir, name+"."+string(format))
+		if _, err := os.Stat(file); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+
+		if format == Parquet {
+			return fmt.Sprintf("read_parquet(%s)", quoteLiteral(file)), true, nil
+		}
+		return fmt.Sprintf("read_json(%s, format = 'newline_delimited', columns = %s)", quoteLiteral(file), importColumns[name]), true, nil
+	}
+
+	// Imported commits are numbered after the ones appended by analyses
+	db.appenderMu.Lock()
+	defer db.appenderMu.Unlock()
+
+	if err := db.commitsAppender.Flush(); err != nil {
+		return ImportResult{}, err
+	}
+	if err := db.filestatesAppender.Flush(); err != nil {
+		return ImportResult{}, err
+	}
+
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer tx.Rollback()
+
+	commits, _, err := source("commits")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	createStmt := fmt.Sprintf(`
+		CREATE TEMP TABLE import_commits AS
+		SELECT hash, contributor, author_date, project, message, category, utc_offset_minutes
+		FROM %s`, commits)
+	if _, err := tx.ExecContext(ctx, createStmt); err != nil {
+		return ImportResult{}, fmt.Errorf("reading commits: %w", err)
+	}
+
+	if err := checkImportConflicts(ctx, tx); err != nil {
+		return ImportResult{}, err
+	}
+
+	var result ImportResult
+
+	projectsQuery := "SELECT DISTINCT project FROM import_commits ORDER BY project"
+	rows, err := tx.QueryContext(ctx, projectsQuery)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for rows.Next() {
+		var project string
+		if err := rows.Scan(&project); err != nil {
+			rows.Close()
+			return ImportResult{}, err
+		}
+		result.Projects = append(result.Projects, project)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return ImportResult{}, err
+	}
+
+	deleteKnownStmt := "DELETE FROM import_commits WHERE hash IN (SELECT hash FROM commits)"
+	skipped, err := exec(ctx, tx, deleteKnownStmt)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	result.Skipped = skipped
+
+	insertCommitsStmt := `
+		INSERT INTO commits (id, hash, contributor, author_date, project, message, category, utc_offset_minutes)
+		SELECT ? + row_number() OVER (ORDER BY rowid) - 1, hash, contributor, author_date, project, message, category, utc_offset_minutes
+		FROM import_commits`
+	if result.Commits, err = exec(ctx, tx, insertCommitsStmt, db.nextCommitID); err != nil {
+		return ImportResult{}, fmt.Errorf("importing commits: %w", err)
+	}
+
+	filestates, ok, err := source("filestates")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if ok {
+		insertFilestatesStmt := fmt.Sprintf(`
+			INSERT INTO filestates (commit_hash, path, rename_from, language, sloc, cloc, blank, complexity, lines_added, lines_deleted)
+			SELECT commit_hash, path, rename_from, language, sloc, cloc, blank, complexity, lines_added, lines_deleted
+			FROM %s
+			WHERE commit_hash IN (SELECT hash FROM import_commits)`, filestates)
+		if result.Filestates, err = exec(ctx, tx, insertFilestatesStmt); err != nil {
+			return ImportResult{}, fmt.Errorf("importing filestates: %w", err)
+		}
+	}
+
+	commitIssues, ok, err := source("commit_issues")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if ok {
+		insertCommitIssuesStmt := fmt.Sprintf(`
+			INSERT INTO commit_issues (commit_hash, issue)
+			SELECT commit_hash, issue
+			FROM %s
+			WHERE commit_hash IN (SELECT hash FROM import_commits)`, commitIssues)
+		if _, err := tx.ExecContext(ctx, insertCommitIssuesStmt); err != nil {
+			return ImportResult{}, fmt.Errorf("importing commit issues: %w", err)
+		}
+	}
+
+	issues, ok, err := source("issues")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if ok {
+		insertIssuesStmt := fmt.Sprintf(`
+			INSERT INTO issues (project, key, type, estimate)
+			SELECT project, key, type, estimate
+			FROM %s
+			ON CONFLICT DO NOTHING`, issues)
+		if result.Issues, err = exec(ctx, tx, insertIssuesStmt); err != nil {
+			return ImportResult{}, fmt.Errorf("importing issues: %w", err)
+		}
+	}
+
+	// Rolling back drops the table as well
+	if _, err := tx.ExecContext(ctx, "DROP TABLE import_commits"); err != nil {
+		return ImportResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ImportResult{}, err
+	}
+	db.nextCommitID += int32(result.Commits)
+
+	return result, nil
+}
+
+// dumpFormat returns the format of the commits exported to dir.
+func dumpFormat(dir string) (ExportFormat, error) {
+	for _, format := range []ExportFormat{Parquet, JSONL} {
+		if _, err := os.Stat(filepath.Join(dir, "commits."+string(format))); err == nil {
+			return format, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s: %w", dir, ErrNoDump)
+}
+
+// checkImportConflicts fails if an imported commit is stored for another
+// project or occurs more than once.
+func checkImportConflicts(ctx context.Context, tx *sql.Tx) error {
+	conflictQuery := `
+		SELECT i.hash, i.project, c.project
+		FROM import_commits i
+		JOIN commits c ON c.hash = i.hash
+		WHERE c.project <> i.project
+		LIMIT 1`
+	var hash, project, stored string
+	err := tx.QueryRowContext(ctx, conflictQuery).Scan(&hash, &project, &stored)
+	if err == nil {
+		return fmt.Errorf("%w: %s of %s is stored for %s", ErrImportConflict, hash, project, stored)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	duplicateQuery := `
+		SELECT hash
+		FROM import_commits
+		GROUP BY hash
+		HAVING COUNT(*) > 1
+		LIMIT 1`
+	err = tx.QueryRowContext(ctx, duplicateQuery).Scan(&hash)
+	if err == nil {
+		return fmt.Errorf("%w: %s occurs more than once", ErrImportConflict, hash)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return nil
+}
+
+func exec(ctx context.Context, tx *sql.Tx, query string, args ...any) (int, error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boyter/scc/v3/processor"
+)
+
+// seedProject stores n commits of project touching two files each, the
+// first of them with an issue reference.
+func seedProject(t *testing.T, db *DB, project string, n int) {
+	t.Helper()
+	ctx := context.Background()
+
+	commits := testCommits(project, n)
+	commits[0].Message = "Fix PROJ-1"
+	if err := db.PersistCommits(ctx, commits); err != nil {
+		t.Fatal(err)
+	}
+
+	var filestates []FileState
+	for i, commit := range commits {
+		for _, path := range []string{"main.go", "util/util.go"} {
+			filestates = append(filestates, FileState{
+				CommitHash: commit.Hash,
+				LinesAdded: int64(i + 1),
+				FileJob: &processor.FileJob{
+					Filename:   path,
+					Language:   "Go",
+					Code:       int64(10 * (i + 1)),
+					Comment:    2,
+					Blank:      1,
+					Complexity: int64(i),
+				},
+			})
+		}
+	}
+	if err := db.PersistFileStates(ctx, filestates, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ExtractIssues(project); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshot returns the stored commits, filestates and issue references in a
+// comparable form. Commit ids are left out, they depend on the database.
+func snapshot(t *testing.T, db *DB) string {
+	t.Helper()
+
+	queries := []string{
+		`SELECT hash, contributor, author_date, project, message, category, utc_offset_minutes
+		FROM commits ORDER BY id`,
+		`SELECT f.commit_hash, f.path, f.rename_from, f.language, f.sloc, f.cloc, f.blank, f.complexity, f.lines_added, f.lines_deleted
+		FROM filestates f JOIN commits c ON c.hash = f.commit_hash ORDER BY c.id, f.path`,
+		`SELECT commit_hash, issue FROM commit_issues ORDER BY commit_hash, issue`,
+	}
+
+	var b strings.Builder
+	for _, query := range queries {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols, _ := rows.Columns()
+		for rows.Next() {
+			values := make([]any, len(cols))
+			ptrs := make([]any, len(cols))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintln(&b, values...)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	return b.String()
+}
+
+func exportProject(t *testing.T, db *DB, project string, format ExportFormat) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if _, err := db.Export(context.Background(), project, format, dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	for _, format := range []ExportFormat{Parquet, JSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			ctx := context.Background()
+
+			src := openTestDB(t)
+			seedProject(t, src, "p", 3)
+			dump := exportProject(t, src, "p", format)
+
+			dst := openTestDB(t)
+			result, err := dst.Import(ctx, dump)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := ImportResult{Projects: []string{"p"}, Commits: 3, Filestates: 6}
+			if fmt.Sprint(result) != fmt.Sprint(want) {
+				t.Errorf("got %+v, want %+v", result, want)
+			}
+
+			if got, want := snapshot(t, dst), snapshot(t, src); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+
+			// Commits analyzed after the import are numbered after it
+			if err := dst.PersistCommits(ctx, testCommits("q", 1)); err != nil {
+				t.Fatal(err)
+			}
+			var ids int
+			if err := dst.QueryRow("SELECT COUNT(DISTINCT id) FROM commits").Scan(&ids); err != nil {
+				t.Fatal(err)
+			}
+			if ids != 4 {
+				t.Errorf("got %d distinct ids, want 4", ids)
+			}
+		})
+	}
+}
+
+func TestImportSkipsStoredCommits(t *testing.T) {
+	ctx := context.Background()
+
+	src := openTestDB(t)
+	seedProject(t, src, "p", 3)
+	dump := exportProject(t, src, "p", Parquet)
+
+	dst := openTestDB(t)
+	seedProject(t, dst, "p", 2)
+
+	result, err := dst.Import(ctx, dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ImportResult{Projects: []string{"p"}, Commits: 1, Skipped: 2, Filestates: 2}
+	if fmt.Sprint(result) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+	if got, want := snapshot(t, dst), snapshot(t, src); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	result, err = dst.Import(ctx, dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = ImportResult{Projects: []string{"p"}, Skipped: 3}
+	if fmt.Sprint(result) != fmt.Sprint(want) {
+		t.Errorf("got %+v on the second import, want %+v", result, want)
+	}
+}
+
+func TestImportConflict(t *testing.T) {
+	src := openTestDB(t)
+	seedProject(t, src, "p", 3)
+	dump := exportProject(t, src, "p", JSONL)
+
+	// The last commit of p is stored for q
+	dst := openTestDB(t)
+	commits := testCommits("p", 3)[2:]
+	commits[0].Project = "q"
+	if err := dst.PersistCommits(context.Background(), commits); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshot(t, dst)
+
+	if _, err := dst.Import(context.Background(), dump); !errors.Is(err, ErrImportConflict) {
+		t.Fatalf("got error %v, want %v", err, ErrImportConflict)
+	}
+	if after := snapshot(t, dst); after != before {
+		t.Errorf("got\n%s\nafter the failed import, want\n%s", after, before)
+	}
+}
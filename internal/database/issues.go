@@ -0,0 +1,303 @@
+package database
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrIssueFormat = errors.New("issues must be provided as .csv or .json file")
+
+	// IssuePattern matches GitHub-style (#123) and Jira-style (ABC-456) issue
+	// references in commit messages.
+	IssuePattern = `#[0-9]+|\b[A-Z][A-Z0-9]+-[0-9]+\b`
+
+	// IssueKeys are the Jira project keys of issue references. If set, only
+	// Jira-style references with these keys are extracted, otherwise all but
+	// those starting with one of the IgnoredIssuePrefixes.
+	IssueKeys []string
+
+	// IgnoredIssuePrefixes are names of standards, algorithms and encodings
+	// that look like Jira-style issue references, like UTF-8 or SHA-256.
+	IgnoredIssuePrefixes = []string{
+		"AES", "ANSI", "CP", "CVE", "CWE", "ECMA", "GPL", "HTTP", "IEC", "IEEE", "ISO", "LGPL",
+		"MD", "PEP", "RFC", "RSA", "SHA", "TLS", "UCS", "UTF", "WIN",
+	}
+
+	ErrIssueKey = errors.New("issue keys must consist of upper case letters and digits, starting with a letter")
+
+	// UnplannedIssueTypes are the issue types that count as unplanned work.
+	UnplannedIssueTypes = []string{"bug", "defect", "incident", "hotfix", "support"}
+)
+
+type Issue struct {
+	Key      string  `json:"key"`
+	Type     string  `json:"type"`
+	Estimate float64 `json:"estimate"`
+}
+
+// LoadIssues reads issue metadata from a JSON array or a CSV file with the
+// header key,type,estimate.
+func LoadIssues(path string) ([]Issue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var issues []Issue
+		if err := json.NewDecoder(f).Decode(&issues); err != nil {
+			return nil, err
+		}
+		return issues, nil
+	case ".csv":
+		return parseIssuesCSV(f)
+	default:
+		return nil, ErrIssueFormat
+	}
+}
+
+func parseIssuesCSV(r io.Reader) ([]Issue, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, column := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	if _, exists := columns["key"]; !exists {
+		return nil, fmt.Errorf("issues csv: missing column %q", "key")
+	}
+
+	var issues []Issue
+	for _, record := range records[1:] {
+		issue := Issue{Key: record[columns["key"]]}
+		if i, exists := columns["type"]; exists {
+			issue.Type = record[i]
+		}
+		if i, exists := columns["estimate"]; exists && record[i] != "" {
+			issue.Estimate, err = strconv.ParseFloat(record[i], 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		issues = append(issues, issue)
+	}
+
+	return issues, nil
+}
+
+// ImportIssues stores issue metadata for project, replacing existing
+// metadata of issues with the same key.
+func (db *DB) ImportIssues(project string, issues []Issue) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, issue := range issues {
+		if _, err := tx.Exec(
+			"INSERT OR REPLACE INTO issues VALUES (?, ?, ?, ?)",
+			project, issue.Key, strings.ToLower(issue.Type), issue.Estimate,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// issueKeyRegex matches valid Jira project keys.
+var issueKeyRegex = regexp.MustCompile(`^[A-Z][A-Z0-9]+$`)
+
+// ParseIssueKeys parses a comma-separated list of Jira project keys like
+// PROJ,OPS.
+func ParseIssueKeys(s string) ([]string, error) {
+	var keys []string
+	for key := range strings.SplitSeq(s, ",") {
+		key = strings.TrimSpace(key)
+		if !issueKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("%w: %q", ErrIssueKey, key)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// issuePattern returns the pattern of the issue references to extract.
+func issuePattern() string {
+	if len(IssueKeys) == 0 {
+		return IssuePattern
+	}
+
+	keys := make([]string, len(IssueKeys))
+	for i, key := range IssueKeys {
+		keys[i] = regexp.QuoteMeta(key)
+	}
+
+	return `#[0-9]+|\b(` + strings.Join(keys, "|") + `)-[0-9]+\b`
+}
+
+// ExtractIssues (re-)populates commit_issues with the issue references found
+// in the commit messages of project.
+func (db *DB) ExtractIssues(project string) error {
+	deleteCommitIssuesStmt := `
+	DELETE FROM commit_issues
+	WHERE commit_hash IN (
+		SELECT hash
+		FROM commits
+		WHERE project = ?
+	);`
+	if _, err := db.Exec(deleteCommitIssuesStmt, project); err != nil {
+		return err
+	}
+
+	insertCommitIssuesStmt := `
+	INSERT INTO commit_issues
+	SELECT DISTINCT hash, issue
+	FROM (
+		SELECT hash, unnest(regexp_extract_all(message, ?)) AS issue
+		FROM commits
+		WHERE project = ?
+	)
+	WHERE NOT list_contains(string_split(?, ','), split_part(issue, '-', 1));`
+	ignored := ""
+	if len(IssueKeys) == 0 {
+		ignored = strings.Join(IgnoredIssuePrefixes, ",")
+	}
+	if _, err := db.Exec(insertCommitIssuesStmt, issuePattern(), project, ignored); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type IssueData struct {
+	Issue        string  `json:"issue"`
+	Type         string  `json:"type"`
+	Estimate     float64 `json:"estimate"`
+	Commits      int     `json:"commits"`
+	FilesTouched int     `json:"filesTouched"`
+	LinesAdded   int     `json:"linesAdded"`
+	LinesDeleted int     `json:"linesDeleted"`
+}
+
+// GetIssues aggregates the churn and the number of files touched by the
+// commits referencing each issue of project.
+func (db DB) GetIssues(project string) ([]IssueData, error) {
+	rows, err := db.Query(`
+	SELECT
+		ci.issue,
+		COALESCE(i.type, ''),
+		COALESCE(i.estimate, 0),
+		COUNT(DISTINCT c.hash),
+		COUNT(DISTINCT f.path),
+		COALESCE(SUM(f.lines_added), 0),
+		COALESCE(SUM(f.lines_deleted), 0)
+	FROM commit_issues ci
+	JOIN commits c ON c.hash = ci.commit_hash
+	LEFT JOIN filestates f ON f.commit_hash = c.hash AND f.lines_added + f.lines_deleted > 0
+	LEFT JOIN issues i ON i.project = c.project AND i.key = ci.issue
+	WHERE c.project = ?
+	GROUP BY ci.issue, i.type, i.estimate
+	ORDER BY SUM(f.lines_added) + SUM(f.lines_deleted) DESC NULLS LAST, ci.issue`, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	issues := []IssueData{}
+	for rows.Next() {
+		var id IssueData
+		if err := rows.Scan(&id.Issue, &id.Type, &id.Estimate, &id.Commits, &id.FilesTouched, &id.LinesAdded, &id.LinesDeleted); err != nil {
+			return nil, err
+		}
+		issues = append(issues, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return issues, nil
+}
+
+type UnplannedWork struct {
+	Module         string  `json:"module"`
+	Churn          int     `json:"churn"`
+	UnplannedChurn int     `json:"unplannedChurn"`
+	UnplannedShare float64 `json:"unplannedShare"`
+}
+
+// GetUnplannedWork returns the churn per directory of project and how much of
+// it was caused by unplanned work. A commit is unplanned, if it references an
+// issue with one of the UnplannedIssueTypes or, if none of its issues have a
+// known type, if it was classified as bugfix.
+func (db DB) GetUnplannedWork(project string) ([]UnplannedWork, error) {
+	rows, err := db.Query(`
+	WITH unplanned AS (
+		SELECT c.hash
+		FROM commits c
+		LEFT JOIN commit_issues ci ON ci.commit_hash = c.hash
+		LEFT JOIN issues i ON i.project = c.project AND i.key = ci.issue
+		WHERE c.project = ?
+		GROUP BY c.hash, c.category
+		HAVING bool_or(list_contains(string_split(?, ','), i.type))
+			OR (COUNT(i.type) = 0 AND c.category = ?)
+	)
+	SELECT
+		`+directoryExpr+` AS module,
+		SUM(f.lines_added + f.lines_deleted) AS churn,
+		COALESCE(SUM(f.lines_added + f.lines_deleted) FILTER (WHERE u.hash IS NOT NULL), 0) AS unplanned_churn
+	FROM filestates f
+	JOIN commits c ON c.hash = f.commit_hash
+	LEFT JOIN unplanned u ON u.hash = c.hash
+	WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+	GROUP BY module
+	ORDER BY unplanned_churn DESC, module`, project, strings.Join(UnplannedIssueTypes, ","), CategoryBugfix, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var work []UnplannedWork
+	for rows.Next() {
+		var uw UnplannedWork
+		if err := rows.Scan(&uw.Module, &uw.Churn, &uw.UnplannedChurn); err != nil {
+			return nil, err
+		}
+		if uw.Churn > 0 {
+			uw.UnplannedShare = float64(uw.UnplannedChurn) / float64(uw.Churn)
+		}
+		work = append(work, uw)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(work) == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return work, nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// extractIssues stores a commit of project "p" per message and returns the
+// issues extracted from each.
+func extractIssues(t *testing.T, db *DB, messages []string) [][]string {
+	t.Helper()
+
+	commits := testCommits("p", len(messages))
+	for i, message := range messages {
+		commits[i].Message = message
+	}
+	if err := db.PersistCommits(context.Background(), commits); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ExtractIssues("p"); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := make([][]string, len(commits))
+	for i, commit := range commits {
+		rows, err := db.Query("SELECT issue FROM commit_issues WHERE commit_hash = ? ORDER BY issue", commit.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var issue string
+			if err := rows.Scan(&issue); err != nil {
+				t.Fatal(err)
+			}
+			issues[i] = append(issues[i], issue)
+		}
+		rows.Close()
+	}
+
+	return issues
+}
+
+func TestExtractIssues(t *testing.T) {
+	tests := []struct {
+		message string
+		keys    []string
+		issues  []string
+	}{
+		{"Fix PROJ-12 and #7", nil, []string{"#7", "PROJ-12"}},
+		{"Fix PROJ-12 twice: PROJ-12", nil, []string{"PROJ-12"}},
+		{"Read files as UTF-8", nil, nil},
+		{"Use SHA-256 instead of MD5", nil, nil},
+		{"Format dates as ISO-8601", nil, nil},
+		{"Patch CVE-2024-1234", nil, nil},
+		{"Support X-1 and proj-3", nil, nil},
+		{"Fix PROJ-12 and OPS-3 in AB-1", []string{"PROJ", "OPS"}, []string{"OPS-3", "PROJ-12"}},
+		{"Close #7 of SUBPROJ-1", []string{"PROJ"}, []string{"#7"}},
+		{"Fix UTF-8 handling for UTF-9", []string{"UTF"}, []string{"UTF-8", "UTF-9"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			keys := IssueKeys
+			IssueKeys = test.keys
+			t.Cleanup(func() { IssueKeys = keys })
+
+			db := openTestDB(t)
+			got := extractIssues(t, db, []string{test.message})[0]
+			if fmt.Sprint(got) != fmt.Sprint(test.issues) {
+				t.Errorf("got issues %q, want %q", got, test.issues)
+			}
+		})
+	}
+}
+
+func TestParseIssueKeys(t *testing.T) {
+	keys, err := ParseIssueKeys("PROJ, OPS2")
+	if err != nil || fmt.Sprint(keys) != "[PROJ OPS2]" {
+		t.Errorf("got %q and error %v, want [PROJ OPS2]", keys, err)
+	}
+
+	for _, s := range []string{"", "PROJ,", "proj", "P", "2FA", "PR|OJ"} {
+		if _, err := ParseIssueKeys(s); !errors.Is(err, ErrIssueKey) {
+			t.Errorf("%q: got error %v, want %v", s, err, ErrIssueKey)
+		}
+	}
+}
+
+func TestGetIssuesWithoutIssues(t *testing.T) {
+	db := openTestDB(t)
+	extractIssues(t, db, []string{"Change"})
+
+	issues, err := db.GetIssues("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues == nil || len(issues) != 0 {
+		t.Errorf("got %#v, want empty issues", issues)
+	}
+}
+
+func TestCleanRemovesIssues(t *testing.T) {
+	db := openTestDB(t)
+	extractIssues(t, db, []string{"Fix PROJ-1"})
+	if err := db.ImportIssues("p", []Issue{{Key: "PROJ-1", Type: "Bug", Estimate: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ImportIssues("q", []Issue{{Key: "PROJ-1", Type: "Bug"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Clean("p"); err != nil {
+		t.Fatal(err)
+	}
+
+	for table, want := range map[string]int{"commits": 0, "commit_issues": 0, "issues": 1} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("got %d rows in %s, want %d", n, table, want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import "time"
+
+// KPIs are the code health indicators of a project at its latest commit.
+type KPIs struct {
+	Sloc       int `json:"sloc"`
+	Complexity int `json:"complexity"`
+	// Hotspots is the number of files with a hotspot score of at least the
+	// threshold passed to GetKPIs
+	Hotspots int `json:"hotspots"`
+	// BusFactor is the smallest number of contributors who together added at
+	// least half of the lines of the project
+	BusFactor int `json:"busFactor"`
+	// ActiveContributors is the number of contributors who committed since
+	// the time passed to GetKPIs
+	ActiveContributors int `json:"activeContributors"`
+}
+
+// ActiveContributorWindow is how long contributors count as active after
+// their last commit in project metrics.
+var ActiveContributorWindow = 90 * 24 * time.Hour
+
+func (db DB) GetKPIs(project string, hotspotThreshold float64, activeSince time.Time) (KPIs, error) {
+	hotspots, err := db.GetHotspots(project)
+	if err != nil {
+		return KPIs{}, err
+	}
+
+	var kpis KPIs
+	for _, h := range hotspots {
+		kpis.Sloc += h.Sloc
+		kpis.Complexity += h.Complexity
+		if h.Score >= hotspotThreshold {
+			kpis.Hotspots++
+		}
+	}
+
+	err = db.QueryRow(`
+	WITH authored AS (
+		SELECT c.contributor, SUM(f.lines_added) AS lines
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		WHERE c.project = ?
+		GROUP BY c.contributor
+	), ranked AS (
+		SELECT
+			lines,
+			SUM(lines) OVER (ORDER BY lines DESC, contributor ROWS UNBOUNDED PRECEDING) AS cumulative,
+			SUM(lines) OVER () AS total
+		FROM authored
+	)
+	SELECT COUNT(*)
+	FROM ranked
+	WHERE cumulative - lines < total / 2`, project).Scan(&kpis.BusFactor)
+	if err != nil {
+		return KPIs{}, err
+	}
+
+	err = db.QueryRow(
+		"SELECT COUNT(DISTINCT contributor) FROM commits WHERE project = ? AND author_date >= ?::TIMESTAMP",
+		project,
+		activeSince,
+	).Scan(&kpis.ActiveContributors)
+	if err != nil {
+		return KPIs{}, err
+	}
+
+	return kpis, nil
+}
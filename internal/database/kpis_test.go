@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boyter/scc/v3/processor"
+)
+
+func TestGetKPIs(t *testing.T) {
+	db := openTestDB(t)
+	seedProjects(t, db)
+
+	day := func(d int) time.Time {
+		return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	// main.go has a hotspot score of 1, util.go of 1/12 and README.md of 0.
+	// Alice added 160 of 173 lines.
+	tests := []struct {
+		name        string
+		threshold   float64
+		activeSince time.Time
+		want        KPIs
+	}{
+		{"all active", 0.5, day(1), KPIs{Sloc: 165, Complexity: 15, Hotspots: 1, BusFactor: 1, ActiveContributors: 2}},
+		{"lower threshold", 0.05, day(1), KPIs{Sloc: 165, Complexity: 15, Hotspots: 2, BusFactor: 1, ActiveContributors: 2}},
+		{"zero threshold", 0, day(1), KPIs{Sloc: 165, Complexity: 15, Hotspots: 3, BusFactor: 1, ActiveContributors: 2}},
+		{"Bob left", 0.5, day(4), KPIs{Sloc: 165, Complexity: 15, Hotspots: 1, BusFactor: 1, ActiveContributors: 1}},
+		{"nobody active", 0.5, day(5), KPIs{Sloc: 165, Complexity: 15, Hotspots: 1, BusFactor: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kpis, err := db.GetKPIs("p", test.threshold, test.activeSince)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kpis != test.want {
+				t.Errorf("got %+v, want %+v", kpis, test.want)
+			}
+		})
+	}
+
+	if _, err := db.GetKPIs("r", 0.5, day(1)); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v for an unknown project, want %v", err, ErrProjectNotFound)
+	}
+}
+
+func TestGetKPIsBusFactor(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []int64
+		want  int
+	}{
+		{"single author", []int64{10}, 1},
+		{"majority", []int64{60, 30, 10}, 1},
+		{"exactly half", []int64{50, 30, 20}, 1},
+		{"two needed", []int64{40, 35, 25}, 2},
+		{"even split", []int64{25, 25, 25, 25}, 2},
+		{"no lines", []int64{0, 0}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openTestDB(t)
+			ctx := context.Background()
+
+			commits := testCommits("p", len(test.lines))
+			var filestates []FileState
+			for i, lines := range test.lines {
+				commits[i].Author = string(rune('A' + i))
+				filestates = append(filestates, FileState{
+					CommitHash: commits[i].Hash,
+					LinesAdded: lines,
+					FileJob:    &processor.FileJob{Filename: commits[i].Author + ".go", Language: "Go", Code: lines},
+				})
+			}
+			if err := db.PersistCommits(ctx, commits); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.PersistFileStates(ctx, filestates, func(int, int) {}); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			kpis, err := db.GetKPIs("p", 0.5, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kpis.BusFactor != test.want {
+				t.Errorf("got bus factor %d, want %d", kpis.BusFactor, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Project struct {
+	Name         string    `json:"name"`
+	Commits      int       `json:"commits"`
+	Contributors int       `json:"contributors"`
+	FirstCommit  time.Time `json:"firstCommit"`
+	LastCommit   time.Time `json:"lastCommit"`
+}
+
+const projectSummaryQuery = `
+	SELECT
+		project,
+		COUNT(*),
+		COUNT(DISTINCT contributor),
+		min(author_date),
+		max(author_date)
+	FROM commits`
+
+func (db DB) GetProjectSummaries() ([]Project, error) {
+	rows, err := db.Query(projectSummaryQuery + " GROUP BY project ORDER BY project")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		var p Project
+		if err := rows.Scan(&p.Name, &p.Commits, &p.Contributors, &p.FirstCommit, &p.LastCommit); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return projects, nil
+}
+
+func (db DB) GetProject(project string) (Project, error) {
+	var p Project
+	err := db.QueryRow(projectSummaryQuery+" WHERE project = ? GROUP BY project", project).
+		Scan(&p.Name, &p.Commits, &p.Contributors, &p.FirstCommit, &p.LastCommit)
+
+	if err == sql.ErrNoRows {
+		return Project{}, ErrProjectNotFound
+	}
+
+	if err != nil {
+		return Project{}, err
+	}
+
+	return p, nil
+}
+
+// DeleteProject removes all analysis data of project including its
+// component definitions, issue metadata and run history.
+func (db *DB) DeleteProject(project string) error {
+	if _, err := db.GetProject(project); err != nil {
+		return err
+	}
+
+	if err := db.Clean(project); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM components WHERE project = ?", project); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM analysis_runs WHERE project = ?", project); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type CommitFilter struct {
+	Author   string
+	Category string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Offset   int
+}
+
+type CommitInfo struct {
+	Hash         string    `json:"hash"`
+	Author       string    `json:"author"`
+	Date         time.Time `json:"date"`
+	Message      string    `json:"message"`
+	Category     string    `json:"category"`
+	FilesTouched int       `json:"filesTouched"`
+	LinesAdded   int       `json:"linesAdded"`
+	LinesDeleted int       `json:"linesDeleted"`
+}
+
+type CommitPage struct {
+	Commits []CommitInfo `json:"commits"`
+	Total   int          `json:"total"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+}
+
+// GetCommits returns the commits of project matching filter, newest first.
+// Zero values in filter don't restrict the result.
+func (db DB) GetCommits(project string, filter CommitFilter) (CommitPage, error) {
+	where := `
+	WHERE c.project = $project
+		AND ($author = '' OR c.contributor = $author)
+		AND ($category = '' OR c.category = $category)
+		AND ($since::TIMESTAMP IS NULL OR c.author_date >= $since::TIMESTAMP)
+		AND ($until::TIMESTAMP IS NULL OR c.author_date < $until::TIMESTAMP)`
+	args := []any{
+		sql.Named("project", project),
+		sql.Named("author", filter.Author),
+		sql.Named("category", filter.Category),
+		sql.Named("since", nullTime(filter.Since)),
+		sql.Named("until", nullTime(filter.Until)),
+	}
+
+	page := CommitPage{
+		Commits: []CommitInfo{},
+		Limit:   filter.Limit,
+		Offset:  filter.Offset,
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM commits c"+where, args...).Scan(&page.Total); err != nil {
+		return CommitPage{}, err
+	}
+
+	rows, err := db.Query(`
+	SELECT
+		c.hash,
+		c.contributor,
+		c.author_date,
+		c.message,
+		COALESCE(c.category, ''),
+		COUNT(f.path),
+		COALESCE(SUM(f.lines_added), 0),
+		COALESCE(SUM(f.lines_deleted), 0)
+	FROM commits c
+	LEFT JOIN filestates f ON f.commit_hash = c.hash AND f.lines_added + f.lines_deleted > 0`+where+`
+	GROUP BY c.id, c.hash, c.contributor, c.author_date, c.message, c.category
+	ORDER BY c.author_date DESC, c.id DESC
+	LIMIT $limit OFFSET $offset`, append(args, sql.Named("limit", filter.Limit), sql.Named("offset", filter.Offset))...)
+	if err != nil {
+		return CommitPage{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ci CommitInfo
+		if err := rows.Scan(
+			&ci.Hash,
+			&ci.Author,
+			&ci.Date,
+			&ci.Message,
+			&ci.Category,
+			&ci.FilesTouched,
+			&ci.LinesAdded,
+			&ci.LinesDeleted,
+		); err != nil {
+			return CommitPage{}, err
+		}
+		page.Commits = append(page.Commits, ci)
+	}
+
+	if err := rows.Err(); err != nil {
+		return CommitPage{}, err
+	}
+
+	return page, nil
+}
+
+// nullTime maps the zero time to NULL.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
+
+type FileInfo struct {
+	Path         string `json:"path"`
+	Language     string `json:"language"`
+	Sloc         int    `json:"sloc"`
+	Cloc         int    `json:"cloc"`
+	Blank        int    `json:"blank"`
+	Complexity   int    `json:"complexity"`
+	LinesAdded   int    `json:"linesAdded"`
+	LinesDeleted int    `json:"linesDeleted"`
+}
+
+// GetFiles returns the state of all files of project at the commit with hash.
+func (db DB) GetFiles(project, hash string) ([]FileInfo, error) {
+	rows, err := db.Query(`
+	SELECT f.path, f.language, f.sloc, f.cloc, f.blank, f.complexity, f.lines_added, f.lines_deleted
+	FROM filestates f
+	JOIN commits c ON c.hash = f.commit_hash
+	WHERE c.project = ? AND c.hash = ?
+	ORDER BY f.path`, project, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileInfo
+	for rows.Next() {
+		var fi FileInfo
+		if err := rows.Scan(&fi.Path, &fi.Language, &fi.Sloc, &fi.Cloc, &fi.Blank, &fi.Complexity, &fi.LinesAdded, &fi.LinesDeleted); err != nil {
+			return nil, err
+		}
+		files = append(files, fi)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, ErrCommitNotFound
+	}
+
+	return files, nil
+}
+
+type Contributor struct {
+	Contributor  string    `json:"contributor"`
+	Team         string    `json:"team"`
+	Commits      int       `json:"commits"`
+	LinesAdded   int       `json:"linesAdded"`
+	LinesDeleted int       `json:"linesDeleted"`
+	FirstCommit  time.Time `json:"firstCommit"`
+	LastCommit   time.Time `json:"lastCommit"`
+}
+
+func (db DB) GetContributors(project string) ([]Contributor, error) {
+	rows, err := db.Query(`
+	WITH churn AS (
+		SELECT f.commit_hash, SUM(f.lines_added) AS lines_added, SUM(f.lines_deleted) AS lines_deleted
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		WHERE c.project = ?
+		GROUP BY f.commit_hash
+	)
+	SELECT
+		c.contributor,
+		COALESCE(tm.team, ?),
+		COUNT(*) AS commits,
+		COALESCE(SUM(ch.lines_added), 0),
+		COALESCE(SUM(ch.lines_deleted), 0),
+		min(c.author_date),
+		max(c.author_date)
+	FROM commits c
+	LEFT JOIN churn ch ON ch.commit_hash = c.hash
+	LEFT JOIN team_members tm ON tm.contributor = c.contributor
+	WHERE c.project = ?
+	GROUP BY c.contributor, tm.team
+	ORDER BY commits DESC, c.contributor`, project, UnassignedTeam, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contributors []Contributor
+	for rows.Next() {
+		var c Contributor
+		if err := rows.Scan(&c.Contributor, &c.Team, &c.Commits, &c.LinesAdded, &c.LinesDeleted, &c.FirstCommit, &c.LastCommit); err != nil {
+			return nil, err
+		}
+		contributors = append(contributors, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(contributors) == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return contributors, nil
+}
+
+type Hotspot struct {
+	Path       string  `json:"path"`
+	Language   string  `json:"language"`
+	Sloc       int     `json:"sloc"`
+	Complexity int     `json:"complexity"`
+	Revisions  int     `json:"revisions"`
+	Churn      int     `json:"churn"`
+	Authors    int     `json:"authors"`
+	Score      float64 `json:"score"`
+}
+
+// latestFilesCTE lists the files of the latest commit of a project. Ids follow
+// the order of the history, unlike author dates, which rebases and clock skew
+// can reorder. It expects the project as its only parameter.
+const latestFilesCTE = `
+	latest_files AS (
+		SELECT f.path, f.language, f.sloc, f.cloc, f.blank, f.complexity
+		FROM filestates f
+		WHERE f.commit_hash = (
+			SELECT hash
+			FROM commits
+			WHERE project = ?
+			ORDER BY id DESC
+			LIMIT 1
+		)
+	)`
+
+// HotspotThreshold is the hotspot score from which on files count as
+// hotspots in project metrics, findings and quality gates.
+var HotspotThreshold = 0.5
+
+// GetHotspots returns the files of the latest commit of project ordered by
+// their hotspot score, which is the product of the number of revisions and
+// the complexity, normalized to the highest score in the project.
+func (db DB) GetHotspots(project string) ([]Hotspot, error) {
+	rows, err := db.Query(`
+	WITH`+latestFilesCTE+`,
+	changes AS (
+		SELECT
+			f.path,
+			COUNT(*) AS revisions,
+			SUM(f.lines_added + f.lines_deleted) AS churn,
+			COUNT(DISTINCT c.contributor) AS authors
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+		GROUP BY f.path
+	), scored AS (
+		SELECT
+			l.path,
+			l.language,
+			l.sloc,
+			l.complexity,
+			COALESCE(ch.revisions, 0) AS revisions,
+			COALESCE(ch.churn, 0) AS churn,
+			COALESCE(ch.authors, 0) AS authors,
+			COALESCE(ch.revisions, 0) * l.complexity AS raw_score
+		FROM latest_files l
+		LEFT JOIN changes ch ON ch.path = l.path
+	)
+	SELECT
+		path,
+		language,
+		sloc,
+		complexity,
+		revisions,
+		churn,
+		authors,
+		COALESCE(raw_score / NULLIF(max(raw_score) OVER (), 0), 0) AS score
+	FROM scored
+	ORDER BY score DESC, revisions DESC, path`, project, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hotspots []Hotspot
+	for rows.Next() {
+		var h Hotspot
+		if err := rows.Scan(&h.Path, &h.Language, &h.Sloc, &h.Complexity, &h.Revisions, &h.Churn, &h.Authors, &h.Score); err != nil {
+			return nil, err
+		}
+		hotspots = append(hotspots, h)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(hotspots) == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return hotspots, nil
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/boyter/scc/v3/processor"
+)
+
+// seedProjects stores four commits of project "p" and one of project "q".
+// Alice adds main.go and util.go, Bob fixes main.go twice and Alice
+// documents the project.
+func seedProjects(t *testing.T, db *DB) []Commit {
+	t.Helper()
+	ctx := context.Background()
+
+	commits := testCommits("p", 4)
+	commits[0].Message = "Add main and util"
+	commits[1].Author, commits[1].Message = "Bob", "Fix main"
+	commits[2].Author, commits[2].Message = "Bob", "Fix main again"
+	commits[3].Message = "Add README"
+	if err := db.PersistCommits(ctx, append(commits, testCommits("q", 1)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	type file struct {
+		path             string
+		code, complexity int64
+	}
+	// Every commit lists all files present, unchanged ones without added
+	// or deleted lines
+	changes := []map[string][2]int64{
+		{"main.go": {100, 0}, "util.go": {40, 0}},
+		{"main.go": {10, 5}, "util.go": {0, 0}},
+		{"main.go": {3, 1}, "util.go": {0, 0}},
+		{"main.go": {0, 0}, "util.go": {0, 0}, "README.md": {20, 0}},
+	}
+	files := []file{{"main.go", 105, 12}, {"util.go", 40, 3}, {"README.md", 20, 0}}
+
+	var filestates []FileState
+	for i, commit := range commits {
+		for _, f := range files {
+			change, ok := changes[i][f.path]
+			if !ok {
+				continue
+			}
+			filestates = append(filestates, FileState{
+				CommitHash:   commit.Hash,
+				LinesAdded:   change[0],
+				LinesDeleted: change[1],
+				FileJob:      &processor.FileJob{Filename: f.path, Language: "Go", Code: f.code, Complexity: f.complexity},
+			})
+		}
+	}
+	if err := db.PersistFileStates(ctx, filestates, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ClassifyCommits("p", DefaultClassificationRules); err != nil {
+		t.Fatal(err)
+	}
+
+	return commits
+}
+
+func TestGetProjects(t *testing.T) {
+	db := openTestDB(t)
+	seedProjects(t, db)
+
+	projects, err := db.GetProjectSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[{p 4 2 2025-01-01 12:00:00 +0000 UTC 2025-01-04 12:00:00 +0000 UTC} {q 1 1 2025-01-01 12:00:00 +0000 UTC 2025-01-01 12:00:00 +0000 UTC}]"
+	if got := fmt.Sprint(projects); got != want {
+		t.Errorf("got projects %s, want %s", got, want)
+	}
+
+	project, err := db.GetProject("q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Name != "q" || project.Commits != 1 {
+		t.Errorf("got project %+v, want q with 1 commit", project)
+	}
+
+	if _, err := db.GetProject("r"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v for an unknown project, want %v", err, ErrProjectNotFound)
+	}
+}
+
+func TestGetCommits(t *testing.T) {
+	db := openTestDB(t)
+	commits := seedProjects(t, db)
+
+	day := func(d int) time.Time {
+		return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name   string
+		filter CommitFilter
+		hashes string
+		total  int
+	}{
+		{"all", CommitFilter{Limit: 10}, "[p-3 p-2 p-1 p-0]", 4},
+		{"first page", CommitFilter{Limit: 3}, "[p-3 p-2 p-1]", 4},
+		{"second page", CommitFilter{Limit: 3, Offset: 3}, "[p-0]", 4},
+		{"past the end", CommitFilter{Limit: 3, Offset: 6}, "[]", 4},
+		{"author", CommitFilter{Author: "Bob", Limit: 10}, "[p-2 p-1]", 2},
+		{"category", CommitFilter{Category: CategoryFeature, Limit: 10}, "[p-3 p-0]", 2},
+		{"since", CommitFilter{Since: day(2), Limit: 10}, "[p-3 p-2 p-1]", 3},
+		{"until is exclusive", CommitFilter{Until: day(3).Add(12 * time.Hour), Limit: 10}, "[p-1 p-0]", 2},
+		{"combined", CommitFilter{Author: "Bob", Since: day(3), Limit: 1}, "[p-2]", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := db.GetCommits("p", test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hashes := make([]string, len(page.Commits))
+			for i, commit := range page.Commits {
+				hashes[i] = commit.Hash
+			}
+			if got := fmt.Sprint(hashes); got != test.hashes {
+				t.Errorf("got commits %s, want %s", got, test.hashes)
+			}
+			if page.Total != test.total || page.Limit != test.filter.Limit || page.Offset != test.filter.Offset {
+				t.Errorf("got total %d, limit %d and offset %d, want %d, %d and %d",
+					page.Total, page.Limit, page.Offset, test.total, test.filter.Limit, test.filter.Offset)
+			}
+		})
+	}
+
+	// Only changed files count
+	page, err := db.GetCommits("p", CommitFilter{Limit: 1, Offset: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CommitInfo{
+		Hash:         commits[1].Hash,
+		Author:       "Bob",
+		Date:         day(2).Add(12 * time.Hour),
+		Message:      "Fix main",
+		Category:     CategoryBugfix,
+		FilesTouched: 1,
+		LinesAdded:   10,
+		LinesDeleted: 5,
+	}
+	got := page.Commits[0]
+	if got.Date.Equal(want.Date) {
+		got.Date = want.Date
+	}
+	if got != want {
+		t.Errorf("got commit %+v, want %+v", got, want)
+	}
+
+	page, err = db.GetCommits("r", CommitFilter{Limit: 10})
+	if err != nil || page.Commits == nil || len(page.Commits) != 0 || page.Total != 0 {
+		t.Errorf("got page %+v and error %v for an unknown project, want an empty page", page, err)
+	}
+}
+
+func TestGetFiles(t *testing.T) {
+	db := openTestDB(t)
+	commits := seedProjects(t, db)
+
+	files, err := db.GetFiles("p", commits[1].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[{main.go Go 105 0 0 12 10 5} {util.go Go 40 0 0 3 0 0}]"
+	if got := fmt.Sprint(files); got != want {
+		t.Errorf("got files %s, want %s", got, want)
+	}
+
+	for _, hash := range []string{"p-9", "q-0"} {
+		if _, err := db.GetFiles("p", hash); !errors.Is(err, ErrCommitNotFound) {
+			t.Errorf("got error %v for commit %s, want %v", err, hash, ErrCommitNotFound)
+		}
+	}
+}
+
+func TestGetContributors(t *testing.T) {
+	db := openTestDB(t)
+	seedProjects(t, db)
+	if err := db.SetTeams([]Team{{Name: "Apps", Members: []string{"Bob"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	contributors, err := db.GetContributors("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name, team              string
+		commits, added, deleted int
+		firstCommit, lastCommit int
+	}{
+		// Ordered by commits, then by name
+		{"Alice", UnassignedTeam, 2, 160, 0, 1, 4},
+		{"Bob", "Apps", 2, 13, 6, 2, 3},
+	}
+	if len(contributors) != len(want) {
+		t.Fatalf("got %+v, want %+v", contributors, want)
+	}
+	for i, w := range want {
+		c := contributors[i]
+		if c.Contributor != w.name || c.Team != w.team || c.Commits != w.commits || c.LinesAdded != w.added || c.LinesDeleted != w.deleted ||
+			c.FirstCommit.Day() != w.firstCommit || c.LastCommit.Day() != w.lastCommit {
+			t.Errorf("got %+v, want %+v", c, w)
+		}
+	}
+
+	if _, err := db.GetContributors("r"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v for an unknown project, want %v", err, ErrProjectNotFound)
+	}
+}
+
+func TestGetHotspots(t *testing.T) {
+	db := openTestDB(t)
+	seedProjects(t, db)
+
+	hotspots, err := db.GetHotspots("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// main.go scores 3 revisions * 12, util.go 1 * 3 and README.md 1 * 0
+	want := []Hotspot{
+		{Path: "main.go", Language: "Go", Sloc: 105, Complexity: 12, Revisions: 3, Churn: 119, Authors: 2, Score: 1},
+		{Path: "util.go", Language: "Go", Sloc: 40, Complexity: 3, Revisions: 1, Churn: 40, Authors: 1, Score: 3.0 / 36},
+		{Path: "README.md", Language: "Go", Sloc: 20, Revisions: 1, Churn: 20, Authors: 1},
+	}
+	if len(hotspots) != len(want) {
+		t.Fatalf("got %+v, want %+v", hotspots, want)
+	}
+	for i := range want {
+		if hotspots[i] != want[i] {
+			t.Errorf("got %+v, want %+v", hotspots[i], want[i])
+		}
+	}
+
+	if _, err := db.GetHotspots("r"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v for an unknown project, want %v", err, ErrProjectNotFound)
+	}
+}
+
+func TestDeleteProject(t *testing.T) {
+	db := openTestDB(t)
+	seedProjects(t, db)
+	if err := db.SetComponents("p", []Component{{Name: "Main", Paths: []string{"main.go"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteProject("p"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetProject("p"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v after deleting, want %v", err, ErrProjectNotFound)
+	}
+	if _, err := db.GetComponents("p"); !errors.Is(err, ErrNoComponents) {
+		t.Errorf("got error %v for components after deleting, want %v", err, ErrNoComponents)
+	}
+	if _, err := db.GetProject("q"); err != nil {
+		t.Errorf("got error %v for another project, want it to remain", err)
+	}
+
+	var filestates int
+	if err := db.QueryRow("SELECT COUNT(*) FROM filestates").Scan(&filestates); err != nil {
+		t.Fatal(err)
+	}
+	if filestates != 0 {
+		t.Errorf("got %d filestates after deleting, want 0", filestates)
+	}
+
+	if err := db.DeleteProject("p"); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("got error %v deleting twice, want %v", err, ErrProjectNotFound)
+	}
+}
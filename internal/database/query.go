@@ -0,0 +1,309 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/marcboeker/go-duckdb/v2"
+)
+
+var (
+	// ErrInvalidQuery is returned for ad-hoc queries that fail to parse, run
+	// or read anything but the query views.
+	ErrInvalidQuery = errors.New("invalid query")
+
+	// QueryViews are the views ad-hoc queries may read.
+	QueryViews = []string{"v_commits", "v_files_latest", "v_churn", "v_hotspots"}
+)
+
+// createViewsStmt defines the QueryViews. They are recreated on every start,
+// so their definitions follow the schema.
+const createViewsStmt = `
+	CREATE OR REPLACE VIEW v_commits AS
+	SELECT
+		c.project,
+		c.hash,
+		c.contributor AS author,
+		tm.team,
+		c.author_date,
+		c.utc_offset_minutes,
+		c.message,
+		c.category,
+		COUNT(f.path) AS files_changed,
+		COALESCE(SUM(f.lines_added), 0) AS lines_added,
+		COALESCE(SUM(f.lines_deleted), 0) AS lines_deleted
+	FROM commits c
+	LEFT JOIN filestates f ON f.commit_hash = c.hash AND f.lines_added + f.lines_deleted > 0
+	LEFT JOIN team_members tm ON tm.contributor = c.contributor
+	GROUP BY ALL;
+
+	CREATE OR REPLACE VIEW v_files_latest AS
+	WITH latest AS (
+		SELECT project, hash
+		FROM commits
+		QUALIFY row_number() OVER (PARTITION BY project ORDER BY id DESC) = 1
+	)
+	SELECT
+		l.project,
+		l.hash AS commit_hash,
+		f.path,
+		f.language,
+		f.sloc,
+		f.cloc AS comments,
+		f.blank,
+		f.complexity
+	FROM latest l
+	JOIN filestates f ON f.commit_hash = l.hash;
+
+	CREATE OR REPLACE VIEW v_churn AS
+	SELECT
+		c.project,
+		c.hash AS commit_hash,
+		c.contributor AS author,
+		c.author_date,
+		f.path,
+		f.language,
+		f.lines_added,
+		f.lines_deleted,
+		f.lines_added + f.lines_deleted AS churn
+	FROM filestates f
+	JOIN commits c ON c.hash = f.commit_hash
+	WHERE f.lines_added + f.lines_deleted > 0;
+
+	CREATE OR REPLACE VIEW v_hotspots AS
+	WITH changes AS (
+		SELECT project, path, COUNT(*) AS revisions, SUM(churn) AS churn, COUNT(DISTINCT author) AS authors
+		FROM v_churn
+		GROUP BY project, path
+	), scored AS (
+		SELECT
+			l.project,
+			l.path,
+			l.language,
+			l.sloc,
+			l.complexity,
+			COALESCE(ch.revisions, 0) AS revisions,
+			COALESCE(ch.churn, 0) AS churn,
+			COALESCE(ch.authors, 0) AS authors,
+			COALESCE(ch.revisions, 0) * l.complexity AS raw_score
+		FROM v_files_latest l
+		LEFT JOIN changes ch ON ch.project = l.project AND ch.path = l.path
+	)
+	SELECT
+		project,
+		path,
+		language,
+		sloc,
+		complexity,
+		revisions,
+		churn,
+		authors,
+		COALESCE(raw_score / NULLIF(max(raw_score) OVER (PARTITION BY project), 0), 0) AS score
+	FROM scored;`
+
+// QueryResult holds the rows of an ad-hoc query.
+type QueryResult struct {
+	Columns []string `json:"columns"`
+	// Types are the DuckDB types of the columns, like VARCHAR or TIMESTAMP
+	Types []string `json:"types"`
+	Rows  [][]any  `json:"rows"`
+	// Truncated is set, if the query returned more than the maximum number of
+	// rows
+	Truncated bool `json:"truncated"`
+}
+
+// RunQuery runs query, if it is a single SELECT statement that only reads
+// the QueryViews. At most maxRows rows are returned, unless maxRows is zero.
+func (db DB) RunQuery(ctx context.Context, query string, maxRows int) (QueryResult, error) {
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	defer conn.Close()
+
+	if err := checkQuery(ctx, conn, query); err != nil {
+		return QueryResult{}, err
+	}
+
+	var result QueryResult
+	err = conn.Raw(func(driverConn any) error {
+		s, err := driverConn.(*duckdb.Conn).PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		stmt := s.(*duckdb.Stmt)
+		defer stmt.Close()
+
+		// DuckDB reports the type of a prepared statement without running it
+		stmtType, err := stmt.StatementType()
+		if err != nil {
+			return err
+		}
+		if stmtType != duckdb.STATEMENT_TYPE_SELECT {
+			return errors.New("only SELECT statements are allowed")
+		}
+
+		rows, err := stmt.QueryContext(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		result.Columns = rows.Columns()
+		result.Types = make([]string, len(result.Columns))
+		if typed, ok := rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+			for i := range result.Columns {
+				result.Types[i] = typed.ColumnTypeDatabaseTypeName(i)
+			}
+		}
+
+		result.Rows = [][]any{}
+		for {
+			values := make([]driver.Value, len(result.Columns))
+			if err := rows.Next(values); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if maxRows > 0 && len(result.Rows) == maxRows {
+				result.Truncated = true
+				return nil
+			}
+
+			row := make([]any, len(values))
+			for i, v := range values {
+				row[i] = v
+			}
+			result.Rows = append(result.Rows, row)
+		}
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return QueryResult{}, ctx.Err()
+		}
+		return QueryResult{}, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	return result, nil
+}
+
+// checkQuery rejects queries that aren't a single SELECT statement or read
+// anything but the QueryViews and their own common table expressions. DuckDB
+// serializes the parse tree of SELECT statements to JSON, so the tables and
+// table functions a query reads can be checked before it runs.
+func checkQuery(ctx context.Context, conn *sql.Conn, query string) error {
+	var serialized string
+	if err := conn.QueryRowContext(ctx, "SELECT json_serialize_sql(?::VARCHAR)::VARCHAR", query).Scan(&serialized); err != nil {
+		return err
+	}
+
+	var tree struct {
+		Error        bool   `json:"error"`
+		ErrorMessage string `json:"error_message"`
+		Statements   []any  `json:"statements"`
+	}
+	if err := json.Unmarshal([]byte(serialized), &tree); err != nil {
+		return err
+	}
+
+	if tree.Error {
+		return fmt.Errorf("%w: %s", ErrInvalidQuery, tree.ErrorMessage)
+	}
+
+	if len(tree.Statements) != 1 {
+		return fmt.Errorf("%w: expected a single statement", ErrInvalidQuery)
+	}
+
+	ctes := make(map[string]bool)
+	collectCTEs(tree.Statements[0], ctes)
+
+	// Common table expressions named like a table would make references to
+	// that table in their own definition pass as references to themselves
+	rows, err := conn.QueryContext(ctx, "SELECT lower(table_name) FROM information_schema.tables")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return err
+		}
+		if ctes[table] && !slices.Contains(QueryViews, table) {
+			return fmt.Errorf("%w: common table expression %s shadows a table", ErrInvalidQuery, table)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return checkTableRefs(tree.Statements[0], ctes)
+}
+
+func collectCTEs(node any, ctes map[string]bool) {
+	switch node := node.(type) {
+	case map[string]any:
+		if cteMap, ok := node["cte_map"].(map[string]any); ok {
+			entries, _ := cteMap["map"].([]any)
+			for _, entry := range entries {
+				if entry, ok := entry.(map[string]any); ok {
+					if name, ok := entry["key"].(string); ok {
+						ctes[strings.ToLower(name)] = true
+					}
+				}
+			}
+		}
+		for _, child := range node {
+			collectCTEs(child, ctes)
+		}
+	case []any:
+		for _, child := range node {
+			collectCTEs(child, ctes)
+		}
+	}
+}
+
+func checkTableRefs(node any, ctes map[string]bool) error {
+	switch node := node.(type) {
+	case map[string]any:
+		switch node["type"] {
+		case "BASE_TABLE":
+			catalog, _ := node["catalog_name"].(string)
+			schema, _ := node["schema_name"].(string)
+			table, _ := node["table_name"].(string)
+			table = strings.ToLower(table)
+
+			isView := catalog == "" && (schema == "" || schema == "main") && slices.Contains(QueryViews, table)
+			isCTE := catalog == "" && schema == "" && ctes[table]
+			if !isView && !isCTE {
+				return fmt.Errorf("%w: %s can't be queried, use %s", ErrInvalidQuery, table, strings.Join(QueryViews, ", "))
+			}
+		case "TABLE_FUNCTION", "SHOW_REF":
+			return fmt.Errorf("%w: table functions can't be queried, use %s", ErrInvalidQuery, strings.Join(QueryViews, ", "))
+		}
+
+		for _, child := range node {
+			if err := checkTableRefs(child, ctes); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, child := range node {
+			if err := checkTableRefs(child, ctes); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunQueryRejects(t *testing.T) {
+	db := openTestDB(t)
+
+	queries := map[string]string{
+		"copy to":                   "COPY (SELECT * FROM v_commits) TO 'out.csv'",
+		"copy from":                 "COPY commits FROM 'in.csv'",
+		"attach":                    "ATTACH 'other.db' AS other",
+		"detach":                    "DETACH other",
+		"export database":           "EXPORT DATABASE 'dump'",
+		"install":                   "INSTALL httpfs",
+		"load":                      "LOAD httpfs",
+		"insert":                    "INSERT INTO commits SELECT * FROM commits",
+		"delete":                    "DELETE FROM commits",
+		"create table as":           "CREATE TABLE x AS SELECT * FROM v_commits",
+		"set":                       "SET threads = 1",
+		"call":                      "CALL pragma_table_info('commits')",
+		"pragma":                    "PRAGMA table_info('commits')",
+		"pragma version":            "PRAGMA version",
+		"show tables":               "SHOW TABLES",
+		"describe":                  "DESCRIBE commits",
+		"summarize":                 "SUMMARIZE commits",
+		"read_csv":                  "SELECT * FROM read_csv('/etc/passwd')",
+		"read_csv_auto":             "SELECT * FROM read_csv_auto('/etc/passwd')",
+		"read_parquet":              "SELECT * FROM read_parquet('data.parquet')",
+		"read_json":                 "SELECT * FROM read_json('data.json')",
+		"read_text":                 "SELECT * FROM read_text('/etc/passwd')",
+		"glob":                      "SELECT * FROM glob('/etc/*')",
+		"duckdb_tables":             "SELECT * FROM duckdb_tables()",
+		"pragma function":           "SELECT * FROM pragma_table_info('commits')",
+		"range":                     "SELECT * FROM range(10)",
+		"file path":                 "SELECT * FROM '/etc/passwd'",
+		"csv file":                  "SELECT * FROM 'data.csv'",
+		"parquet file":              "FROM 'data.parquet'",
+		"base table":                "SELECT * FROM commits",
+		"from first":                "FROM filestates",
+		"qualified table":           "SELECT * FROM main.commits",
+		"catalog qualified":         "SELECT * FROM memory.main.commits",
+		"qualified view":            "SELECT * FROM other.v_commits",
+		"information schema":        "SELECT * FROM information_schema.tables",
+		"system table":              "SELECT * FROM duckdb_settings()",
+		"multiple statements":       "SELECT * FROM v_commits; SELECT * FROM v_churn",
+		"multiple with write":       "SELECT 1; DELETE FROM commits",
+		"cte shadowing table":       "WITH commits AS (SELECT * FROM commits) SELECT * FROM commits",
+		"cte shadowing other table": "WITH filestates AS (SELECT * FROM v_churn) SELECT * FROM filestates, commits",
+		"nested cte shadowing":      "SELECT * FROM (WITH commits AS (SELECT * FROM commits) SELECT * FROM commits)",
+		"uppercase cte shadowing":   "WITH COMMITS AS (SELECT * FROM Commits) SELECT * FROM commits",
+		"cte reading table":         "WITH c AS (SELECT * FROM commits) SELECT * FROM c",
+		"subquery":                  "SELECT * FROM (SELECT * FROM commits)",
+		"in subquery":               "SELECT * FROM v_commits WHERE hash IN (SELECT commit_hash FROM filestates)",
+		"exists subquery":           "SELECT * FROM v_commits WHERE EXISTS (SELECT 1 FROM commits)",
+		"scalar subquery":           "SELECT (SELECT COUNT(*) FROM commits) AS n",
+		"join":                      "SELECT * FROM v_commits JOIN commits USING (hash)",
+		"join condition subquery":   "SELECT * FROM v_commits a JOIN v_commits b ON a.hash = (SELECT MIN(hash) FROM commits)",
+		"lateral join":              "SELECT * FROM v_commits v, LATERAL (SELECT * FROM commits c WHERE c.hash = v.hash)",
+		"union":                     "SELECT hash FROM v_commits UNION SELECT hash FROM commits",
+		"union all":                 "SELECT hash FROM v_commits UNION ALL SELECT hash FROM commits",
+		"except":                    "SELECT hash FROM v_commits EXCEPT SELECT hash FROM commits",
+		"intersect":                 "SELECT hash FROM v_commits INTERSECT SELECT hash FROM commits",
+		"table function in join":    "SELECT * FROM v_commits, read_csv('/etc/passwd')",
+		"table function in cte":     "WITH f AS (SELECT * FROM glob('/etc/*')) SELECT * FROM f",
+		"pivot":                     "PIVOT commits ON project",
+		"values with table":         "SELECT * FROM (VALUES (1)) v(x) WHERE x IN (SELECT id FROM commits)",
+		"syntax error":              "SELECT FROM WHERE",
+	}
+
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			if _, err := db.RunQuery(context.Background(), query, 10); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidQuery)
+			}
+		})
+	}
+}
+
+func TestRunQueryAccepts(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.PersistCommits(context.Background(), testCommits("github.com/o/p", 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := map[string]string{
+		"commits":             "SELECT project, hash, author FROM v_commits",
+		"latest files":        "SELECT * FROM v_files_latest",
+		"churn":               "SELECT * FROM v_churn",
+		"hotspots":            "SELECT path, score FROM v_hotspots ORDER BY score DESC",
+		"qualified view":      "SELECT * FROM main.v_commits",
+		"uppercase view":      "SELECT * FROM V_COMMITS",
+		"from first":          "FROM v_commits",
+		"cte":                 "WITH c AS (SELECT * FROM v_commits) SELECT COUNT(*) FROM c",
+		"cte named like view": "WITH v_churn AS (SELECT * FROM v_commits) SELECT * FROM v_churn",
+		"subquery":            "SELECT * FROM v_commits WHERE hash IN (SELECT commit_hash FROM v_churn)",
+		"lateral join":        "SELECT * FROM v_commits v, LATERAL (SELECT * FROM v_churn c WHERE c.commit_hash = v.hash)",
+		"union":               "SELECT hash FROM v_commits UNION SELECT commit_hash FROM v_churn",
+		"aggregate":           "SELECT author, COUNT(*) AS commits FROM v_commits GROUP BY author",
+		"column named table":  "SELECT hash AS commits, project AS filestates FROM v_commits",
+		"no table":            "SELECT 1 + 1",
+		"trailing semicolon":  "SELECT * FROM v_commits;",
+	}
+
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			if _, err := db.RunQuery(context.Background(), query, 10); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	result, err := db.RunQuery(context.Background(), "SELECT hash FROM v_commits ORDER BY author_date", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 2 || !result.Truncated || result.Columns[0] != "hash" || result.Types[0] != "VARCHAR" {
+		t.Errorf("got %+v, want 2 of 3 hashes", result)
+	}
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/marcboeker/go-duckdb/v2"
+)
+
+// FormatValue formats a value returned by DuckDB as text.
+func FormatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []byte:
+		return string(v)
+	case duckdb.Decimal:
+		return fmt.Sprint(v.Float64())
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// WriteCSV writes the columns and rows of r as CSV.
+func (r QueryResult) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(r.Columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(r.Columns))
+	for _, row := range r.Rows {
+		for i, v := range row {
+			record[i] = FormatValue(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteArrow writes r as an Arrow IPC stream with a single record batch.
+// Numbers, booleans and timestamps keep their type, all other values are
+// written as text.
+func (r QueryResult) WriteArrow(w io.Writer) error {
+	fields := make([]arrow.Field, len(r.Columns))
+	for i, column := range r.Columns {
+		fields[i] = arrow.Field{Name: column, Type: arrowType(r.Types[i]), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	for _, row := range r.Rows {
+		for i, v := range row {
+			if err := appendArrow(builder.Field(i), v); err != nil {
+				return fmt.Errorf("column %s: %w", r.Columns[i], err)
+			}
+		}
+	}
+
+	record := builder.NewRecord()
+	defer record.Release()
+
+	writer := ipc.NewWriter(w, ipc.WithSchema(schema))
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+func arrowType(duckdbType string) arrow.DataType {
+	switch {
+	case duckdbType == "BOOLEAN":
+		return arrow.FixedWidthTypes.Boolean
+	case duckdbType == "TINYINT", duckdbType == "SMALLINT", duckdbType == "INTEGER", duckdbType == "BIGINT":
+		return arrow.PrimitiveTypes.Int64
+	case duckdbType == "UTINYINT", duckdbType == "USMALLINT", duckdbType == "UINTEGER", duckdbType == "UBIGINT":
+		return arrow.PrimitiveTypes.Uint64
+	case duckdbType == "FLOAT", duckdbType == "DOUBLE", strings.HasPrefix(duckdbType, "DECIMAL"):
+		return arrow.PrimitiveTypes.Float64
+	case duckdbType == "DATE", strings.HasPrefix(duckdbType, "TIMESTAMP"):
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+func appendArrow(builder array.Builder, v any) error {
+	if v == nil {
+		builder.AppendNull()
+		return nil
+	}
+
+	switch b := builder.(type) {
+	case *array.BooleanBuilder:
+		value, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("unexpected %T", v)
+		}
+		b.Append(value)
+	case *array.Int64Builder:
+		switch value := v.(type) {
+		case int8:
+			b.Append(int64(value))
+		case int16:
+			b.Append(int64(value))
+		case int32:
+			b.Append(int64(value))
+		case int64:
+			b.Append(value)
+		default:
+			return fmt.Errorf("unexpected %T", v)
+		}
+	case *array.Uint64Builder:
+		switch value := v.(type) {
+		case uint8:
+			b.Append(uint64(value))
+		case uint16:
+			b.Append(uint64(value))
+		case uint32:
+			b.Append(uint64(value))
+		case uint64:
+			b.Append(value)
+		default:
+			return fmt.Errorf("unexpected %T", v)
+		}
+	case *array.Float64Builder:
+		switch value := v.(type) {
+		case float32:
+			b.Append(float64(value))
+		case float64:
+			b.Append(value)
+		case duckdb.Decimal:
+			b.Append(value.Float64())
+		default:
+			return fmt.Errorf("unexpected %T", v)
+		}
+	case *array.TimestampBuilder:
+		value, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("unexpected %T", v)
+		}
+		b.Append(arrow.Timestamp(value.UnixMicro()))
+	case *array.StringBuilder:
+		b.Append(FormatValue(v))
+	}
+
+	return nil
+}
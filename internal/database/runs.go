@@ -0,0 +1,85 @@
+package database
+
+import "time"
+
+// Run is the outcome of an analysis of a project.
+type Run struct {
+	ID         string    `json:"id"`
+	Project    string    `json:"project"`
+	Trigger    string    `json:"trigger"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+func (db DB) RecordRun(run Run) error {
+	_, err := db.Exec(
+		"INSERT OR REPLACE INTO analysis_runs VALUES (?, ?, ?, ?, ?, ?, ?)",
+		run.ID,
+		run.Project,
+		run.Trigger,
+		run.Status,
+		run.Error,
+		run.StartedAt,
+		run.FinishedAt,
+	)
+
+	return err
+}
+
+// GetRuns returns the latest limit runs of project, newest first.
+func (db DB) GetRuns(project string, limit int) ([]Run, error) {
+	rows, err := db.Query(`
+	SELECT id, project, trigger, status, error, started_at, finished_at
+	FROM analysis_runs
+	WHERE project = ?
+	ORDER BY started_at DESC
+	LIMIT ?`, project, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.ID, &r.Project, &r.Trigger, &r.Status, &r.Error, &r.StartedAt, &r.FinishedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// GetLastRunTimes returns when the latest run of each project started.
+func (db DB) GetLastRunTimes() (map[string]time.Time, error) {
+	rows, err := db.Query("SELECT project, max(started_at) FROM analysis_runs GROUP BY project")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastRuns := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			project   string
+			startedAt time.Time
+		)
+		if err := rows.Scan(&project, &startedAt); err != nil {
+			return nil, err
+		}
+		lastRuns[project] = startedAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lastRuns, nil
+}
@@ -0,0 +1,292 @@
+package database
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// UnassignedTeam is reported for contributors that aren't member of any
+	// team.
+	UnassignedTeam = "unassigned"
+
+	// MaxChangesetSize is the maximum number of files a commit may touch to be
+	// considered for change coupling. Larger commits are mostly mechanical
+	// changes like reformatting or license updates.
+	MaxChangesetSize = 50
+
+	// MinSharedCommits is the minimum number of commits two files need to
+	// share to be considered coupled.
+	MinSharedCommits = 2
+)
+
+// Team groups canonical author names, as recorded in commits.contributor.
+type Team struct {
+	Name    string   `json:"name" yaml:"name"`
+	Members []string `json:"members" yaml:"members"`
+}
+
+// LoadTeams reads team definitions from a YAML or JSON file of the form
+// {"teams": [{"name": "Platform", "members": ["Jane Doe"]}]}.
+func LoadTeams(path string) ([]Team, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON, so this handles both formats
+	var definition struct {
+		Teams []Team `yaml:"teams"`
+	}
+	if err := yaml.UnmarshalStrict(content, &definition); err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]string)
+	for _, team := range definition.Teams {
+		if team.Name == "" {
+			return nil, fmt.Errorf("team with members %v has no name", team.Members)
+		}
+		for _, member := range team.Members {
+			if other, exists := members[member]; exists && other != team.Name {
+				return nil, fmt.Errorf("%q is member of teams %q and %q", member, other, team.Name)
+			}
+			members[member] = team.Name
+		}
+	}
+
+	return definition.Teams, nil
+}
+
+// SetTeams replaces all team definitions. Teams are shared by all projects.
+func (db *DB) SetTeams(teams []Team) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM team_members"); err != nil {
+		return err
+	}
+
+	for _, team := range teams {
+		for _, member := range team.Members {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO team_members VALUES (?, ?)", member, team.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db DB) GetTeams() ([]Team, error) {
+	rows, err := db.Query("SELECT team, contributor FROM team_members ORDER BY team, contributor")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []Team
+	for rows.Next() {
+		var name, member string
+		if err := rows.Scan(&name, &member); err != nil {
+			return nil, err
+		}
+
+		if len(teams) == 0 || teams[len(teams)-1].Name != name {
+			teams = append(teams, Team{Name: name})
+		}
+		teams[len(teams)-1].Members = append(teams[len(teams)-1].Members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return teams, nil
+}
+
+// teamChangesCTE lists the churn of every file change of a project together
+// with the team of its author. It expects the unassigned team name and the
+// project as parameters.
+const teamChangesCTE = `
+	team_changes AS (
+		SELECT
+			f.path,
+			c.hash,
+			COALESCE(tm.team, ?) AS team,
+			f.lines_added + f.lines_deleted AS churn
+		FROM filestates f
+		JOIN commits c ON c.hash = f.commit_hash
+		LEFT JOIN team_members tm ON tm.contributor = c.contributor
+		WHERE c.project = ? AND f.lines_added + f.lines_deleted > 0
+	)`
+
+type TeamOwnership struct {
+	Path           string  `json:"path,omitempty"`
+	Component      string  `json:"component,omitempty"`
+	MainTeam       string  `json:"mainTeam"`
+	OwnershipShare float64 `json:"ownershipShare"`
+	Teams          int     `json:"teams"`
+}
+
+// GetTeamOwnership returns the team with the most churn and the number of
+// teams that changed each file of the latest commit of project.
+func (db DB) GetTeamOwnership(project string) ([]TeamOwnership, error) {
+	rows, err := db.Query(`
+	WITH`+teamChangesCTE+`,`+latestFilesCTE+`,
+	team_churn AS (
+		SELECT tc.path, tc.team, SUM(tc.churn) AS churn
+		FROM team_changes tc
+		JOIN latest_files lf ON lf.path = tc.path
+		GROUP BY tc.path, tc.team
+	)
+	SELECT path, arg_max(team, churn), max(churn) / SUM(churn), COUNT(*) AS teams
+	FROM team_churn
+	GROUP BY path
+	ORDER BY teams DESC, path`, UnassignedTeam, project, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ownership []TeamOwnership
+	for rows.Next() {
+		var to TeamOwnership
+		if err := rows.Scan(&to.Path, &to.MainTeam, &to.OwnershipShare, &to.Teams); err != nil {
+			return nil, err
+		}
+		ownership = append(ownership, to)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ownership) == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return ownership, nil
+}
+
+// GetComponentTeamOwnership returns the team with the most churn and the
+// number of teams that changed each component of project.
+func (db DB) GetComponentTeamOwnership(project string) ([]TeamOwnership, error) {
+	if err := db.ensureComponents(project); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+	WITH`+teamChangesCTE+`,`+fileComponentsCTE+`,
+	team_churn AS (
+		SELECT fc.component, tc.team, SUM(tc.churn) AS churn
+		FROM team_changes tc
+		JOIN file_components fc ON fc.path = tc.path
+		GROUP BY fc.component, tc.team
+	)
+	SELECT component, arg_max(team, churn), max(churn) / SUM(churn), COUNT(*) AS teams
+	FROM team_churn
+	GROUP BY component
+	ORDER BY teams DESC, component`, UnassignedTeam, project, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ownership []TeamOwnership
+	for rows.Next() {
+		var to TeamOwnership
+		if err := rows.Scan(&to.Component, &to.MainTeam, &to.OwnershipShare, &to.Teams); err != nil {
+			return nil, err
+		}
+		ownership = append(ownership, to)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ownership, nil
+}
+
+type TeamCoupling struct {
+	Path          string  `json:"path"`
+	Team          string  `json:"team"`
+	Coupled       string  `json:"coupled"`
+	CoupledTeam   string  `json:"coupledTeam"`
+	SharedCommits int     `json:"sharedCommits"`
+	Degree        float64 `json:"degree"`
+}
+
+// GetTeamCoupling returns pairs of files of the latest commit of project that
+// frequently change together, but are mainly owned by different teams.
+// Commits touching more than MaxChangesetSize files are ignored.
+func (db DB) GetTeamCoupling(project string) ([]TeamCoupling, error) {
+	rows, err := db.Query(`
+	WITH`+teamChangesCTE+`,`+latestFilesCTE+`,
+	changes AS (
+		SELECT tc.hash, tc.path
+		FROM team_changes tc
+		JOIN latest_files lf ON lf.path = tc.path
+		WHERE tc.hash IN (
+			SELECT hash
+			FROM team_changes
+			GROUP BY hash
+			HAVING COUNT(*) <= ?
+		)
+	), commits_per_file AS (
+		SELECT path, COUNT(*) AS commits
+		FROM changes
+		GROUP BY path
+	), owners AS (
+		SELECT path, arg_max(team, churn) AS team
+		FROM (
+			SELECT path, team, SUM(churn) AS churn
+			FROM team_changes
+			GROUP BY path, team
+		)
+		GROUP BY path
+	), coupling AS (
+		SELECT
+			a.path AS path,
+			b.path AS coupled,
+			COUNT(*) AS shared_commits,
+			COUNT(*) / ((ca.commits + cb.commits) / 2) AS degree
+		FROM changes a
+		JOIN changes b ON a.hash = b.hash AND a.path < b.path
+		JOIN commits_per_file ca ON ca.path = a.path
+		JOIN commits_per_file cb ON cb.path = b.path
+		GROUP BY a.path, b.path, ca.commits, cb.commits
+		HAVING COUNT(*) >= ?
+	)
+	SELECT cp.path, oa.team, cp.coupled, ob.team, cp.shared_commits, cp.degree
+	FROM coupling cp
+	JOIN owners oa ON oa.path = cp.path
+	JOIN owners ob ON ob.path = cp.coupled
+	WHERE oa.team <> ob.team
+	ORDER BY cp.degree DESC, cp.shared_commits DESC`, UnassignedTeam, project, project, MaxChangesetSize, MinSharedCommits)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var coupling []TeamCoupling
+	for rows.Next() {
+		var tc TeamCoupling
+		if err := rows.Scan(&tc.Path, &tc.Team, &tc.Coupled, &tc.CoupledTeam, &tc.SharedCommits, &tc.Degree); err != nil {
+			return nil, err
+		}
+		coupling = append(coupling, tc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return coupling, nil
+}
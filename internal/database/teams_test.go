@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTeams(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		teams   string
+		err     string
+	}{
+		{"yaml", "teams:\n  - name: Platform\n    members: [Jane Doe, Alice]\n  - name: Apps\n    members: [Bob]\n", "[{Platform [Jane Doe Alice]} {Apps [Bob]}]", ""},
+		{"json", `{"teams": [{"name": "Platform", "members": ["Jane Doe"]}]}`, "[{Platform [Jane Doe]}]", ""},
+		{"member listed twice", "teams:\n  - name: Platform\n    members: [Alice, Alice]\n", "[{Platform [Alice Alice]}]", ""},
+		{"no teams", "", "[]", ""},
+		{"unknown field", "teams:\n  - name: Platform\n    member: [Alice]\n", "", "field member not found"},
+		{"unknown top-level field", "groups: []\n", "", "field groups not found"},
+		{"wrong type", "teams:\n  - name: Platform\n    members: Alice\n", "", "cannot unmarshal"},
+		{"no name", "teams:\n  - members: [Alice]\n", "", "has no name"},
+		{"member of two teams", "teams:\n  - name: Platform\n    members: [Alice]\n  - name: Apps\n    members: [Bob, Alice]\n", "", `"Alice" is member of teams "Platform" and "Apps"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "teams.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			teams, err := LoadTeams(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("got error %v, want error containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(teams); got != test.teams {
+				t.Errorf("got teams %s, want %s", got, test.teams)
+			}
+		})
+	}
+
+	if _, err := LoadTeams(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("got error %v for a missing file, want it not to exist", err)
+	}
+}
+
+func TestSetTeams(t *testing.T) {
+	db := openTestDB(t)
+
+	teams, err := db.GetTeams()
+	if err != nil || len(teams) != 0 {
+		t.Fatalf("got teams %v and error %v, want none", teams, err)
+	}
+
+	tests := []struct {
+		name  string
+		teams []Team
+		want  string
+	}{
+		{
+			"sorted by team and member",
+			[]Team{{Name: "Platform", Members: []string{"Jane Doe", "Alice"}}, {Name: "Apps", Members: []string{"Bob"}}},
+			"[{Apps [Bob]} {Platform [Alice Jane Doe]}]",
+		},
+		{
+			"replaced, without empty teams and duplicate members",
+			[]Team{{Name: "Apps", Members: []string{"Bob", "Carol", "Bob"}}, {Name: "Empty"}},
+			"[{Apps [Bob Carol]}]",
+		},
+		{"removed", nil, "[]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := db.SetTeams(test.teams); err != nil {
+				t.Fatal(err)
+			}
+
+			teams, err := db.GetTeams()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(teams); got != test.want {
+				t.Errorf("got teams %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/sarif"
+)
+
+var (
+	// MaxFileComplexity is the complexity from which on files are reported
+	// as highly complex by default.
+	MaxFileComplexity int64 = 50
+
+	// MinComplexityIncrease is the complexity increase within
+	// RegressionWindow from which on files are reported as regressions by
+	// default.
+	MinComplexityIncrease int64 = 10
+
+	// RegressionWindow is how far back complexity increases are looked for
+	// by default.
+	RegressionWindow = 90 * 24 * time.Hour
+)
+
+// Rules of findings.
+const (
+	FindingHotspot              = "hotspot"
+	FindingHighComplexity       = "high-complexity"
+	FindingComplexityRegression = "complexity-regression"
+)
+
+var findingRules = []sarif.Rule{
+	{
+		ID:                   FindingHotspot,
+		Name:                 "Hotspot",
+		ShortDescription:     sarif.Message{Text: "File is a hotspot"},
+		FullDescription:      &sarif.Message{Text: "The file is complex and changes often. Its code health affects the development effort more than that of other files."},
+		DefaultConfiguration: &sarif.Configuration{Level: sarif.LevelWarning},
+	},
+	{
+		ID:                   FindingHighComplexity,
+		Name:                 "HighComplexity",
+		ShortDescription:     sarif.Message{Text: "File is highly complex"},
+		FullDescription:      &sarif.Message{Text: "The cyclomatic complexity of the file exceeds the configured limit."},
+		DefaultConfiguration: &sarif.Configuration{Level: sarif.LevelWarning},
+	},
+	{
+		ID:                   FindingComplexityRegression,
+		Name:                 "ComplexityRegression",
+		ShortDescription:     sarif.Message{Text: "Complexity of the file increased"},
+		FullDescription:      &sarif.Message{Text: "The cyclomatic complexity of the file increased by more than the configured limit."},
+		DefaultConfiguration: &sarif.Configuration{Level: sarif.LevelWarning},
+	},
+}
+
+// Finding is a code health issue of a file.
+type Finding struct {
+	Rule       string  `json:"rule"`
+	Level      string  `json:"level"`
+	Path       string  `json:"path"`
+	Message    string  `json:"message"`
+	Complexity int64   `json:"complexity"`
+	Delta      int64   `json:"delta,omitempty"`
+	Score      float64 `json:"score,omitempty"`
+}
+
+// FindingThresholds configure which files Findings reports.
+type FindingThresholds struct {
+	// HotspotScore is the hotspot score from which on files are hotspots.
+	HotspotScore float64
+
+	// MaxComplexity is the complexity from which on files are highly complex.
+	MaxComplexity int64
+
+	// MinComplexityIncrease is the complexity increase since Since from which
+	// on files are reported as regressions.
+	MinComplexityIncrease int64
+	Since                 time.Time
+}
+
+// Findings returns the hotspots, highly complex files and complexity
+// regressions of the latest commit of project.
+func Findings(db *database.DB, project string, t FindingThresholds) ([]Finding, error) {
+	hotspots, err := db.GetHotspots(project)
+	if err != nil {
+		return nil, err
+	}
+
+	increases, err := db.GetComplexityIncreases(project, t.Since)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	for _, h := range hotspots {
+		if h.Score >= t.HotspotScore {
+			findings = append(findings, Finding{
+				Rule:       FindingHotspot,
+				Level:      sarif.LevelWarning,
+				Path:       h.Path,
+				Message:    fmt.Sprintf("Hotspot with a score of %.2f, changed in %d commits by %d authors at a complexity of %d", h.Score, h.Revisions, h.Authors, h.Complexity),
+				Complexity: int64(h.Complexity),
+				Score:      h.Score,
+			})
+		}
+	}
+
+	for _, h := range hotspots {
+		if int64(h.Complexity) > t.MaxComplexity {
+			findings = append(findings, Finding{
+				Rule:       FindingHighComplexity,
+				Level:      sarif.LevelWarning,
+				Path:       h.Path,
+				Message:    fmt.Sprintf("Complexity of %d exceeds %d", h.Complexity, t.MaxComplexity),
+				Complexity: int64(h.Complexity),
+				Score:      h.Score,
+			})
+		}
+	}
+
+	for _, c := range increases {
+		delta := int64(c.After - c.Before)
+		if delta >= t.MinComplexityIncrease {
+			findings = append(findings, Finding{
+				Rule:       FindingComplexityRegression,
+				Level:      sarif.LevelWarning,
+				Path:       c.Path,
+				Message:    fmt.Sprintf("Complexity increased by %d from %d to %d since %s", delta, c.Before, c.After, t.Since.Format(time.DateOnly)),
+				Complexity: int64(c.After),
+				Delta:      delta,
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// Findings returns the violations of r concerning files as errors and the
+// other changed hotspots as notes. Comment ratio violations concern the range
+// as a whole and aren't included.
+func (r GateResult) Findings() []Finding {
+	files := make(map[string]GateFile, len(r.Files))
+	for _, f := range r.Files {
+		files[f.Path] = f
+	}
+
+	findings := []Finding{}
+	violated := make(map[string]bool)
+	for _, v := range r.Violations {
+		f, ok := files[v.Path]
+		if !ok {
+			continue
+		}
+
+		finding := Finding{
+			Level:      sarif.LevelError,
+			Path:       f.Path,
+			Message:    v.Message,
+			Complexity: f.ComplexityAfter,
+			Delta:      f.ComplexityDelta(),
+			Score:      f.HotspotScore,
+		}
+		switch v.Rule {
+		case RuleHotspotComplexity:
+			finding.Rule = FindingComplexityRegression
+			violated[f.Path] = true
+		case RuleNewFileComplexity:
+			finding.Rule = FindingHighComplexity
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+
+	for _, f := range r.Files {
+		if f.Hotspot && !f.Deleted && !violated[f.Path] {
+			findings = append(findings, Finding{
+				Rule:       FindingHotspot,
+				Level:      sarif.LevelNote,
+				Path:       f.Path,
+				Message:    fmt.Sprintf("Changes a hotspot with a score of %.2f", f.HotspotScore),
+				Complexity: f.ComplexityAfter,
+				Delta:      f.ComplexityDelta(),
+				Score:      f.HotspotScore,
+			})
+		}
+	}
+
+	return findings
+}
+
+// WriteSARIF writes findings as SARIF log. Locations are relative to the root
+// of the repository.
+func WriteSARIF(w io.Writer, findings []Finding) error {
+	index := make(map[string]int, len(findingRules))
+	for i, rule := range findingRules {
+		index[rule.ID] = i
+	}
+
+	results := make([]sarif.Result, 0, len(findings))
+	for _, f := range findings {
+		properties := map[string]any{"complexity": f.Complexity}
+		if f.Delta != 0 {
+			properties["delta"] = f.Delta
+		}
+		if f.Score != 0 {
+			properties["score"] = f.Score
+		}
+
+		results = append(results, sarif.Result{
+			RuleID:    f.Rule,
+			RuleIndex: index[f.Rule],
+			Level:     f.Level,
+			Message:   sarif.Message{Text: f.Message},
+			Locations: []sarif.Location{sarif.FileLocation(f.Path)},
+			// Keep the identity of results stable while the file changes
+			PartialFingerprints: map[string]string{"codescene/v1": f.Rule + ":" + f.Path},
+			Properties:          properties,
+		})
+	}
+
+	log := sarif.Log{
+		Schema:  sarif.Schema,
+		Version: sarif.Version,
+		Runs: []sarif.Run{{
+			Tool: sarif.Tool{Driver: sarif.Driver{
+				Name:           "codescene",
+				InformationURI: "https://github.com/tim-hilt/codescene",
+				Rules:          findingRules,
+			}},
+			Results: results,
+		}},
+	}
+
+	return log.Write(w)
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tim-hilt/codescene/internal/sarif"
+)
+
+var testFindings = []Finding{
+	{Rule: FindingHotspot, Level: sarif.LevelWarning, Path: "main.go", Message: "Hotspot", Complexity: 12, Score: 1},
+	{Rule: FindingHighComplexity, Level: sarif.LevelError, Path: "util/util.go", Message: "Complex", Complexity: 60},
+	{Rule: FindingComplexityRegression, Level: sarif.LevelNote, Path: "cmd/main.go", Message: "Regression", Complexity: 20, Delta: 15},
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, testFindings); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := os.ReadFile(filepath.Join("testdata", "findings.sarif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got\n%s\nwant\n%s", buf.Bytes(), want)
+	}
+
+	var log sarif.Log
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 {
+		t.Fatalf("got %d runs, want 1", len(log.Runs))
+	}
+
+	// Code scanning resolves results to rules by their index
+	rules := log.Runs[0].Tool.Driver.Rules
+	for _, result := range log.Runs[0].Results {
+		if result.RuleIndex < 0 || result.RuleIndex >= len(rules) || rules[result.RuleIndex].ID != result.RuleID {
+			t.Errorf("result of rule %s has rule index %d", result.RuleID, result.RuleIndex)
+		}
+	}
+}
+
+func TestWriteSARIFWithoutFindings(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var log struct {
+		Runs []struct {
+			Results json.RawMessage `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 || string(log.Runs[0].Results) != "[]" {
+		t.Errorf("got %s, want a run with empty results", buf.Bytes())
+	}
+}
+
+func TestFindings(t *testing.T) {
+	repo := newTestRepo(t)
+	db := openTestDB(t)
+
+	repo.commit("alice", "Add main", map[string]string{"main.go": goFile(1), "util.go": goFile(1)})
+	repo.commit("bob", "Grow main", map[string]string{"main.go": goFile(20)})
+	if err := Analyze(context.Background(), db, testProject, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Increases are counted from the state before the last commit
+	findings, err := Findings(db, testProject, FindingThresholds{
+		HotspotScore:          0.5,
+		MaxComplexity:         10,
+		MinComplexityIncrease: 5,
+		Since:                 repo.date.Add(-12 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.Rule+" "+f.Path)
+	}
+	want := []string{"hotspot main.go", "high-complexity main.go", "complexity-regression main.go"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got findings %q, want %q", got, want)
+	}
+}
+
+func TestGateFindings(t *testing.T) {
+	result := GateResult{
+		Files: []GateFile{
+			{Path: "hot.go", ComplexityBefore: 10, ComplexityAfter: 14, HotspotScore: 0.9, Hotspot: true},
+			{Path: "new.go", Added: true, ComplexityAfter: 30},
+			{Path: "touched.go", ComplexityBefore: 5, ComplexityAfter: 5, HotspotScore: 0.6, Hotspot: true},
+			{Path: "deleted.go", Deleted: true, ComplexityBefore: 8, HotspotScore: 0.7, Hotspot: true},
+		},
+		Violations: []GateViolation{
+			{Rule: RuleHotspotComplexity, Path: "hot.go", Message: "hotspot"},
+			{Rule: RuleNewFileComplexity, Path: "new.go", Message: "new"},
+			{Rule: RuleCommentRatio, Message: "comments"},
+		},
+	}
+
+	var got []string
+	for _, f := range result.Findings() {
+		got = append(got, fmt.Sprintf("%s %s %s %d", f.Level, f.Rule, f.Path, f.Delta))
+	}
+	want := []string{
+		"error complexity-regression hot.go 4",
+		"error high-complexity new.go 30",
+		"note hotspot touched.go 0",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got findings %q, want %q", got, want)
+	}
+}
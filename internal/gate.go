@@ -0,0 +1,359 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/boyter/scc/v3/processor"
+
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/git"
+)
+
+// GateThresholds configure the checks of Gate. Negative limits disable the
+// corresponding check.
+type GateThresholds struct {
+	// HotspotScore is the hotspot score from which on files are hotspots.
+	HotspotScore float64 `json:"hotspotScore"`
+
+	// MaxHotspotIncrease is how much the complexity of a hotspot may increase.
+	MaxHotspotIncrease int64 `json:"maxHotspotIncrease"`
+
+	// MaxNewFileComplexity is the maximum complexity of added files.
+	MaxNewFileComplexity int64 `json:"maxNewFileComplexity"`
+
+	// MaxCommentRatioDecline is by how many percentage points the comment
+	// ratio of the changed files may decline. Added and deleted files aren't
+	// counted.
+	MaxCommentRatioDecline float64 `json:"maxCommentRatioDecline"`
+}
+
+// Rules checked by Gate.
+const (
+	RuleHotspotComplexity = "hotspot-complexity"
+	RuleNewFileComplexity = "new-file-complexity"
+	RuleCommentRatio      = "comment-ratio"
+)
+
+// GateFile is a file changed in the range checked by Gate. Counts of files
+// that don't exist on one side are zero. Renamed files are compared with
+// their previous path.
+type GateFile struct {
+	Path             string  `json:"path"`
+	RenamedFrom      string  `json:"renamedFrom,omitempty"`
+	Language         string  `json:"language"`
+	Added            bool    `json:"added"`
+	Deleted          bool    `json:"deleted"`
+	LinesAdded       int64   `json:"linesAdded"`
+	LinesDeleted     int64   `json:"linesDeleted"`
+	SlocBefore       int64   `json:"slocBefore"`
+	SlocAfter        int64   `json:"slocAfter"`
+	CommentsBefore   int64   `json:"commentsBefore"`
+	CommentsAfter    int64   `json:"commentsAfter"`
+	ComplexityBefore int64   `json:"complexityBefore"`
+	ComplexityAfter  int64   `json:"complexityAfter"`
+	HotspotScore     float64 `json:"hotspotScore"`
+	Hotspot          bool    `json:"hotspot"`
+}
+
+func (f GateFile) ComplexityDelta() int64 {
+	return f.ComplexityAfter - f.ComplexityBefore
+}
+
+// GateViolation is a threshold exceeded by the range. Path is empty for
+// violations of the range as a whole.
+type GateViolation struct {
+	Rule    string `json:"rule"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+type GateResult struct {
+	Project            string          `json:"project"`
+	Base               string          `json:"base"`
+	Head               string          `json:"head"`
+	Thresholds         GateThresholds  `json:"thresholds"`
+	HotspotsAnalyzed   bool            `json:"hotspotsAnalyzed"`
+	Files              []GateFile      `json:"files"`
+	CommentRatioBefore float64         `json:"commentRatioBefore"`
+	CommentRatioAfter  float64         `json:"commentRatioAfter"`
+	Violations         []GateViolation `json:"violations"`
+}
+
+func (r GateResult) Passed() bool {
+	return len(r.Violations) == 0
+}
+
+// ParseRange splits revisions like origin/main..HEAD into base and head. A
+// single revision is compared with HEAD.
+func ParseRange(revisions string) (string, string, error) {
+	base, head, found := strings.Cut(revisions, "..")
+	if !found {
+		head = "HEAD"
+	}
+
+	// base...head means the same as base..head here
+	head = strings.TrimPrefix(head, ".")
+
+	if base == "" || head == "" {
+		return "", "", fmt.Errorf("invalid range %q, use <base>..<head>", revisions)
+	}
+
+	return base, head, nil
+}
+
+// Gate counts the files changed by the commits of the local repository that
+// are reachable from head but not from base and checks them against
+// thresholds. The commits of the range usually aren't analyzed, so the files
+// are counted at both ends instead of being read from the filestates.
+// Hotspots are taken from the analysis of project in db, the check is skipped
+// if project wasn't analyzed.
+func Gate(ctx context.Context, db *database.DB, repository git.Repository, project, base, head string, thresholds GateThresholds) (GateResult, error) {
+	result := GateResult{Project: project, Thresholds: thresholds, Files: []GateFile{}, Violations: []GateViolation{}}
+
+	var err error
+	if result.Head, err = repository.ResolveRevision(ctx, head); err != nil {
+		return GateResult{}, err
+	}
+
+	// Changes on base since the branches diverged aren't part of the range
+	if result.Base, err = repository.MergeBase(ctx, base, result.Head); err != nil {
+		return GateResult{}, err
+	}
+
+	changes, err := repository.DiffRange(ctx, result.Base, result.Head)
+	if err != nil {
+		return GateResult{}, err
+	}
+
+	hotspots, err := db.GetHotspots(project)
+	if err != nil && !errors.Is(err, database.ErrProjectNotFound) {
+		return GateResult{}, err
+	}
+	result.HotspotsAnalyzed = err == nil
+
+	scores := make(map[string]float64, len(hotspots))
+	for _, h := range hotspots {
+		scores[h.Path] = h.Score
+	}
+
+	processor.ProcessConstants()
+
+	for _, change := range changes {
+		// Hotspots were analyzed before the range, so renamed files have
+		// their previous path there
+		basePath := cmp.Or(change.RenameFrom, change.Filename)
+
+		file := GateFile{
+			Path:         change.Filename,
+			RenamedFrom:  change.RenameFrom,
+			LinesAdded:   change.LinesAdded,
+			LinesDeleted: change.LinesDeleted,
+			HotspotScore: scores[basePath],
+		}
+		file.Hotspot = result.HotspotsAnalyzed && file.HotspotScore >= thresholds.HotspotScore
+
+		before, err := countFile(ctx, repository, result.Base, basePath)
+		if err != nil {
+			return GateResult{}, err
+		}
+
+		after, err := countFile(ctx, repository, result.Head, change.Filename)
+		if err != nil {
+			return GateResult{}, err
+		}
+
+		// Only files whose language is counted are checked
+		if (before == nil || before.Language == "") && (after == nil || after.Language == "") {
+			continue
+		}
+
+		file.Added = before == nil
+		file.Deleted = after == nil
+		if before != nil {
+			file.Language = before.Language
+			file.SlocBefore, file.CommentsBefore, file.ComplexityBefore = before.Code, before.Comment, before.Complexity
+		}
+		if after != nil {
+			file.Language = after.Language
+			file.SlocAfter, file.CommentsAfter, file.ComplexityAfter = after.Code, after.Comment, after.Complexity
+		}
+
+		result.Files = append(result.Files, file)
+	}
+
+	slices.SortFunc(result.Files, func(a, b GateFile) int {
+		return cmp.Or(cmp.Compare(b.ComplexityDelta(), a.ComplexityDelta()), strings.Compare(a.Path, b.Path))
+	})
+
+	result.check()
+
+	return result, nil
+}
+
+// countFile counts path at revision. It returns nil if the file doesn't exist
+// there or isn't counted, like binaries and large files.
+func countFile(ctx context.Context, repository git.Repository, revision, path string) (*processor.FileJob, error) {
+	content, err := repository.Show(ctx, revision, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	filestate := database.FileState{CommitHash: revision, FileJob: &processor.FileJob{Filename: path}}
+	newFileJob(content, &filestate)
+	if filestate.FileJob == nil {
+		return nil, nil
+	}
+
+	if err := processFile(&filestate); err != nil && err.Error() != "Missing #!" {
+		return nil, err
+	}
+
+	return filestate.FileJob, nil
+}
+
+// check records the thresholds exceeded by the files of r.
+func (r *GateResult) check() {
+	t := r.Thresholds
+
+	var sloc, comments [2]int64
+	for _, f := range r.Files {
+		// Removing well commented files doesn't make the others worse, and
+		// added files have no ratio to decline from
+		if !f.Deleted && !f.Added {
+			sloc[0], sloc[1] = sloc[0]+f.SlocBefore, sloc[1]+f.SlocAfter
+			comments[0], comments[1] = comments[0]+f.CommentsBefore, comments[1]+f.CommentsAfter
+		}
+
+		if t.MaxHotspotIncrease >= 0 && f.Hotspot && !f.Added && f.ComplexityDelta() > t.MaxHotspotIncrease {
+			r.Violations = append(r.Violations, GateViolation{
+				Rule:    RuleHotspotComplexity,
+				Path:    f.Path,
+				Message: fmt.Sprintf("complexity of hotspot increased by %d from %d to %d", f.ComplexityDelta(), f.ComplexityBefore, f.ComplexityAfter),
+			})
+		}
+
+		if t.MaxNewFileComplexity >= 0 && f.Added && f.ComplexityAfter > t.MaxNewFileComplexity {
+			r.Violations = append(r.Violations, GateViolation{
+				Rule:    RuleNewFileComplexity,
+				Path:    f.Path,
+				Message: fmt.Sprintf("new file has a complexity of %d", f.ComplexityAfter),
+			})
+		}
+	}
+
+	r.CommentRatioBefore = commentRatio(sloc[0], comments[0])
+	r.CommentRatioAfter = commentRatio(sloc[1], comments[1])
+
+	// Ranges that only add or delete files have no ratio to decline from
+	decline := r.CommentRatioBefore - r.CommentRatioAfter
+	if t.MaxCommentRatioDecline >= 0 && sloc[0]+comments[0] > 0 && decline > t.MaxCommentRatioDecline {
+		r.Violations = append(r.Violations, GateViolation{
+			Rule:    RuleCommentRatio,
+			Message: fmt.Sprintf("comment ratio of the changed files declined by %.1f points from %.1f%% to %.1f%%", decline, r.CommentRatioBefore, r.CommentRatioAfter),
+		})
+	}
+}
+
+// commentRatio returns the share of comment lines in percent.
+func commentRatio(sloc, comments int64) float64 {
+	if sloc+comments == 0 {
+		return 0
+	}
+
+	return 100 * float64(comments) / float64(sloc+comments)
+}
+
+// maxGateFiles is the number of changed files listed by WriteMarkdown.
+const maxGateFiles = 25
+
+// WriteMarkdown writes a summary of r suitable for pull request comments.
+func (r GateResult) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+
+	status := "passed ✅"
+	if !r.Passed() {
+		status = "failed ❌"
+	}
+	fmt.Fprintf(&b, "## Code health gate %s\n\n", status)
+	fmt.Fprintf(&b, "Compared `%s` with `%s`: %d changed files.\n\n", git.ShortHash(r.Base), git.ShortHash(r.Head), len(r.Files))
+
+	t := r.Thresholds
+	b.WriteString("| Check | Threshold | Violations |\n|---|---|---|\n")
+	hotspots := r.violations(RuleHotspotComplexity)
+	if !r.HotspotsAnalyzed {
+		hotspots = "skipped, project not analyzed"
+	}
+	fmt.Fprintf(&b, "| Complexity increase in hotspots | %s | %s |\n", limit(t.MaxHotspotIncrease >= 0, fmt.Sprintf("≤ %d", t.MaxHotspotIncrease)), hotspots)
+	fmt.Fprintf(&b, "| Complexity of new files | %s | %s |\n", limit(t.MaxNewFileComplexity >= 0, fmt.Sprintf("≤ %d", t.MaxNewFileComplexity)), r.violations(RuleNewFileComplexity))
+	fmt.Fprintf(&b, "| Comment ratio decline | %s | %s |\n", limit(t.MaxCommentRatioDecline >= 0, fmt.Sprintf("≤ %.1f points", t.MaxCommentRatioDecline)), r.violations(RuleCommentRatio))
+
+	if len(r.Violations) > 0 {
+		b.WriteString("\n### Violations\n\n")
+		for _, v := range r.Violations {
+			if v.Path != "" {
+				fmt.Fprintf(&b, "- `%s`: %s\n", v.Path, v.Message)
+			} else {
+				fmt.Fprintf(&b, "- %s\n", v.Message)
+			}
+		}
+	}
+
+	if len(r.Files) > 0 {
+		b.WriteString("\n### Changed files\n\n")
+		b.WriteString("| File | Complexity | Δ | Comment ratio | Hotspot |\n|---|---:|---:|---:|---|\n")
+		for _, f := range r.Files[:min(len(r.Files), maxGateFiles)] {
+			name := fmt.Sprintf("`%s`", f.Path)
+			if f.RenamedFrom != "" {
+				name = fmt.Sprintf("`%s` → `%s`", f.RenamedFrom, f.Path)
+			}
+			hotspot := ""
+			if f.Hotspot {
+				hotspot = fmt.Sprintf("🔥 %.2f", f.HotspotScore)
+			}
+			fmt.Fprintf(&b, "| %s | %d → %d | %+d | %.1f%% → %.1f%% | %s |\n",
+				name, f.ComplexityBefore, f.ComplexityAfter, f.ComplexityDelta(),
+				commentRatio(f.SlocBefore, f.CommentsBefore), commentRatio(f.SlocAfter, f.CommentsAfter), hotspot)
+		}
+		if len(r.Files) > maxGateFiles {
+			fmt.Fprintf(&b, "\n%d more files aren't listed.\n", len(r.Files)-maxGateFiles)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nComment ratio of the changed files: %.1f%% → %.1f%%\n", r.CommentRatioBefore, r.CommentRatioAfter)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r GateResult) violations(rule string) string {
+	var n int
+	for _, v := range r.Violations {
+		if v.Rule == rule {
+			n++
+		}
+	}
+
+	if n == 0 {
+		return "✅ none"
+	}
+
+	return fmt.Sprintf("❌ %d", n)
+}
+
+func limit(enabled bool, s string) string {
+	if !enabled {
+		return "disabled"
+	}
+
+	return s
+}
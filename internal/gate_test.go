@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tim-hilt/codescene/internal/git"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		revisions  string
+		base, head string
+		valid      bool
+	}{
+		{"origin/main..HEAD", "origin/main", "HEAD", true},
+		{"main...feature", "main", "feature", true},
+		{"main", "main", "HEAD", true},
+		{"..HEAD", "", "", false},
+		{"main..", "", "", false},
+	}
+
+	for _, test := range tests {
+		base, head, err := ParseRange(test.revisions)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: got error %v, want valid %t", test.revisions, err, test.valid)
+			continue
+		}
+		if base != test.base || head != test.head {
+			t.Errorf("%q: got %q and %q, want %q and %q", test.revisions, base, head, test.base, test.head)
+		}
+	}
+}
+
+func TestGateFollowsRenames(t *testing.T) {
+	repo := newTestRepo(t)
+	db := openTestDB(t)
+
+	repo.commit("alice", "Add main", map[string]string{"main.go": goFile(1), "util.go": goFile(1)})
+	repo.commit("alice", "Grow main", map[string]string{"main.go": goFile(2)})
+	repo.commit("bob", "Grow main", map[string]string{"main.go": goFile(3)})
+	if err := Analyze(context.Background(), db, testProject, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.git("checkout", "--quiet", "-b", "feature")
+	repo.commit("bob", "Move main", map[string]string{"main.go": "", "cmd/main.go": goFile(3)})
+	repo.commit("bob", "Grow main", map[string]string{"cmd/main.go": goFile(4)})
+
+	thresholds := GateThresholds{HotspotScore: 0.5, MaxHotspotIncrease: 0, MaxNewFileComplexity: 0, MaxCommentRatioDecline: -1}
+	result, err := Gate(context.Background(), db, git.Open(repo.dir, testProject), testProject, "main", "feature", thresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Files) != 1 {
+		t.Fatalf("got files %+v, want only cmd/main.go", result.Files)
+	}
+	f := result.Files[0]
+	if f.Path != "cmd/main.go" || f.RenamedFrom != "main.go" || f.Added || f.Deleted {
+		t.Errorf("got %+v, want cmd/main.go renamed from main.go", f)
+	}
+	if !f.Hotspot || f.ComplexityBefore == 0 || f.ComplexityDelta() <= 0 {
+		t.Errorf("got %+v, want a hotspot with increased complexity", f)
+	}
+
+	// The renamed file is no new file, but a hotspot that got more complex
+	if len(result.Violations) != 1 || result.Violations[0].Rule != RuleHotspotComplexity || result.Violations[0].Path != "cmd/main.go" {
+		t.Errorf("got violations %+v, want a single %s violation of cmd/main.go", result.Violations, RuleHotspotComplexity)
+	}
+}
+
+func TestGateIgnoresAddedFilesInCommentRatio(t *testing.T) {
+	repo := newTestRepo(t)
+	db := openTestDB(t)
+
+	repo.commit("alice", "Add main", map[string]string{"main.go": "// Package main is commented.\npackage main\n"})
+	repo.git("checkout", "--quiet", "-b", "feature")
+	repo.commit("alice", "Add util", map[string]string{
+		"main.go": "// Package main is commented.\npackage main\n\n// Version is commented as well.\nconst Version = 1\n",
+		"util.go": goFile(3),
+	})
+
+	thresholds := GateThresholds{MaxHotspotIncrease: -1, MaxNewFileComplexity: -1, MaxCommentRatioDecline: 0}
+	result, err := Gate(context.Background(), db, git.Open(repo.dir, testProject), testProject, "main", "feature", thresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Files) != 2 {
+		t.Fatalf("got files %+v, want main.go and util.go", result.Files)
+	}
+	if result.CommentRatioAfter != 50 {
+		t.Errorf("got comment ratio %.1f%% after, want 50%% of main.go alone", result.CommentRatioAfter)
+	}
+	if !result.Passed() {
+		t.Errorf("got violations %+v, want none for an added uncommented file", result.Violations)
+	}
+}
@@ -2,21 +2,22 @@ package git
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"runtime"
 	"strings"
 	"time"
 
 	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/metrics"
 )
 
 var (
-	ErrNoNewCommits = errors.New("no new commits")
-
-	Concurrency = runtime.NumCPU()
+	ErrNoNewCommits     = errors.New("no new commits")
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrNoMergeBase      = errors.New("no common ancestor")
 )
 
 type Repository struct {
@@ -24,7 +25,10 @@ type Repository struct {
 	repo string
 }
 
-func Clone(repo string, shallowSince time.Time) (Repository, error) {
+// Clone clones the commits of repo committed after shallowSince, plus their
+// parents, so the changes of all cloned commits can be diffed.
+func Clone(ctx context.Context, repo string, shallowSince time.Time) (Repository, error) {
+	incremental := !shallowSince.IsZero()
 	shallowSince = shallowSince.Add(1 * time.Second)
 
 	destination, err := os.MkdirTemp("", "")
@@ -32,7 +36,8 @@ func Clone(repo string, shallowSince time.Time) (Repository, error) {
 		return Repository{}, err
 	}
 
-	cmd := exec.Command(
+	cmd := exec.CommandContext(
+		ctx,
 		"git",
 		"clone",
 		"--single-branch",
@@ -46,93 +51,274 @@ func Clone(repo string, shallowSince time.Time) (Repository, error) {
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
+	start := time.Now()
 	err = cmd.Run()
+	observe("clone", start, err)
+
+	if err != nil {
+		// Don't leave partial clones behind
+		os.RemoveAll(destination)
+	}
 
-	if strings.Contains(stderr.String(), "error processing shallow info: 4") {
+	// Remote and local transports report this differently
+	if strings.Contains(stderr.String(), "error processing shallow info: 4") ||
+		strings.Contains(stderr.String(), "no commits selected for shallow requests") {
 		return Repository{}, ErrNoNewCommits
 	}
 
 	if err != nil {
-		return Repository{}, err
+		return Repository{}, fmt.Errorf("cloning %s: %w: %s", repo, err, strings.TrimSpace(stderr.String()))
+	}
+
+	if incremental {
+		cmd := exec.CommandContext(ctx, "git", "fetch", "--deepen=1")
+		cmd.Dir = destination
+
+		start := time.Now()
+		output, err := cmd.CombinedOutput()
+		observe("fetch", start, err)
+
+		if err != nil {
+			os.RemoveAll(destination)
+			return Repository{}, fmt.Errorf("deepening clone of %s: %w: %s", repo, err, strings.TrimSpace(string(output)))
+		}
 	}
 
 	return Repository{destination, repo}, nil
 }
 
-func (r Repository) Log(errs chan error) (chan database.Commit, int, chan database.FileState, int, error) {
-	cmd := exec.Command("git", "log", "--reverse", "--pretty=format:%H;%aI;%an;%s")
+// InitBare creates an empty bare repository for repo, into which revisions
+// can be fetched.
+func InitBare(ctx context.Context, repo string) (Repository, error) {
+	destination, err := os.MkdirTemp("", "")
+	if err != nil {
+		return Repository{}, err
+	}
+
+	repository := Repository{destination, repo}
+	if _, err := repository.git(ctx, "init", "--quiet", "--bare"); err != nil {
+		os.RemoveAll(destination)
+		return Repository{}, err
+	}
+
+	return repository, nil
+}
+
+// Fetch fetches revisions of the repository, which may be branches, tags or
+// commit hashes, as refs/codescene/<i> in the order given. A positive depth
+// limits the history fetched to that many commits from each revision.
+// Objects fetched before are reused.
+func (r Repository) Fetch(ctx context.Context, depth int, revisions ...string) error {
+	args := []string{"fetch", "--quiet", "--no-tags"}
+	if depth > 0 {
+		args = append(args, fmt.Sprintf("--depth=%d", depth))
+	}
+	args = append(args, "https://"+r.repo)
+	for i, revision := range revisions {
+		args = append(args, fmt.Sprintf("+%s:refs/codescene/%d", revision, i))
+	}
+
+	if _, err := r.git(ctx, args...); err != nil {
+		// Servers report missing branches and commits differently
+		if msg := err.Error(); strings.Contains(msg, "couldn't find remote ref") || strings.Contains(msg, "not our ref") ||
+			strings.Contains(msg, "unadvertised object") {
+			return fmt.Errorf("fetching %s: %w: %w", r.repo, ErrRevisionNotFound, err)
+		}
+		return fmt.Errorf("fetching %s: %w", r.repo, err)
+	}
+
+	return nil
+}
+
+// DeleteFetched deletes the refs created by Fetch, so the fetched commits are
+// no longer kept alive by them.
+func (r Repository) DeleteFetched(ctx context.Context) error {
+	refs, err := r.git(ctx, "for-each-ref", "--format=%(refname)", "refs/codescene/")
+	if err != nil {
+		return err
+	}
+
+	for ref := range strings.FieldsSeq(refs) {
+		if _, err := r.git(ctx, "update-ref", "-d", ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ShortHash abbreviates a commit hash for display.
+func ShortHash(hash string) string {
+	return hash[:min(len(hash), 10)]
+}
+
+// Open opens the local repository at path as project repo. Unlike clones,
+// opened repositories must not be closed, as that removes them.
+func Open(path, repo string) Repository {
+	return Repository{path, repo}
+}
+
+// RemoteURL returns the URL of the remote origin as configured, without
+// applying url.<base>.insteadOf rewrites.
+func (r Repository) RemoteURL(ctx context.Context) (string, error) {
+	return r.git(ctx, "config", "--get", "remote.origin.url")
+}
+
+// MergeBase returns the hash of the best common ancestor of the revisions a
+// and b. It fails with ErrNoMergeBase, if the histories of a and b don't
+// meet, which may also be because they are shallow.
+func (r Repository) MergeBase(ctx context.Context, a, b string) (string, error) {
+	hash, err := r.git(ctx, "merge-base", a, b)
+
+	// Other errors exit with 128
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return "", fmt.Errorf("%w of %s and %s", ErrNoMergeBase, a, b)
+	}
+
+	return hash, err
+}
+
+// ResolveRevision returns the commit hash of revision.
+func (r Repository) ResolveRevision(ctx context.Context, revision string) (string, error) {
+	return r.git(ctx, "rev-parse", "--verify", "--end-of-options", revision+"^{commit}")
+}
+
+// git runs the git command args in the repository and returns its trimmed
+// output.
+func (r Repository) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
 	cmd.Dir = r.Path
 
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	start := time.Now()
 	stdout, err := cmd.Output()
+	observe(args[0], start, err)
+
 	if err != nil {
-		return nil, -1, nil, -1, err
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(stdout)), nil
+}
+
+// Log returns the commits of the repository, oldest first.
+func (r Repository) Log(ctx context.Context) ([]database.Commit, error) {
+	commits, err := r.log(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(commits) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	return commits, nil
+}
+
+// LogRange returns the commits reachable from head but not from base, oldest
+// first.
+func (r Repository) LogRange(ctx context.Context, base, head string) ([]database.Commit, error) {
+	return r.log(ctx, base+".."+head)
+}
+
+func (r Repository) log(ctx context.Context, args ...string) ([]database.Commit, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"log", "--reverse", "--pretty=format:%H;%aI;%an;%s"}, args...)...)
+	cmd.Dir = r.Path
+
+	start := time.Now()
+	stdout, err := cmd.Output()
+	observe("log", start, err)
+
+	if err != nil {
+		return nil, err
 	}
 
 	if len(stdout) == 0 {
-		return nil, -1, nil, -1, os.ErrNotExist
-	}
-
-	commitsString := string(stdout)
-	commitStrings := strings.Split(strings.TrimSpace(commitsString), "\n")
-
-	cs := make(chan database.Commit)
-	fs := make(chan database.FileState, Concurrency)
-
-	go func() {
-		defer close(cs)
-		defer close(fs)
-
-		for i, commitString := range commitStrings {
-			commit, err := parseCommit(commitString)
-			if err != nil {
-				errs <- err
-				return
-			}
-			commit.Project = r.repo
-			cs <- commit
-
-			var previousHash string
-			if i == 0 {
-				// empty tree hash
-				previousHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
-			} else {
-				previousHash = strings.SplitN(commitStrings[i-1], ";", 2)[0] // TODO: This could be a bit more elegant
-			}
-
-			cmd := exec.Command("git", "diff", "--no-renames", "--numstat", previousHash, commit.Hash)
-			cmd.Dir = r.Path
-
-			stdout, err := cmd.Output()
-
-			if err != nil {
-				errs <- err
-				return
-			}
-
-			if len(stdout) == 0 {
-				continue
-			}
-
-			filechanges := strings.Split(strings.TrimSpace(string(stdout)), "\n")
-			filestates, err := parseFilestates(filechanges)
-
-			if err != nil {
-				errs <- err
-				return
-			}
-
-			for _, filestate := range filestates {
-				filestate.CommitHash = commit.Hash
-				fs <- filestate // TODO: Monitor fullness of channel!
-			}
+		return nil, nil
+	}
+
+	commitStrings := strings.Split(strings.TrimSpace(string(stdout)), "\n")
+	commits := make([]database.Commit, 0, len(commitStrings))
+
+	for _, commitString := range commitStrings {
+		commit, err := parseCommit(commitString)
+		if err != nil {
+			return nil, err
 		}
-	}()
+		commit.Project = r.repo
+		commits = append(commits, commit)
+	}
 
-	return cs, len(commitStrings), fs, len(fs), nil // TODO: Get rid of this hack
+	return commits, nil
 }
 
-func (r Repository) Show(hash, file string) ([]byte, error) {
-	cmd := exec.Command("git", "show", fmt.Sprintf("%s:%s", hash, file))
+// Diff returns the files changed by each of commits. progress is called after
+// each commit with the number of commits diffed so far.
+func (r Repository) Diff(ctx context.Context, commits []database.Commit, progress func(curr, total int)) ([]database.FileState, error) {
+	var filestates []database.FileState
+
+	for i, commit := range commits {
+		var previousHash string
+		if i == 0 {
+			// empty tree hash
+			previousHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+		} else {
+			previousHash = commits[i-1].Hash
+		}
+
+		fs, err := r.diff(ctx, previousHash, commit.Hash, "--no-renames")
+		if err != nil {
+			return nil, err
+		}
+		filestates = append(filestates, fs...)
+
+		progress(i+1, len(commits))
+	}
+
+	return filestates, nil
+}
+
+// DiffRange returns the files changed between the revisions base and head,
+// attributed to head. Renamed files carry their path at base in RenameFrom.
+func (r Repository) DiffRange(ctx context.Context, base, head string) ([]database.FileState, error) {
+	return r.diff(ctx, base, head, "--find-renames")
+}
+
+// diff runs git diff from..to with the given rename option.
+func (r Repository) diff(ctx context.Context, from, to, renames string) ([]database.FileState, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", renames, "--numstat", from, to)
+	cmd.Dir = r.Path
+
+	start := time.Now()
+	stdout, err := cmd.Output()
+	observe("diff", start, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stdout) == 0 {
+		return nil, nil
+	}
+
+	filechanges := strings.Split(strings.TrimSpace(string(stdout)), "\n")
+	filestates, err := parseFilestates(filechanges)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range filestates {
+		filestates[i].CommitHash = to
+	}
+
+	return filestates, nil
+}
+
+func (r Repository) Show(ctx context.Context, hash, file string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", "show", fmt.Sprintf("%s:%s", hash, file))
 	cmd.Dir = r.Path
 
 	var (
@@ -142,9 +328,14 @@ func (r Repository) Show(hash, file string) ([]byte, error) {
 
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
+
+	start := time.Now()
 	err := cmd.Run()
+	observe("show", start, err)
 
-	if strings.Contains(stderr.String(), "does not exist") {
+	// Repositories with a checkout report files that exist in the working
+	// tree differently
+	if strings.Contains(stderr.String(), "does not exist") || strings.Contains(stderr.String(), "exists on disk, but not in") {
 		return nil, os.ErrNotExist
 	}
 
@@ -159,7 +350,10 @@ func (r Repository) Files(hash string) ([]string, error) {
 	cmd := exec.Command("git", "ls-tree", "-r", "--name-only", hash)
 	cmd.Dir = r.Path
 
+	start := time.Now()
 	stdout, err := cmd.Output()
+	observe("ls-tree", start, err)
+
 	if err != nil {
 		return nil, err
 	}
@@ -172,3 +366,10 @@ func (r Repository) Files(hash string) ([]string, error) {
 func (r Repository) Close() error {
 	return os.RemoveAll(r.Path)
 }
+
+// observe records the outcome and duration of a git subprocess started at
+// start.
+func observe(command string, start time.Time, err error) {
+	metrics.GitCommands.WithLabelValues(command, metrics.Outcome(err)).Inc()
+	metrics.GitCommandDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,72 @@
+// Package metrics defines the Prometheus metrics of codescene.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codescene_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "codescene_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	AnalysisRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codescene_analysis_runs_total",
+		Help: "Finished analyses by trigger and status.",
+	}, []string{"trigger", "status"})
+
+	AnalysisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "codescene_analysis_duration_seconds",
+		Help:    "Duration of finished analyses by trigger and status.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+	}, []string{"trigger", "status"})
+
+	AnalysisPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "codescene_analysis_phase_duration_seconds",
+		Help:    "Duration of the phases of analyses.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"phase"})
+
+	GitCommands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codescene_git_commands_total",
+		Help: "Git subprocesses by command and outcome.",
+	}, []string{"command", "outcome"})
+
+	GitCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "codescene_git_command_duration_seconds",
+		Help:    "Duration of git subprocesses by command.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"command"})
+
+	FilestatesProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "codescene_filestates_processed_total",
+		Help: "File states processed in the counting phase of analyses.",
+	})
+
+	CacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codescene_cache_hits_total",
+		Help: "Work saved by reusing earlier results: commits analyzed before and file states carried over from the previous commit.",
+	}, []string{"kind"})
+
+	DatabaseSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "codescene_database_size_bytes",
+		Help: "Size of the database files.",
+	})
+)
+
+// Outcome is the outcome label of err.
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "success"
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/tim-hilt/codescene/internal/metrics"
+)
+
+type Phase string
+
+const (
+	PhaseCloning    Phase = "cloning"
+	PhaseLog        Phase = "log"
+	PhaseDiffing    Phase = "diffing"
+	PhaseCounting   Phase = "counting"
+	PhasePersisting Phase = "persisting"
+	PhaseFilling    Phase = "filling"
+)
+
+// Progress describes the state of the current phase of an analysis. Total is
+// zero, if the amount of work of the phase is unknown.
+type Progress struct {
+	Phase   Phase `json:"phase"`
+	Current int   `json:"current"`
+	Total   int   `json:"total"`
+	// Throughput is the number of items processed per second in this phase
+	Throughput float64 `json:"throughput"`
+	// Elapsed and ETA are given in seconds
+	Elapsed float64 `json:"elapsed"`
+	ETA     float64 `json:"eta"`
+}
+
+// ProgressFunc receives progress updates. It is called from a single
+// goroutine at a time.
+type ProgressFunc func(Progress)
+
+type progressTracker struct {
+	report ProgressFunc
+	phase  Phase
+	total  int
+	start  time.Time
+}
+
+func newProgressTracker(report ProgressFunc) *progressTracker {
+	if report == nil {
+		report = func(Progress) {}
+	}
+
+	return &progressTracker{report: report}
+}
+
+// begin ends the current phase and starts phase with total items of work.
+func (t *progressTracker) begin(phase Phase, total int) {
+	t.end()
+	t.phase = phase
+	t.total = total
+	t.start = time.Now()
+	t.advance(0)
+}
+
+// end records the duration of the current phase, if any.
+func (t *progressTracker) end() {
+	if t.phase == "" {
+		return
+	}
+
+	metrics.AnalysisPhaseDuration.WithLabelValues(string(t.phase)).Observe(time.Since(t.start).Seconds())
+	t.phase = ""
+}
+
+// advance reports that current items of the phase are done.
+func (t *progressTracker) advance(current int) {
+	p := Progress{
+		Phase:   t.phase,
+		Current: current,
+		Total:   t.total,
+		Elapsed: time.Since(t.start).Seconds(),
+	}
+
+	if current > 0 && p.Elapsed > 0 {
+		p.Throughput = float64(current) / p.Elapsed
+		if t.total > current {
+			p.ETA = float64(t.total-current) / p.Throughput
+		}
+	}
+
+	t.report(p)
+}
+
+// progress adapts advance to the callbacks of the git and database packages.
+func (t *progressTracker) progress(current, total int) {
+	t.total = total
+	t.advance(current)
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestProgressTracker(t *testing.T) {
+	var reported []Progress
+	tracker := newProgressTracker(func(p Progress) {
+		reported = append(reported, p)
+	})
+
+	tracker.begin(PhaseCounting, 10)
+	if p := reported[0]; p.Phase != PhaseCounting || p.Current != 0 || p.Total != 10 || p.Throughput != 0 || p.ETA != 0 {
+		t.Errorf("got %+v at the start of the phase", p)
+	}
+
+	// 4 items in 2 seconds leave 6 items for 3 more seconds
+	tracker.start = time.Now().Add(-2 * time.Second)
+	tracker.advance(4)
+	p := reported[1]
+	if math.Abs(p.Throughput-2) > 0.1 || math.Abs(p.ETA-3) > 0.1 {
+		t.Errorf("got throughput %f and ETA %f, want 2 and 3", p.Throughput, p.ETA)
+	}
+
+	// Without a total there's nothing to estimate
+	tracker.begin(PhaseLog, 0)
+	tracker.start = time.Now().Add(-time.Second)
+	tracker.advance(5)
+	if p := reported[3]; p.Throughput == 0 || p.ETA != 0 {
+		t.Errorf("got throughput %f and ETA %f without a total, want an ETA of 0", p.Throughput, p.ETA)
+	}
+
+	// Callbacks of other packages set the total
+	tracker.progress(3, 7)
+	if p := reported[4]; p.Current != 3 || p.Total != 7 {
+		t.Errorf("got %+v, want 3 of 7", p)
+	}
+
+	newProgressTracker(nil).begin(PhaseCloning, 0)
+}
+
+func TestAnalyzeReportsProgress(t *testing.T) {
+	repo := newTestRepo(t)
+	db := openTestDB(t)
+
+	repo.commit("alice", "Add main", map[string]string{"main.go": goFile(1), "README.md": "# p\n"})
+	repo.commit("bob", "Add util", map[string]string{"util/util.go": goFile(2)})
+	repo.commit("alice", "Grow main", map[string]string{"main.go": goFile(3)})
+
+	var phases []Phase
+	last := make(map[Phase]Progress)
+	err := Analyze(context.Background(), db, testProject, false, func(p Progress) {
+		if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+			phases = append(phases, p.Phase)
+		}
+		if p.Total > 0 && p.Current > p.Total || p.ETA < 0 || p.Throughput < 0 || p.Elapsed < 0 {
+			t.Errorf("got invalid progress %+v", p)
+		}
+		last[p.Phase] = p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Phase{PhaseCloning, PhaseLog, PhaseDiffing, PhaseCounting, PhasePersisting, PhaseFilling}
+	if fmt.Sprint(phases) != fmt.Sprint(want) {
+		t.Errorf("got phases %v, want %v", phases, want)
+	}
+
+	// Every phase with a known amount of work completes it, three commits
+	// change four files
+	for phase, total := range map[Phase]int{
+		PhaseLog:        3,
+		PhaseDiffing:    3,
+		PhaseCounting:   4,
+		PhasePersisting: 3 + 4,
+		PhaseFilling:    3,
+	} {
+		if p := last[phase]; p.Current != total || p.Total != total {
+			t.Errorf("got %d of %d at the end of %s, want %d", p.Current, p.Total, phase, total)
+		}
+	}
+}
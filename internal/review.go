@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/git"
+)
+
+var (
+	// LargeChange is the number of changed lines from which on changes count
+	// as risky for their size alone.
+	LargeChange = 400
+
+	// MinReviewCoupling is the coupling degree from which on files are
+	// expected to change together.
+	MinReviewCoupling = 0.5
+)
+
+// Weights of the risk factors in the score of reviews. They add up to 1.
+const (
+	hotspotWeight    = 0.35
+	experienceWeight = 0.25
+	couplingWeight   = 0.2
+	sizeWeight       = 0.2
+)
+
+// Risk levels of reviews.
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+// ReviewFactors are the risk factors of a change, each between 0 and 1.
+type ReviewFactors struct {
+	// Hotspots is the highest hotspot score of the changed files.
+	Hotspots float64 `json:"hotspots"`
+
+	// Experience is the share of changed files the authors never changed
+	// before.
+	Experience float64 `json:"experience"`
+
+	// Coupling is the share of the coupling degree of the changed files to
+	// files that weren't changed.
+	Coupling float64 `json:"coupling"`
+
+	// Size is the number of changed lines relative to LargeChange.
+	Size float64 `json:"size"`
+}
+
+func (f ReviewFactors) score() float64 {
+	score := 100 * (hotspotWeight*f.Hotspots + experienceWeight*f.Experience + couplingWeight*f.Coupling + sizeWeight*f.Size)
+	return math.Round(score*100) / 100
+}
+
+// ReviewFile is a file changed in the reviewed range. The history of renamed
+// files is looked up at their previous path.
+type ReviewFile struct {
+	Path         string  `json:"path"`
+	RenamedFrom  string  `json:"renamedFrom,omitempty"`
+	LinesAdded   int64   `json:"linesAdded"`
+	LinesDeleted int64   `json:"linesDeleted"`
+	HotspotScore float64 `json:"hotspotScore"`
+
+	// AuthorCommits is the number of commits the authors of the range made
+	// to the file before.
+	AuthorCommits int `json:"authorCommits"`
+
+	// MissingCoupled are the files usually changed together with this one
+	// that weren't changed.
+	MissingCoupled []string `json:"missingCoupled"`
+
+	Risk float64 `json:"risk"`
+}
+
+type ReviewResult struct {
+	Project      string        `json:"project"`
+	Base         string        `json:"base"`
+	Head         string        `json:"head"`
+	Commits      int           `json:"commits"`
+	Authors      []string      `json:"authors"`
+	LinesChanged int64         `json:"linesChanged"`
+	Factors      ReviewFactors `json:"factors"`
+	Score        float64       `json:"score"`
+	Level        string        `json:"level"`
+
+	// Files are ordered by their risk, so reviewers know where to focus.
+	Files []ReviewFile `json:"files"`
+}
+
+// Review scores the risk of the commits of repository that are reachable
+// from head but not from base, based on the history of project in db: changed
+// hotspots, the experience of the authors with the changed files, files that
+// usually change together with the changed ones but weren't, and the size of
+// the change.
+func Review(ctx context.Context, db *database.DB, repository git.Repository, project, base, head string) (ReviewResult, error) {
+	result := ReviewResult{Project: project, Authors: []string{}, Files: []ReviewFile{}}
+
+	if _, err := db.GetProject(project); err != nil {
+		return ReviewResult{}, err
+	}
+
+	var err error
+	if result.Head, err = repository.ResolveRevision(ctx, head); err != nil {
+		return ReviewResult{}, err
+	}
+
+	if result.Base, err = repository.MergeBase(ctx, base, result.Head); err != nil {
+		return ReviewResult{}, err
+	}
+
+	commits, err := repository.LogRange(ctx, result.Base, result.Head)
+	if err != nil {
+		return ReviewResult{}, err
+	}
+	result.Commits = len(commits)
+
+	changes, err := repository.DiffRange(ctx, result.Base, result.Head)
+	if err != nil {
+		return ReviewResult{}, err
+	}
+
+	// Experience is counted up to the first commit of the range, which may
+	// have been analyzed already
+	before := time.Now()
+	authors := make(map[string]bool)
+	for _, c := range commits {
+		if !authors[c.Author] {
+			authors[c.Author] = true
+			result.Authors = append(result.Authors, c.Author)
+		}
+
+		date, err := time.Parse(time.RFC3339, c.Date)
+		if err != nil {
+			return ReviewResult{}, err
+		}
+		if date.Before(before) {
+			before = date
+		}
+	}
+
+	hotspots, err := db.GetHotspots(project)
+	if err != nil && !errors.Is(err, database.ErrProjectNotFound) {
+		return ReviewResult{}, err
+	}
+	scores := make(map[string]float64, len(hotspots))
+	for _, h := range hotspots {
+		scores[h.Path] = h.Score
+	}
+
+	experience, err := db.GetFileExperience(project, before)
+	if err != nil {
+		return ReviewResult{}, err
+	}
+	authorCommits := make(map[string]int)
+	for _, e := range experience {
+		if authors[e.Contributor] {
+			authorCommits[e.Path] += e.Commits
+		}
+	}
+
+	coupling, err := db.GetFileCoupling(project)
+	if err != nil {
+		return ReviewResult{}, err
+	}
+
+	// The history knows renamed files by their previous path
+	changed := make(map[string]bool, len(changes))
+	for _, c := range changes {
+		changed[c.Filename] = true
+		if c.RenameFrom != "" {
+			changed[c.RenameFrom] = true
+		}
+	}
+
+	// Coupling is symmetric, but each pair is only listed once
+	type couple struct {
+		path   string
+		degree float64
+	}
+	coupled := make(map[string][]couple)
+	for _, fc := range coupling {
+		if fc.Degree >= MinReviewCoupling {
+			coupled[fc.Path] = append(coupled[fc.Path], couple{fc.Coupled, fc.Degree})
+			coupled[fc.Coupled] = append(coupled[fc.Coupled], couple{fc.Path, fc.Degree})
+		}
+	}
+
+	var unfamiliar int
+	var degree, missingDegree float64
+	for _, c := range changes {
+		path := cmp.Or(c.RenameFrom, c.Filename)
+		file := ReviewFile{
+			Path:           c.Filename,
+			RenamedFrom:    c.RenameFrom,
+			LinesAdded:     c.LinesAdded,
+			LinesDeleted:   c.LinesDeleted,
+			HotspotScore:   scores[path],
+			AuthorCommits:  authorCommits[path],
+			MissingCoupled: []string{},
+		}
+		result.LinesChanged += c.LinesAdded + c.LinesDeleted
+
+		var fileDegree, fileMissingDegree float64
+		for _, other := range coupled[path] {
+			fileDegree += other.degree
+			if !changed[other.path] {
+				fileMissingDegree += other.degree
+				file.MissingCoupled = append(file.MissingCoupled, other.path)
+			}
+		}
+		degree += fileDegree
+		missingDegree += fileMissingDegree
+
+		if file.AuthorCommits == 0 {
+			unfamiliar++
+		}
+
+		result.Factors.Hotspots = max(result.Factors.Hotspots, file.HotspotScore)
+
+		factors := ReviewFactors{
+			Hotspots: file.HotspotScore,
+			Size:     min(1, float64(c.LinesAdded+c.LinesDeleted)/float64(LargeChange)),
+		}
+		if file.AuthorCommits == 0 {
+			factors.Experience = 1
+		}
+		if fileDegree > 0 {
+			factors.Coupling = fileMissingDegree / fileDegree
+		}
+		file.Risk = factors.score()
+
+		result.Files = append(result.Files, file)
+	}
+
+	if len(changes) > 0 {
+		result.Factors.Experience = float64(unfamiliar) / float64(len(changes))
+	}
+	if degree > 0 {
+		result.Factors.Coupling = missingDegree / degree
+	}
+	result.Factors.Size = min(1, float64(result.LinesChanged)/float64(LargeChange))
+
+	result.Score = result.Factors.score()
+	result.Level = riskLevel(result.Score)
+
+	slices.SortFunc(result.Files, func(a, b ReviewFile) int {
+		return cmp.Or(cmp.Compare(b.Risk, a.Risk), strings.Compare(a.Path, b.Path))
+	})
+
+	return result, nil
+}
+
+func riskLevel(score float64) string {
+	switch {
+	case score >= 60:
+		return RiskHigh
+	case score >= 30:
+		return RiskMedium
+	default:
+		return RiskLow
+	}
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/tim-hilt/codescene/internal/git"
+)
+
+func TestReviewFollowsRenames(t *testing.T) {
+	tests := []struct {
+		name           string
+		files          map[string]string
+		missingCoupled []string
+	}{
+		{"renamed file", map[string]string{"main.go": "", "cmd/main.go": goFile(4)}, []string{"util.go"}},
+		{"renamed couple", map[string]string{"main.go": "", "cmd/main.go": goFile(4), "util.go": "", "cmd/util.go": goFile(3)}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepo(t)
+			db := openTestDB(t)
+
+			for i := 1; i <= 3; i++ {
+				repo.commit("alice", "Grow", map[string]string{"main.go": goFile(i), "util.go": goFile(i)})
+			}
+			if err := Analyze(context.Background(), db, testProject, false, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			repo.git("checkout", "--quiet", "-b", "feature")
+			repo.commit("alice", "Move", test.files)
+
+			result, err := Review(context.Background(), db, git.Open(repo.dir, testProject), testProject, "main", "feature")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var main *ReviewFile
+			for i, f := range result.Files {
+				if f.Path == "cmd/main.go" {
+					main = &result.Files[i]
+				}
+			}
+			if main == nil {
+				t.Fatalf("got files %+v, want cmd/main.go", result.Files)
+			}
+			if len(result.Files) != len(test.files)/2 {
+				t.Errorf("got files %+v, want only renamed files", result.Files)
+			}
+
+			if main.RenamedFrom != "main.go" || main.AuthorCommits != 3 || main.HotspotScore == 0 {
+				t.Errorf("got %+v, want the history of main.go", *main)
+			}
+			if fmt.Sprint(main.MissingCoupled) != fmt.Sprint(test.missingCoupled) {
+				t.Errorf("got missing coupled files %v, want %v", main.MissingCoupled, test.missingCoupled)
+			}
+			if result.Factors.Experience != 0 {
+				t.Errorf("got experience factor %v, want 0", result.Factors.Experience)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/auth"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+// Authenticator authenticates requests to the API. Authentication is
+// disabled, if nil, and everyone is treated as admin.
+var Authenticator auth.Authenticator
+
+var errForbidden = errors.New("forbidden")
+
+// authenticate stores the principal making requests to the API and the
+// metrics in their context. It reports false, if the request was rejected.
+func authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if Authenticator == nil || !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" {
+		return r, true
+	}
+
+	p, err := Authenticator.Authenticate(r)
+	if err != nil {
+		w.Header().Add("WWW-Authenticate", `Basic realm="codescene", charset="UTF-8"`)
+		w.Header().Add("WWW-Authenticate", `Bearer realm="codescene"`)
+		writeError(w, err)
+		return r, false
+	}
+
+	return r.WithContext(auth.WithPrincipal(r.Context(), p)), true
+}
+
+// require rejects requests of principals without role. Requests for projects
+// that aren't visible to the principal are answered as if the project didn't
+// exist.
+func require(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := auth.FromContext(r.Context())
+
+		if r.PathValue("host") != "" && !p.CanView(projectName(r)) {
+			writeError(w, database.ErrProjectNotFound)
+			return
+		}
+
+		if !p.Allows(role) {
+			writeError(w, errForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// authorizeRepo returns the project name of repo, if the principal making
+// the request may analyze it.
+func authorizeRepo(r *http.Request, repo string) (string, error) {
+	project, err := internal.SanitizeRepo(repo)
+	if err != nil {
+		return "", err
+	}
+
+	if !auth.FromContext(r.Context()).CanView(project) {
+		return "", errForbidden
+	}
+
+	return project, nil
+}
+
+func (s *Server) me(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, auth.FromContext(r.Context()))
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+// exportProject exports the data of a project in the format given by the
+// format parameter and returns the files as zip archive.
+func (s *Server) exportProject(w http.ResponseWriter, r *http.Request) {
+	format := database.Parquet
+	if f := r.URL.Query().Get("format"); f != "" {
+		format = database.ExportFormat(f)
+	}
+
+	dir, err := os.MkdirTemp("", "codescene-export-")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	project := projectName(r)
+	files, err := s.Export(r.Context(), project, format, dir)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	name := strings.NewReplacer("/", "_", ".", "_").Replace(project) + "-" + string(format) + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	if err := writeZip(w, files); err != nil {
+		log.Err(err).Str("project", project).Msg("Failed to write export")
+	}
+}
+
+func writeZip(w io.Writer, files []string) error {
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+
+		dst, err := zw.Create(path.Base(filepath.ToSlash(file)))
+		if err == nil {
+			_, err = io.Copy(dst, f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
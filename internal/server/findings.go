@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+// projectFindings returns the hotspots, highly complex files and complexity
+// regressions of a project as JSON or, with format=sarif, as SARIF log.
+func (s *Server) projectFindings(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "sarif" {
+		writeError(w, badRequestError{fmt.Errorf("invalid format %q, use json or sarif", format)})
+		return
+	}
+
+	since, err := parseTime(r.URL.Query().Get("since"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if since.IsZero() {
+		since = time.Now().Add(-internal.RegressionWindow)
+	}
+
+	findings, err := internal.Findings(s.DB, projectName(r), internal.FindingThresholds{
+		HotspotScore:          database.HotspotThreshold,
+		MaxComplexity:         internal.MaxFileComplexity,
+		MinComplexityIncrease: internal.MinComplexityIncrease,
+		Since:                 since,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if format != "sarif" {
+		writeJSON(w, findings)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sarif+json")
+	if err := internal.WriteSARIF(w, findings); err != nil {
+		log.Err(err).Msg("Failed to write findings")
+	}
+}
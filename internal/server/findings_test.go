@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/boyter/scc/v3/processor"
+
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/sarif"
+)
+
+func TestProjectFindingsFormats(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p")
+
+	// Projects without files have no hotspots
+	filestate := database.FileState{
+		CommitHash: "github.com/o/p",
+		LinesAdded: 100,
+		FileJob:    &processor.FileJob{Filename: "main.go", Language: "Go", Code: 100, Complexity: 60},
+	}
+	if err := s.PersistFileStates(context.Background(), []database.FileState{filestate}, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format      string
+		status      int
+		contentType string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"json", http.StatusOK, "application/json"},
+		{"sarif", http.StatusOK, "application/sarif+json"},
+		{"xml", http.StatusBadRequest, "application/json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/projects/github.com/o/p/findings?format="+test.format, nil)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+				t.Errorf("got content type %q, want %q", ct, test.contentType)
+			}
+
+			if test.format != "sarif" {
+				return
+			}
+			var log sarif.Log
+			if err := json.Unmarshal(w.Body.Bytes(), &log); err != nil {
+				t.Fatal(err)
+			}
+			if log.Version != sarif.Version || len(log.Runs) != 1 || len(log.Runs[0].Results) != 2 {
+				t.Errorf("got %s, want a SARIF %s log with a hotspot and a highly complex file", w.Body, sarif.Version)
+			}
+		})
+	}
+}
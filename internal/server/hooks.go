@@ -0,0 +1,191 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal"
+)
+
+var (
+	// WebhookSecret is the secret shared with the systems sending webhooks.
+	// Webhooks are disabled, if empty.
+	WebhookSecret string
+
+	// MaxWebhookSize is the maximum size of webhook payloads in bytes.
+	MaxWebhookSize int64 = 25 << 20
+
+	errInvalidSignature = errors.New("invalid signature")
+)
+
+// hookResponse tells the sender what was done with a webhook.
+type hookResponse struct {
+	Project string `json:"project,omitempty"`
+	Job     *Job   `json:"job,omitempty"`
+	Ignored string `json:"ignored,omitempty"`
+}
+
+// githubHook handles push events of GitHub, signed with HMAC-SHA256 in the
+// X-Hub-Signature-256 header.
+func (s *Server) githubHook(w http.ResponseWriter, r *http.Request) {
+	body, err := readHook(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !validSignature(r.Header.Get("X-Hub-Signature-256"), body) {
+		writeError(w, errInvalidSignature)
+		return
+	}
+
+	if event := r.Header.Get("X-GitHub-Event"); event != "push" {
+		writeJSON(w, hookResponse{Ignored: "event " + event})
+		return
+	}
+
+	var payload struct {
+		Repository struct {
+			HTMLURL string `json:"html_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeError(w, badRequestError{err})
+		return
+	}
+
+	s.enqueueHook(w, payload.Repository.HTMLURL)
+}
+
+// gitlabHook handles push events of GitLab. GitLab doesn't sign payloads,
+// but sends the secret token in the X-Gitlab-Token header. Projects in
+// subgroups are rejected, as project names have the form
+// <host>/<group>/<name>.
+func (s *Server) gitlabHook(w http.ResponseWriter, r *http.Request) {
+	body, err := readHook(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(WebhookSecret)) != 1 {
+		writeError(w, errInvalidSignature)
+		return
+	}
+
+	var payload struct {
+		ObjectKind string `json:"object_kind"`
+		Project    struct {
+			WebURL string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeError(w, badRequestError{err})
+		return
+	}
+
+	if payload.ObjectKind != "push" {
+		writeJSON(w, hookResponse{Ignored: "event " + payload.ObjectKind})
+		return
+	}
+
+	s.enqueueHook(w, payload.Project.WebURL)
+}
+
+// genericHook handles payloads of the form {"repo": "<url>"} from any other
+// system, signed like GitHub's in the X-Signature-256 header.
+func (s *Server) genericHook(w http.ResponseWriter, r *http.Request) {
+	body, err := readHook(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !validSignature(r.Header.Get("X-Signature-256"), body) {
+		writeError(w, errInvalidSignature)
+		return
+	}
+
+	var payload struct {
+		Repo string `json:"repo"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeError(w, badRequestError{err})
+		return
+	}
+
+	s.enqueueHook(w, payload.Repo)
+}
+
+func readHook(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if WebhookSecret == "" {
+		return nil, errNotFound
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxWebhookSize))
+	if err != nil {
+		return nil, badRequestError{err}
+	}
+
+	return body, nil
+}
+
+// validSignature checks a signature of the form sha256=<hex HMAC of body>.
+func validSignature(header string, body []byte) bool {
+	signature, found := strings.CutPrefix(header, "sha256=")
+	if !found {
+		return false
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(WebhookSecret))
+	mac.Write(body)
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// enqueueHook queues an incremental analysis of the pushed repository, if it
+// was analyzed before. Webhooks can't add new projects.
+func (s *Server) enqueueHook(w http.ResponseWriter, repoURL string) {
+	if repoURL == "" {
+		writeError(w, badRequestError{errors.New("payload contains no repository")})
+		return
+	}
+
+	project, err := internal.SanitizeRepo(strings.TrimSuffix(repoURL, ".git"))
+	if err != nil {
+		writeError(w, badRequestError{err})
+		return
+	}
+
+	if _, err := s.GetProject(project); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	job, _, err := s.jobs.Submit(project, false, TriggerWebhook)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	log.Info().Str("project", project).Str("job", job.ID).Msg("Queued analysis for webhook")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	writeJSON(w, hookResponse{Project: project, Job: &job})
+}
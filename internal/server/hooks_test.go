@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+const testSecret = "s3cr3t"
+
+// newTestServer returns a server on a new database holding the given
+// projects. Jobs are queued, but not run.
+func newTestServer(t *testing.T, projects ...string) *Server {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	db, err := database.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for i, project := range projects {
+		commit := database.Commit{
+			Hash:    project,
+			Author:  "Alice",
+			Message: "Initial commit",
+			Date:    time.Date(2025, 1, 1+i, 0, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			Project: project,
+		}
+		if err := db.PersistCommits(context.Background(), []database.Commit{commit}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	workers := AnalysisWorkers
+	AnalysisWorkers = 0
+	s := New(db)
+	AnalysisWorkers = workers
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func readPayload(t *testing.T, name string) []byte {
+	t.Helper()
+
+	body, err := os.ReadFile(filepath.Join("testdata", "hooks", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestHooks(t *testing.T) {
+	githubPush := readPayload(t, "github-push.json")
+	githubPing := readPayload(t, "github-ping.json")
+	gitlabPush := readPayload(t, "gitlab-push.json")
+	gitlabTagPush := readPayload(t, "gitlab-tag-push.json")
+	gitlabSubgroup := readPayload(t, "gitlab-push-subgroup.json")
+	generic := []byte(`{"repo": "https://github.com/o/p.git"}`)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    []byte
+		header  map[string]string
+		status  int
+		project string
+		ignored string
+		error   string
+	}{
+		{"github push", "/hooks/github", githubPush, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(githubPush)}, http.StatusAccepted, "github.com/o/p", "", ""},
+		{"github ping", "/hooks/github", githubPing, map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": sign(githubPing)}, http.StatusOK, "", "event ping", ""},
+		{"github invalid signature", "/hooks/github", githubPush, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(githubPing)}, http.StatusUnauthorized, "", "", "invalid signature"},
+		{"github signature without prefix", "/hooks/github", githubPush, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": strings.TrimPrefix(sign(githubPush), "sha256=")}, http.StatusUnauthorized, "", "", "invalid signature"},
+		{"github missing signature", "/hooks/github", githubPush, map[string]string{"X-GitHub-Event": "push"}, http.StatusUnauthorized, "", "", "invalid signature"},
+		{"gitlab push", "/hooks/gitlab", gitlabPush, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": testSecret}, http.StatusAccepted, "gitlab.com/o/p", "", ""},
+		{"gitlab tag push", "/hooks/gitlab", gitlabTagPush, map[string]string{"X-Gitlab-Event": "Tag Push Hook", "X-Gitlab-Token": testSecret}, http.StatusOK, "", "event tag_push", ""},
+		{"gitlab subgroup", "/hooks/gitlab", gitlabSubgroup, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": testSecret}, http.StatusBadRequest, "", "", "nested groups"},
+		{"gitlab invalid token", "/hooks/gitlab", gitlabPush, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, http.StatusUnauthorized, "", "", "invalid signature"},
+		{"gitlab missing token", "/hooks/gitlab", gitlabPush, map[string]string{"X-Gitlab-Event": "Push Hook"}, http.StatusUnauthorized, "", "", "invalid signature"},
+		{"generic", "/hooks/generic", generic, map[string]string{"X-Signature-256": sign(generic)}, http.StatusAccepted, "github.com/o/p", "", ""},
+		{"generic unknown project", "/hooks/generic", []byte(`{"repo": "github.com/o/unknown"}`), map[string]string{"X-Signature-256": sign([]byte(`{"repo": "github.com/o/unknown"}`))}, http.StatusNotFound, "", "", "project not found"},
+		{"generic without repo", "/hooks/generic", []byte(`{}`), map[string]string{"X-Signature-256": sign([]byte(`{}`))}, http.StatusBadRequest, "", "", "no repository"},
+		{"generic invalid signature", "/hooks/generic", generic, map[string]string{"X-Signature-256": sign(githubPush)}, http.StatusUnauthorized, "", "", "invalid signature"},
+		{"generic missing signature", "/hooks/generic", generic, nil, http.StatusUnauthorized, "", "", "invalid signature"},
+	}
+
+	WebhookSecret = testSecret
+	t.Cleanup(func() { WebhookSecret = "" })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t, "github.com/o/p", "gitlab.com/o/p")
+
+			r := httptest.NewRequest(http.MethodPost, test.path, bytes.NewReader(test.body))
+			for k, v := range test.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+
+			if test.error != "" {
+				if !strings.Contains(w.Body.String(), test.error) {
+					t.Errorf("got %s, want error containing %q", w.Body, test.error)
+				}
+				if jobs := s.jobs.List(); len(jobs) != 0 {
+					t.Errorf("got %d jobs, want none", len(jobs))
+				}
+				return
+			}
+
+			var resp hookResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Project != test.project || resp.Ignored != test.ignored {
+				t.Errorf("got project %q and ignored %q, want %q and %q", resp.Project, resp.Ignored, test.project, test.ignored)
+			}
+
+			jobs := s.jobs.List()
+			if test.project == "" {
+				if len(jobs) != 0 {
+					t.Errorf("got %d jobs, want none", len(jobs))
+				}
+				return
+			}
+			if len(jobs) != 1 || jobs[0].Repo != test.project || jobs[0].Trigger != TriggerWebhook || jobs[0].Force {
+				t.Errorf("got jobs %+v, want an incremental webhook analysis of %s", jobs, test.project)
+			}
+		})
+	}
+}
+
+func TestHooksDisabledWithoutSecret(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p")
+
+	body := []byte(`{"repo": "github.com/o/p"}`)
+	r := httptest.NewRequest(http.MethodPost, "/hooks/generic", bytes.NewReader(body))
+	// An empty secret must not make empty signatures valid
+	mac := hmac.New(sha256.New, nil)
+	mac.Write(body)
+	r.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// MaxImportSize is the maximum size of uploaded dumps and of the files
+	// extracted from them in total in bytes.
+	MaxImportSize int64 = 1 << 30
+
+	// MaxImportFiles is the maximum number of files in uploaded dumps.
+	MaxImportFiles = 100
+
+	errImportTooLarge = errors.New("dump too large")
+)
+
+// importDump imports the zip archive returned by exportProject, which may
+// cover several projects.
+func (s *Server) importDump(w http.ResponseWriter, r *http.Request) {
+	// The projects are only known after reading the dump
+	if !canViewAll(r) {
+		writeError(w, errForbidden)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "codescene-import-")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "dump.zip")
+	if err := saveBody(w, r, archive); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	files := filepath.Join(dir, "files")
+	if err := extractZip(archive, files); err != nil {
+		if !errors.Is(err, errImportTooLarge) {
+			err = badRequestError{err}
+		}
+		writeError(w, err)
+		return
+	}
+
+	result, err := s.Import(r.Context(), files)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	log.Info().
+		Strs("projects", result.Projects).
+		Int("commits", result.Commits).
+		Int("skipped", result.Skipped).
+		Msg("Imported dump")
+
+	writeJSON(w, result)
+}
+
+func saveBody(w http.ResponseWriter, r *http.Request, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, http.MaxBytesReader(w, r.Body, MaxImportSize)); err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return fmt.Errorf("%w: more than %d bytes uploaded", errImportTooLarge, MaxImportSize)
+		}
+		return badRequestError{err}
+	}
+
+	return f.Close()
+}
+
+// extractZip extracts the files of archive into dir. Directories in the
+// archive are flattened, so file names must be unique across them. Archives with more than MaxImportFiles files or
+// more than MaxImportSize bytes of content are rejected with
+// errImportTooLarge, regardless of the sizes they declare.
+func extractZip(archive, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	remaining := MaxImportSize
+	for _, file := range zr.File {
+		name := path.Base(file.Name)
+		if strings.HasSuffix(file.Name, "/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if seen[name] {
+			return fmt.Errorf("%s appears more than once in the dump", name)
+		}
+		seen[name] = true
+
+		if len(seen) > MaxImportFiles {
+			return fmt.Errorf("%w: more than %d files", errImportTooLarge, MaxImportFiles)
+		}
+
+		n, err := extractFile(file, filepath.Join(dir, name), remaining)
+		if err != nil {
+			return err
+		}
+		remaining -= n
+	}
+
+	return nil
+}
+
+// extractFile writes the content of file to dst and returns its size. It
+// fails with errImportTooLarge, if the content exceeds limit bytes.
+func extractFile(file *zip.File, dst string, limit int64) (int64, error) {
+	tooLarge := fmt.Errorf("%w: more than %d bytes extracted", errImportTooLarge, MaxImportSize)
+	if file.UncompressedSize64 > uint64(limit) {
+		return 0, tooLarge
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Reading one byte more tells whether the content exceeds the limit
+	n, err := io.Copy(f, io.LimitReader(src, limit+1))
+	if err != nil {
+		return 0, err
+	}
+	if n > limit {
+		return 0, tooLarge
+	}
+
+	return n, f.Close()
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func zipOf(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func postImport(s *Server, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/import", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestImportLimits(t *testing.T) {
+	size, files := MaxImportSize, MaxImportFiles
+	MaxImportSize, MaxImportFiles = 1024, 3
+	t.Cleanup(func() { MaxImportSize, MaxImportFiles = size, files })
+
+	many := map[string][]byte{}
+	for i := range 4 {
+		many[fmt.Sprintf("f%d.jsonl", i)] = []byte("{}")
+	}
+
+	tests := []struct {
+		name   string
+		body   []byte
+		status int
+		error  string
+	}{
+		{"oversized upload", bytes.Repeat([]byte{'x'}, 1025), http.StatusRequestEntityTooLarge, "bytes uploaded"},
+		{"oversized file", zipOf(t, map[string][]byte{"commits.jsonl": make([]byte, 1025)}), http.StatusRequestEntityTooLarge, "bytes extracted"},
+		{"oversized total", zipOf(t, map[string][]byte{"commits.jsonl": make([]byte, 600), "filestates.jsonl": make([]byte, 600)}), http.StatusRequestEntityTooLarge, "bytes extracted"},
+		{"too many files", zipOf(t, many), http.StatusRequestEntityTooLarge, "more than 3 files"},
+		{"not a zip", []byte("commits"), http.StatusBadRequest, "zip"},
+		{"duplicate names", zipOf(t, map[string][]byte{"a/commits.jsonl": []byte("{}"), "b/commits.jsonl": []byte("{}")}), http.StatusBadRequest, "commits.jsonl appears more than once"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+
+			w := postImport(s, test.body)
+			if w.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), test.error) {
+				t.Errorf("got %s, want error containing %q", w.Body, test.error)
+			}
+		})
+	}
+}
+
+func TestImportExportedProject(t *testing.T) {
+	const project = "github.com/o/p"
+
+	src := newTestServer(t, project)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/projects/"+project+"/export?format=jsonl", nil)
+	w := httptest.NewRecorder()
+	src.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	dst := newTestServer(t)
+	for _, want := range []database.ImportResult{
+		{Projects: []string{project}, Commits: 1},
+		{Projects: []string{project}, Skipped: 1},
+	} {
+		resp := postImport(dst, w.Body.Bytes())
+		if resp.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", resp.Code, http.StatusOK, resp.Body)
+		}
+
+		var got database.ImportResult
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/auth"
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/metrics"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// Trigger tells what started a job.
+type Trigger string
+
+const (
+	TriggerAPI      Trigger = "api"
+	TriggerWebhook  Trigger = "webhook"
+	TriggerSchedule Trigger = "schedule"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+	ErrQueueFull   = errors.New("too many queued analyses")
+	ErrShutdown    = errors.New("server is shutting down")
+
+	// MaxQueuedJobs is the number of analyses that may wait for a worker.
+	MaxQueuedJobs = 100
+
+	// JobRetention is how long finished jobs can still be retrieved.
+	JobRetention = 24 * time.Hour
+
+	// maxJobRequestSize is the maximum size of request bodies creating jobs.
+	maxJobRequestSize int64 = 4 << 10
+)
+
+type Job struct {
+	ID         string            `json:"id"`
+	Repo       string            `json:"repo"`
+	Force      bool              `json:"force"`
+	Trigger    Trigger           `json:"trigger"`
+	Status     JobStatus         `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Progress   internal.Progress `json:"progress"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	StartedAt  *time.Time        `json:"startedAt,omitempty"`
+	FinishedAt *time.Time        `json:"finishedAt,omitempty"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (j *Job) finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+// JobManager runs analyses in the background on a bounded number of workers.
+// There is at most one queued or running job per repository.
+type JobManager struct {
+	db *database.DB
+
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	active   map[string]*Job
+	watchers map[string][]chan Job
+
+	// queue holds the jobs waiting for a worker in submission order. Workers
+	// are woken through wake.
+	queue    []*Job
+	wake     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stop     chan struct{}
+	stopOnce sync.Once
+	closing  bool
+}
+
+func NewJobManager(db *database.DB, workers int) *JobManager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m := &JobManager{
+		db:       db,
+		jobs:     make(map[string]*Job),
+		active:   make(map[string]*Job),
+		watchers: make(map[string][]chan Job),
+		wake:     make(chan struct{}, workers),
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+	}
+
+	for range workers {
+		m.wg.Add(1)
+		go m.work()
+	}
+
+	return m
+}
+
+// Submit queues an analysis of repo. If the repository is already queued or
+// being analyzed, the existing job is returned and created is false.
+func (m *JobManager) Submit(repo string, force bool, trigger Trigger) (job Job, created bool, err error) {
+	repo, err = internal.SanitizeRepo(repo)
+	if err != nil {
+		return Job{}, false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closing {
+		return Job{}, false, ErrShutdown
+	}
+
+	m.prune()
+
+	if j, exists := m.active[repo]; exists {
+		return *j, false, nil
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, false, err
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	j := &Job{
+		ID:        id,
+		Repo:      repo,
+		Force:     force,
+		Trigger:   trigger,
+		Status:    JobQueued,
+		CreatedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	if len(m.queue) >= MaxQueuedJobs {
+		cancel()
+		return Job{}, false, ErrQueueFull
+	}
+
+	m.queue = append(m.queue, j)
+	m.jobs[id] = j
+	m.active[repo] = j
+
+	// If no token fits, every worker has one pending and checks the queue
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+
+	return *j, true, nil
+}
+
+// prune forgets jobs that finished more than JobRetention ago. It is called
+// whenever jobs are submitted or finish and must be called with m.mu held.
+func (m *JobManager) prune() {
+	for id, j := range m.jobs {
+		if j.finished() && time.Since(*j.FinishedAt) > JobRetention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (m *JobManager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, exists := m.jobs[id]
+	if !exists {
+		return Job{}, ErrJobNotFound
+	}
+
+	return *j, nil
+}
+
+func (m *JobManager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, *j)
+	}
+
+	return jobs
+}
+
+// Cancel stops a queued or running job. The commits a running analysis
+// stored so far are removed again, so the next analysis picks them up.
+func (m *JobManager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+
+	j, exists := m.jobs[id]
+	if !exists {
+		m.mu.Unlock()
+		return Job{}, ErrJobNotFound
+	}
+
+	if j.finished() {
+		m.mu.Unlock()
+		return *j, ErrJobFinished
+	}
+
+	j.cancel()
+
+	// Running jobs are recorded by their worker once Analyze returns
+	queued := j.Status == JobQueued
+	if queued {
+		m.finish(j, JobCanceled, nil)
+	}
+	canceled := *j
+	m.mu.Unlock()
+
+	if queued {
+		m.record(canceled)
+	}
+
+	return canceled, nil
+}
+
+// Watch returns a channel receiving the state of the job whenever it changes.
+// Intermediate states may be skipped, if the receiver is slow. The channel is
+// closed once the job finished. stop must be called, when the caller is no
+// longer interested in updates.
+func (m *JobManager) Watch(id string) (updates <-chan Job, stop func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, exists := m.jobs[id]
+	if !exists {
+		return nil, nil, ErrJobNotFound
+	}
+
+	ch := make(chan Job, 1)
+	ch <- *j
+
+	if j.finished() {
+		close(ch)
+		return ch, func() {}, nil
+	}
+
+	m.watchers[id] = append(m.watchers[id], ch)
+
+	stop = func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		for i, w := range m.watchers[id] {
+			if w == ch {
+				m.watchers[id] = append(m.watchers[id][:i], m.watchers[id][i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}
+
+	return ch, stop, nil
+}
+
+// Shutdown rejects new jobs, cancels queued ones and waits for the running
+// jobs to finish. If ctx is done first, the running jobs are canceled.
+func (m *JobManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closing = true
+	var canceled []Job
+	for _, j := range m.jobs {
+		if j.Status == JobQueued {
+			m.finish(j, JobCanceled, nil)
+			canceled = append(canceled, *j)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, j := range canceled {
+		m.record(j)
+	}
+
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Close cancels all jobs and waits for the workers to return.
+func (m *JobManager) Close() {
+	m.cancel()
+	m.Shutdown(context.Background())
+}
+
+func (m *JobManager) work() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.wake:
+		}
+
+		for j := m.next(); j != nil; j = m.next() {
+			m.run(j)
+		}
+	}
+}
+
+// next takes the first queued job and marks it running. It returns nil, if
+// no job is queued.
+func (m *JobManager) next() *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.queue) == 0 {
+		return nil
+	}
+	j := m.queue[0]
+	m.queue = m.queue[1:]
+
+	now := time.Now()
+	j.Status = JobRunning
+	j.StartedAt = &now
+	m.notify(j)
+
+	return j
+}
+
+func (m *JobManager) run(j *Job) {
+	log.Info().Str("job", j.ID).Str("repo", j.Repo).Msg("Starting analysis")
+
+	err := internal.Analyze(j.ctx, m.db, j.Repo, j.Force, func(p internal.Progress) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		j.Progress = p
+		m.notify(j)
+	})
+
+	m.mu.Lock()
+	switch {
+	case j.ctx.Err() != nil:
+		m.finish(j, JobCanceled, nil)
+	case err != nil:
+		log.Err(err).Str("job", j.ID).Str("repo", j.Repo).Msg("Analysis failed")
+		m.finish(j, JobFailed, err)
+	default:
+		m.finish(j, JobSucceeded, nil)
+	}
+	finished := *j
+	m.mu.Unlock()
+
+	m.record(finished)
+}
+
+// record stores the finished job j as analysis run. Jobs canceled while
+// queued are recorded as runs that started and finished when they were
+// canceled. record must not be called with m.mu held.
+func (m *JobManager) record(j Job) {
+	metrics.AnalysisRuns.WithLabelValues(string(j.Trigger), string(j.Status)).Inc()
+
+	startedAt := *j.FinishedAt
+	if j.StartedAt != nil {
+		startedAt = *j.StartedAt
+		metrics.AnalysisDuration.WithLabelValues(string(j.Trigger), string(j.Status)).
+			Observe(j.FinishedAt.Sub(startedAt).Seconds())
+	}
+
+	if err := m.db.RecordRun(database.Run{
+		ID:         j.ID,
+		Project:    j.Repo,
+		Trigger:    string(j.Trigger),
+		Status:     string(j.Status),
+		Error:      j.Error,
+		StartedAt:  startedAt,
+		FinishedAt: *j.FinishedAt,
+	}); err != nil {
+		log.Err(err).Str("job", j.ID).Msg("Failed to record analysis run")
+	}
+}
+
+// finish removes j from the queue, if it is still waiting for a worker. It
+// must be called with m.mu held.
+func (m *JobManager) finish(j *Job, status JobStatus, err error) {
+	now := time.Now()
+	j.Status = status
+	j.FinishedAt = &now
+	if err != nil {
+		j.Error = err.Error()
+	}
+	j.cancel()
+
+	delete(m.active, j.Repo)
+	m.queue = slices.DeleteFunc(m.queue, func(queued *Job) bool {
+		return queued == j
+	})
+	m.notify(j)
+
+	for _, ch := range m.watchers[j.ID] {
+		close(ch)
+	}
+	delete(m.watchers, j.ID)
+
+	m.prune()
+}
+
+// notify must be called with m.mu held.
+func (m *JobManager) notify(j *Job) {
+	for _, ch := range m.watchers[j.ID] {
+		// Replace a state the watcher didn't receive yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- *j
+	}
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	p := auth.FromContext(r.Context())
+	jobs := slices.DeleteFunc(s.jobs.List(), func(j Job) bool {
+		return !p.CanView(j.Repo)
+	})
+	slices.SortFunc(jobs, func(a, b Job) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	writeJSON(w, jobs)
+}
+
+type createJobRequest struct {
+	Repo  string `json:"repo"`
+	Force bool   `json:"force"`
+}
+
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request) {
+	var req createJobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequestSize)).Decode(&req); err != nil {
+		writeError(w, badRequestError{err})
+		return
+	}
+
+	if req.Repo == "" {
+		writeError(w, badRequestError{errors.New("repository not provided")})
+		return
+	}
+
+	repo, err := authorizeRepo(r, req.Repo)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	job, created, err := s.jobs.Submit(repo, req.Force, TriggerAPI)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	writeJSON(w, job)
+}
+
+// visibleJob returns the job in the request path, if its repository is
+// visible to the principal making the request.
+func (s *Server) visibleJob(r *http.Request) (Job, error) {
+	job, err := s.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		return Job{}, err
+	}
+
+	if !auth.FromContext(r.Context()).CanView(job.Repo) {
+		return Job{}, ErrJobNotFound
+	}
+
+	return job, nil
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.visibleJob(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, job)
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.visibleJob(r); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	job, err := s.jobs.Cancel(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	writeJSON(w, job)
+}
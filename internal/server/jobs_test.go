@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanceledQueuedJobsAreRecorded(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p", "github.com/o/q")
+
+	canceled, _, err := s.jobs.Submit("github.com/o/p", false, TriggerAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.jobs.Cancel(canceled.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown, _, err := s.jobs.Submit("github.com/o/q", false, TriggerSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.jobs.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, job := range []Job{canceled, shutdown} {
+		runs, err := s.GetRuns(job.Repo, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(runs) != 1 {
+			t.Fatalf("got %d runs of %s, want 1", len(runs), job.Repo)
+		}
+		run := runs[0]
+		if run.ID != job.ID || run.Status != string(JobCanceled) || run.Trigger != string(job.Trigger) {
+			t.Errorf("got run %+v, want canceled run of job %s", run, job.ID)
+		}
+		if !run.StartedAt.Equal(run.FinishedAt) {
+			t.Errorf("got run from %s to %s, want it to start when it was canceled", run.StartedAt, run.FinishedAt)
+		}
+	}
+}
+
+func TestCanceledQueuedJobsFreeTheQueue(t *testing.T) {
+	queued := MaxQueuedJobs
+	MaxQueuedJobs = 1
+	t.Cleanup(func() { MaxQueuedJobs = queued })
+
+	s := newTestServer(t, "github.com/o/p", "github.com/o/q", "github.com/o/r")
+
+	canceled, _, err := s.jobs.Submit("github.com/o/p", false, TriggerAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.jobs.Cancel(canceled.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.jobs.Submit("github.com/o/q", false, TriggerAPI); err != nil {
+		t.Errorf("got error %v after canceling the queued job, want none", err)
+	}
+	if _, _, err := s.jobs.Submit("github.com/o/r", false, TriggerAPI); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got error %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestFinishedJobsArePruned(t *testing.T) {
+	retention := JobRetention
+	JobRetention = time.Millisecond
+	t.Cleanup(func() { JobRetention = retention })
+
+	s := newTestServer(t, "github.com/o/p", "github.com/o/q")
+
+	var jobs []Job
+	for _, repo := range []string{"github.com/o/p", "github.com/o/q"} {
+		job, _, err := s.jobs.Submit(repo, false, TriggerAPI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if _, err := s.jobs.Cancel(jobs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * JobRetention)
+	if _, err := s.jobs.Cancel(jobs[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.jobs.Get(jobs[0].ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("got error %v for the expired job, want %v", err, ErrJobNotFound)
+	}
+}
+
+func TestCreateJobLimitsRequests(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p")
+
+	body := `{"repo": "github.com/o/p", "force": false` + strings.Repeat(" ", int(maxJobRequestSize)) + `}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/jobs", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	if jobs := s.jobs.List(); len(jobs) != 0 {
+		t.Errorf("got jobs %+v, want none", jobs)
+	}
+}
+
+func TestShutdownDrainsRunningJobs(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p")
+
+	queued, _, err := s.jobs.Submit("github.com/o/p", false, TriggerAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A worker busy with an analysis that finishes once released
+	release := make(chan struct{})
+	s.jobs.wg.Add(1)
+	go func() {
+		defer s.jobs.wg.Done()
+		<-release
+	}()
+
+	done := make(chan error)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("got shutdown with error %v before the analysis finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, _, err := s.jobs.Submit("github.com/o/q", false, TriggerAPI); !errors.Is(err, ErrShutdown) {
+		t.Errorf("got error %v submitting during shutdown, want %v", err, ErrShutdown)
+	}
+	if job, err := s.jobs.Get(queued.ID); err != nil || job.Status != JobCanceled {
+		t.Errorf("got queued job %+v and error %v, want it canceled", job, err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("got error %v, want the analysis to finish", err)
+	}
+}
+
+func TestShutdownCancelsRunningJobsAfterTimeout(t *testing.T) {
+	s := newTestServer(t)
+
+	// A worker busy with an analysis that only ends when canceled
+	s.jobs.wg.Add(1)
+	go func() {
+		defer s.jobs.wg.Done()
+		<-s.jobs.ctx.Done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
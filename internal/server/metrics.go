@@ -0,0 +1,163 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/metrics"
+)
+
+// statusRecorder records the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// instrument records the count and duration of requests by route. Requests
+// that match no route are recorded as "unmatched", so scanners can't inflate
+// the number of series.
+func instrument(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+
+	next.ServeHTTP(rec, r)
+
+	route := r.Pattern
+	if route == "" {
+		route = "unmatched"
+	}
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+}
+
+var (
+	projectSlocDesc = prometheus.NewDesc(
+		"codescene_project_sloc",
+		"Source lines of code at the latest commit.",
+		[]string{"project"}, nil,
+	)
+	projectComplexityDesc = prometheus.NewDesc(
+		"codescene_project_complexity",
+		"Total complexity at the latest commit.",
+		[]string{"project"}, nil,
+	)
+	projectHotspotsDesc = prometheus.NewDesc(
+		"codescene_project_hotspots",
+		"Files with a hotspot score of at least the hotspot threshold.",
+		[]string{"project"}, nil,
+	)
+	projectBusFactorDesc = prometheus.NewDesc(
+		"codescene_project_bus_factor",
+		"Smallest number of contributors who added at least half of the lines.",
+		[]string{"project"}, nil,
+	)
+	projectActiveContributorsDesc = prometheus.NewDesc(
+		"codescene_project_active_contributors",
+		"Contributors who committed within the active contributor window.",
+		[]string{"project"}, nil,
+	)
+)
+
+// projectCollector reads the code health of all projects from the database
+// on each scrape, so deleted projects disappear from the metrics.
+type projectCollector struct {
+	db *database.DB
+}
+
+func (c projectCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- projectSlocDesc
+	ch <- projectComplexityDesc
+	ch <- projectHotspotsDesc
+	ch <- projectBusFactorDesc
+	ch <- projectActiveContributorsDesc
+}
+
+func (c projectCollector) Collect(ch chan<- prometheus.Metric) {
+	projects, err := c.db.GetProjects()
+	if err != nil {
+		log.Err(err).Msg("Failed to list projects for metrics")
+		return
+	}
+
+	activeSince := time.Now().Add(-database.ActiveContributorWindow)
+	for _, project := range projects {
+		kpis, err := c.db.GetKPIs(project, database.HotspotThreshold, activeSince)
+		if err != nil {
+			log.Err(err).Str("project", project).Msg("Failed to compute project metrics")
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(projectSlocDesc, prometheus.GaugeValue, float64(kpis.Sloc), project)
+		ch <- prometheus.MustNewConstMetric(projectComplexityDesc, prometheus.GaugeValue, float64(kpis.Complexity), project)
+		ch <- prometheus.MustNewConstMetric(projectHotspotsDesc, prometheus.GaugeValue, float64(kpis.Hotspots), project)
+		ch <- prometheus.MustNewConstMetric(projectBusFactorDesc, prometheus.GaugeValue, float64(kpis.BusFactor), project)
+		ch <- prometheus.MustNewConstMetric(projectActiveContributorsDesc, prometheus.GaugeValue, float64(kpis.ActiveContributors), project)
+	}
+}
+
+// newMetricsHandler serves the operational metrics together with the code
+// health of the projects in db.
+func newMetricsHandler(db *database.DB) http.Handler {
+	projects := prometheus.NewRegistry()
+	projects.MustRegister(projectCollector{db})
+
+	return promhttp.HandlerFor(
+		prometheus.Gatherers{prometheus.DefaultGatherer, projects},
+		promhttp.HandlerOpts{},
+	)
+}
+
+// serveMetrics exposes the metrics in the Prometheus format. They cover all
+// projects, so only principals that can view every project may scrape them.
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !canViewAll(r) {
+		writeError(w, errForbidden)
+		return
+	}
+
+	size, err := s.Size()
+	if err != nil {
+		log.Err(err).Msg("Failed to determine database size")
+	} else {
+		metrics.DatabaseSize.Set(float64(size))
+	}
+
+	s.metrics.ServeHTTP(w, r)
+}
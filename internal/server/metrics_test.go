@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boyter/scc/v3/processor"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tim-hilt/codescene/internal/auth"
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/metrics"
+)
+
+func getMetrics(t *testing.T, s *Server, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestRequestMetrics(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p")
+
+	requests := []struct {
+		path, route, code string
+	}{
+		{"/api/v1/projects", "GET /api/v1/projects", "200"},
+		{"/api/v1/projects/github.com/o/p", "GET /api/v1/projects/{host}/{owner}/{name}", "200"},
+		{"/api/v1/projects/github.com/o/q", "GET /api/v1/projects/{host}/{owner}/{name}", "404"},
+		{"/api/v1/projects/github.com/o/q/hotspots", "GET /api/v1/projects/{host}/{owner}/{name}/hotspots", "404"},
+		// Paths only matched by the fallback routes are grouped
+		{"/api/v1/unknown", "/api/", "404"},
+	}
+
+	for _, request := range requests {
+		counter := metrics.HTTPRequests.WithLabelValues(request.route, http.MethodGet, request.code)
+		before := testutil.ToFloat64(counter)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, request.path, nil))
+
+		if got := testutil.ToFloat64(counter) - before; got != 1 {
+			t.Errorf("%s: got %v more requests of route %q with code %s, want 1", request.path, got, request.route, request.code)
+		}
+	}
+
+	// Durations are recorded per route, not per status code
+	if got := testutil.CollectAndCount(metrics.HTTPRequestDuration); got < 4 {
+		t.Errorf("got %d duration series, want one for each of the 4 routes", got)
+	}
+
+	// Requests without a route at all don't add a series per path
+	unmatched := metrics.HTTPRequests.WithLabelValues("unmatched", http.MethodGet, "404")
+	before := testutil.ToFloat64(unmatched)
+	s.mux = http.NewServeMux()
+	for _, path := range []string{"/a", "/b"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if got := testutil.ToFloat64(unmatched) - before; got != 2 {
+		t.Errorf("got %v more unmatched requests, want 2", got)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p")
+
+	w := getMetrics(t, s, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	for _, name := range []string{
+		"codescene_http_requests_total",
+		"codescene_database_size_bytes",
+		"go_goroutines",
+	} {
+		if !strings.Contains(w.Body.String(), "\n"+name) {
+			t.Errorf("got metrics without %s", name)
+		}
+	}
+	if size := testutil.ToFloat64(metrics.DatabaseSize); size <= 0 {
+		t.Errorf("got database size %v, want it to be positive", size)
+	}
+
+	problems, err := testutil.GatherAndLint(prometheus.DefaultGatherer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		if strings.HasPrefix(problem.Metric, "codescene_") {
+			t.Errorf("%s: %s", problem.Metric, problem.Text)
+		}
+	}
+}
+
+func TestMetricsRequireAllProjects(t *testing.T) {
+	tokens, err := auth.NewTokens([]auth.Token{
+		{Name: "ops", Token: "ops-token-0123456789", Role: auth.RoleViewer},
+		{Name: "team", Token: "team-token-0123456789", Role: auth.RoleAdmin, Projects: []string{"github.com/o/*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Authenticator = tokens
+	t.Cleanup(func() { Authenticator = nil })
+
+	s := newTestServer(t, "github.com/o/p")
+
+	for token, status := range map[string]int{
+		"":                      http.StatusUnauthorized,
+		"team-token-0123456789": http.StatusForbidden,
+		"ops-token-0123456789":  http.StatusOK,
+	} {
+		if w := getMetrics(t, s, token); w.Code != status {
+			t.Errorf("token %q: got status %d, want %d", token, w.Code, status)
+		}
+	}
+}
+
+func TestProjectMetrics(t *testing.T) {
+	// Project r has commits, but no files, so it has no metrics
+	s := newTestServer(t, "github.com/o/p", "github.com/o/q", "github.com/o/r")
+	ctx := context.Background()
+
+	// Bob committed to q a day ago, so he's active
+	recent := database.Commit{
+		Hash:    "recent",
+		Author:  "Bob",
+		Message: "Add util",
+		Date:    time.Now().Add(-24 * time.Hour).Format(time.RFC3339),
+		Project: "github.com/o/q",
+	}
+	if err := s.PersistCommits(ctx, []database.Commit{recent}); err != nil {
+		t.Fatal(err)
+	}
+
+	filestates := []database.FileState{
+		{CommitHash: "github.com/o/p", LinesAdded: 100, FileJob: &processor.FileJob{Filename: "main.go", Language: "Go", Code: 100, Complexity: 7}},
+		{CommitHash: "github.com/o/q", LinesAdded: 50, FileJob: &processor.FileJob{Filename: "main.go", Language: "Go", Code: 50, Complexity: 4}},
+		{CommitHash: "recent", FileJob: &processor.FileJob{Filename: "main.go", Language: "Go", Code: 50, Complexity: 4}},
+		{CommitHash: "recent", LinesAdded: 60, FileJob: &processor.FileJob{Filename: "util.go", Language: "Go", Code: 60, Complexity: 1}},
+	}
+	if err := s.PersistFileStates(ctx, filestates, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// util.go of q has a hotspot score of 1/4, and Bob added more than
+	// half of its lines
+	want := `
+# HELP codescene_project_active_contributors Contributors who committed within the active contributor window.
+# TYPE codescene_project_active_contributors gauge
+codescene_project_active_contributors{project="github.com/o/p"} 0
+codescene_project_active_contributors{project="github.com/o/q"} 1
+# HELP codescene_project_bus_factor Smallest number of contributors who added at least half of the lines.
+# TYPE codescene_project_bus_factor gauge
+codescene_project_bus_factor{project="github.com/o/p"} 1
+codescene_project_bus_factor{project="github.com/o/q"} 1
+# HELP codescene_project_complexity Total complexity at the latest commit.
+# TYPE codescene_project_complexity gauge
+codescene_project_complexity{project="github.com/o/p"} 7
+codescene_project_complexity{project="github.com/o/q"} 5
+# HELP codescene_project_hotspots Files with a hotspot score of at least the hotspot threshold.
+# TYPE codescene_project_hotspots gauge
+codescene_project_hotspots{project="github.com/o/p"} 1
+codescene_project_hotspots{project="github.com/o/q"} 1
+# HELP codescene_project_sloc Source lines of code at the latest commit.
+# TYPE codescene_project_sloc gauge
+codescene_project_sloc{project="github.com/o/p"} 100
+codescene_project_sloc{project="github.com/o/q"} 110
+`
+	collector := projectCollector{s.DB}
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// The endpoint serves them together with the operational metrics
+	if w := getMetrics(t, s, ""); !strings.Contains(w.Body.String(), `codescene_project_sloc{project="github.com/o/q"} 110`) {
+		t.Errorf("got metrics without the projects:\n%s", w.Body)
+	}
+
+	// Deleted projects disappear
+	if err := s.DeleteProject("github.com/o/p"); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(collector, "codescene_project_sloc"); n != 1 {
+		t.Errorf("got sloc of %d projects after deleting one, want 1", n)
+	}
+}
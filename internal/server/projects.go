@@ -0,0 +1,156 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/auth"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func (s *Server) projects(w http.ResponseWriter, r *http.Request) {
+	projects, err := s.GetProjectSummaries()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	p := auth.FromContext(r.Context())
+	projects = slices.DeleteFunc(projects, func(project database.Project) bool {
+		return !p.CanView(project.Name)
+	})
+
+	if projects == nil {
+		projects = []database.Project{}
+	}
+
+	writeJSON(w, projects)
+}
+
+func (s *Server) deleteProject(w http.ResponseWriter, r *http.Request) {
+	project := projectName(r)
+	if err := s.DeleteProject(project); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.reviews.forget(project)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) projectMetadata(w http.ResponseWriter, r *http.Request) {
+	granularity := database.Day
+	if g := r.URL.Query().Get("granularity"); g != "" {
+		granularity = database.Granularity(g)
+	}
+
+	timezone := "UTC"
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		timezone = tz
+	}
+
+	metadata, err := s.GetProjectMetadata(projectName(r), granularity, timezone)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, metadata)
+}
+
+// parseTime parses the value of a time parameter.
+func parseTime(value string) (time.Time, error) {
+	t, err := internal.ParseTime(value)
+	if err != nil {
+		return time.Time{}, badRequestError{err}
+	}
+
+	return t, nil
+}
+
+func (s *Server) projectCommits(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := queryInt(r, "limit", 50, 500)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0, math.MaxInt)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	since, err := parseTime(query.Get("since"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	until, err := parseTime(query.Get("until"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	project := projectName(r)
+	if _, err := s.GetProject(project); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	commits, err := s.GetCommits(project, database.CommitFilter{
+		Author:   query.Get("author"),
+		Category: query.Get("category"),
+		Since:    since,
+		Until:    until,
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, commits)
+}
+
+func (s *Server) projectFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := s.GetFiles(projectName(r), r.PathValue("hash"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, files)
+}
+
+func (s *Server) projectActivity(w http.ResponseWriter, r *http.Request) {
+	granularity := database.Week
+	if g := r.URL.Query().Get("granularity"); g != "" {
+		granularity = database.Granularity(g)
+	}
+
+	activity, err := s.GetActivity(projectName(r), granularity)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, activity)
+}
+
+func (s *Server) projectWorkingHours(w http.ResponseWriter, r *http.Request) {
+	workingHours, err := s.GetWorkingHours(projectName(r), r.URL.Query().Get("author"), r.URL.Query().Get("team"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, workingHours)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal/auth"
+)
+
+var (
+	// MaxQueryRows is the maximum number of rows returned by ad-hoc queries.
+	MaxQueryRows = 10000
+
+	// QueryTimeout is how long ad-hoc queries may run.
+	QueryTimeout = 30 * time.Second
+
+	// maxQuerySize is the maximum size of request bodies with queries.
+	maxQuerySize int64 = 64 << 10
+)
+
+const arrowStreamType = "application/vnd.apache.arrow.stream"
+
+// query runs a read-only SQL query against the query views. The query is
+// passed in the q parameter or as {"query": "..."} in the body of POST
+// requests. The result is returned as JSON, CSV or Arrow IPC stream depending
+// on the format parameter or the Accept header.
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	// The views cover all projects
+	if !canViewAll(r) {
+		writeError(w, errForbidden)
+		return
+	}
+
+	q, err := readQuery(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	format, err := queryFormat(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), QueryTimeout)
+	defer cancel()
+
+	result, err := s.RunQuery(ctx, q, MaxQueryRows)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = badRequestError{fmt.Errorf("query exceeded the timeout of %s", QueryTimeout)}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if result.Truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = result.WriteCSV(w)
+	case "arrow":
+		w.Header().Set("Content-Type", arrowStreamType)
+		err = result.WriteArrow(w)
+	default:
+		writeJSON(w, result)
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to write query result")
+	}
+}
+
+func readQuery(w http.ResponseWriter, r *http.Request) (string, error) {
+	if r.Method != http.MethodPost {
+		if q := r.URL.Query().Get("q"); q != "" {
+			return q, nil
+		}
+		return "", badRequestError{errors.New("q is required")}
+	}
+
+	var body struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQuerySize)).Decode(&body); err != nil {
+		return "", badRequestError{err}
+	}
+
+	if body.Query == "" {
+		return "", badRequestError{errors.New("query is required")}
+	}
+
+	return body.Query, nil
+}
+
+func queryFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		accept := r.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, arrowStreamType):
+			format = "arrow"
+		case strings.Contains(accept, "text/csv"):
+			format = "csv"
+		default:
+			format = "json"
+		}
+	}
+
+	if format != "json" && format != "csv" && format != "arrow" {
+		return "", badRequestError{fmt.Errorf("invalid format %q, use json, csv or arrow", format)}
+	}
+
+	return format, nil
+}
+
+// canViewAll reports whether the principal making the request may view all
+// projects.
+func canViewAll(r *http.Request) bool {
+	return len(auth.FromContext(r.Context()).Projects) == 0
+}
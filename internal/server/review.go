@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/git"
+)
+
+var (
+	// ReviewWorkers is the number of reviews that fetch and score revisions
+	// concurrently. Further reviews wait for a free worker.
+	ReviewWorkers = 2
+
+	// ReviewDepth is the number of commits fetched from base and head for
+	// reviews. Ranges whose base and head diverged earlier can't be reviewed.
+	ReviewDepth = 1000
+
+	// MaxReviewRepos is the number of project repositories kept for reviews.
+	// Beyond that, the least recently used repositories are removed.
+	MaxReviewRepos = 20
+)
+
+// reviewer fetches the revisions of reviews into one repository per project,
+// which is kept, so later reviews reuse the objects fetched before. At most
+// MaxReviewRepos repositories are kept.
+type reviewer struct {
+	db      *database.DB
+	workers chan struct{}
+
+	mu    sync.Mutex
+	repos map[string]*reviewRepo
+}
+
+// reviewRepo is the repository of a project. lock is held while revisions
+// are fetched into it and reviewed, as each fetch moves the refs. users and
+// lastUsed are guarded by the reviewer's mu.
+type reviewRepo struct {
+	git.Repository
+	lock chan struct{}
+
+	users    int
+	lastUsed time.Time
+}
+
+func newReviewer(db *database.DB, workers int) *reviewer {
+	return &reviewer{
+		db:      db,
+		workers: make(chan struct{}, workers),
+		repos:   make(map[string]*reviewRepo),
+	}
+}
+
+// review fetches base and head of project and scores the changes between
+// them.
+func (rv *reviewer) review(ctx context.Context, project, base, head string) (internal.ReviewResult, error) {
+	repo, err := rv.repo(ctx, project)
+	if err != nil {
+		return internal.ReviewResult{}, err
+	}
+	defer rv.release(repo)
+
+	// Reviews of the same project wait for each other without taking up a
+	// worker
+	select {
+	case repo.lock <- struct{}{}:
+	case <-ctx.Done():
+		return internal.ReviewResult{}, ctx.Err()
+	}
+	defer func() { <-repo.lock }()
+
+	select {
+	case rv.workers <- struct{}{}:
+	case <-ctx.Done():
+		return internal.ReviewResult{}, ctx.Err()
+	}
+	defer func() { <-rv.workers }()
+
+	// The refs would keep the fetched history of every reviewed branch
+	defer func() {
+		if err := repo.DeleteFetched(context.WithoutCancel(ctx)); err != nil {
+			log.Err(err).Str("project", project).Msg("Failed to delete fetched revisions")
+		}
+	}()
+
+	if err := repo.Fetch(ctx, ReviewDepth, base, head); err != nil {
+		return internal.ReviewResult{}, err
+	}
+
+	result, err := internal.Review(ctx, rv.db, repo.Repository, project, "refs/codescene/0", "refs/codescene/1")
+	if errors.Is(err, git.ErrNoMergeBase) {
+		return internal.ReviewResult{}, badRequestError{fmt.Errorf("%s and %s have %w in their last %d commits", base, head, git.ErrNoMergeBase, ReviewDepth)}
+	}
+
+	return result, err
+}
+
+// repo returns the repository of project, which is created on first use.
+// It must be released once the review is done.
+func (rv *reviewer) repo(ctx context.Context, project string) (*reviewRepo, error) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	repo, ok := rv.repos[project]
+	if !ok {
+		repository, err := git.InitBare(ctx, project)
+		if err != nil {
+			return nil, err
+		}
+
+		repo = &reviewRepo{Repository: repository, lock: make(chan struct{}, 1)}
+		rv.repos[project] = repo
+	}
+	repo.users++
+	rv.evict()
+
+	return repo, nil
+}
+
+func (rv *reviewer) release(repo *reviewRepo) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	repo.users--
+	repo.lastUsed = time.Now()
+}
+
+// evict removes the least recently used repositories, which no review uses,
+// until at most MaxReviewRepos are left. It must be called with rv.mu held.
+func (rv *reviewer) evict() {
+	for len(rv.repos) > MaxReviewRepos {
+		var oldest string
+		for project, repo := range rv.repos {
+			if repo.users == 0 && (oldest == "" || repo.lastUsed.Before(rv.repos[oldest].lastUsed)) {
+				oldest = project
+			}
+		}
+		if oldest == "" {
+			return
+		}
+
+		rv.remove(oldest, rv.repos[oldest])
+		delete(rv.repos, oldest)
+	}
+}
+
+// forget removes the repository of project once its reviews are done.
+func (rv *reviewer) forget(project string) {
+	rv.mu.Lock()
+	repo, ok := rv.repos[project]
+	delete(rv.repos, project)
+	rv.mu.Unlock()
+
+	if ok {
+		repo.lock <- struct{}{}
+		rv.remove(project, repo)
+		<-repo.lock
+	}
+}
+
+// close removes the repositories of all projects. Running reviews fail.
+func (rv *reviewer) close() {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	for project, repo := range rv.repos {
+		rv.remove(project, repo)
+	}
+	clear(rv.repos)
+}
+
+func (rv *reviewer) remove(project string, repo *reviewRepo) {
+	if err := repo.Close(); err != nil {
+		log.Err(err).Str("project", project).Msg("Failed to remove review repository")
+	}
+}
+
+// projectReview scores the risk of the changes between the revisions base
+// and head of a project. The revisions are fetched from the repository, so
+// they don't need to be analyzed.
+func (s *Server) projectReview(w http.ResponseWriter, r *http.Request) {
+	base, head := r.URL.Query().Get("base"), r.URL.Query().Get("head")
+	if base == "" || head == "" {
+		writeError(w, badRequestError{errors.New("base and head are required")})
+		return
+	}
+
+	// Revisions become part of refspecs and must not be taken for options
+	for _, revision := range []string{base, head} {
+		if strings.HasPrefix(revision, "-") || strings.ContainsAny(revision, ": \\\t\n") {
+			writeError(w, badRequestError{errors.New("invalid revision " + revision)})
+			return
+		}
+	}
+
+	project := projectName(r)
+	if _, err := s.GetProject(project); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result, err := s.reviews.review(r.Context(), project, base, head)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, result)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tim-hilt/codescene/internal"
+)
+
+// gitRepo is a git repository served in place of https://github.com/o/p.
+type gitRepo struct {
+	t   *testing.T
+	dir string
+}
+
+func newGitRepo(t *testing.T) *gitRepo {
+	t.Helper()
+
+	root := t.TempDir()
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "url.file://"+root+"/.insteadOf")
+	t.Setenv("GIT_CONFIG_VALUE_0", "https://github.com/")
+	t.Setenv("GIT_AUTHOR_NAME", "Alice")
+	t.Setenv("GIT_AUTHOR_EMAIL", "alice@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Alice")
+	t.Setenv("GIT_COMMITTER_EMAIL", "alice@example.com")
+
+	r := &gitRepo{t, filepath.Join(root, "o", "p")}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r.git("init", "--quiet", "--initial-branch=main")
+
+	return r
+}
+
+func (r *gitRepo) git(args ...string) {
+	r.t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		r.t.Fatalf("git %v: %v: %s", args, err, output)
+	}
+}
+
+// commit commits a change of file.
+func (r *gitRepo) commit(file, content string) {
+	r.t.Helper()
+
+	if err := os.WriteFile(filepath.Join(r.dir, file), []byte(content), 0o644); err != nil {
+		r.t.Fatal(err)
+	}
+	r.git("add", file)
+	r.git("commit", "--quiet", "-m", "Change "+file)
+}
+
+func getReview(s *Server, base, head string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/projects/github.com/o/p/review?base="+base+"&head="+head, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestProjectReview(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.commit("main.go", "package main\n")
+	repo.commit("util.go", "package main\n")
+	repo.git("checkout", "--quiet", "-b", "feature")
+	repo.commit("main.go", "package main\n\nfunc main() {}\n")
+
+	s := newTestServer(t, "github.com/o/p")
+
+	review := func(head string, commits int) string {
+		t.Helper()
+
+		w := getReview(s, "main", head)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		var result internal.ReviewResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Commits != commits {
+			t.Errorf("got %d commits, want %d", result.Commits, commits)
+		}
+
+		return s.reviews.repos["github.com/o/p"].Path
+	}
+
+	// Later reviews fetch into the same repository
+	path := review("feature", 1)
+	repo.commit("util.go", "package main\n\nfunc util() {}\n")
+	if review("feature", 2) != path {
+		t.Error("got a new repository for the second review")
+	}
+
+	refs, err := exec.Command("git", "-C", path, "for-each-ref", "refs/codescene/").Output()
+	if err != nil || len(refs) != 0 {
+		t.Errorf("got refs %q and error %v after the review, want none", refs, err)
+	}
+
+	if w := getReview(s, "main", "unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown revision, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+
+	r := httptest.NewRequest(http.MethodDelete, "/api/v1/projects/github.com/o/p", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for the repository of the deleted project, want it removed", err)
+	}
+}
+
+func TestProjectReviewDepth(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.commit("main.go", "package main\n")
+	repo.git("checkout", "--quiet", "-b", "feature")
+	repo.commit("main.go", "package main\n\nfunc main() {}\n")
+	repo.commit("main.go", "package main\n\nfunc main() { main() }\n")
+
+	depth := ReviewDepth
+	ReviewDepth = 3
+	t.Cleanup(func() { ReviewDepth = depth })
+
+	s := newTestServer(t, "github.com/o/p")
+
+	if w := getReview(s, "main", "feature"); w.Code != http.StatusOK {
+		t.Errorf("got status %d within the depth, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	repo.commit("main.go", "package main\n\nfunc main() { main(); main() }\n")
+	s = newTestServer(t, "github.com/o/p")
+
+	w := getReview(s, "main", "feature")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "no common ancestor in their last 3 commits") {
+		t.Errorf("got status %d beyond the depth, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
+
+func TestReviewEvictsRepositories(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.commit("main.go", "package main\n")
+	repo.git("clone", "--quiet", "--bare", repo.dir, filepath.Join(filepath.Dir(repo.dir), "q"))
+
+	repos := MaxReviewRepos
+	MaxReviewRepos = 1
+	t.Cleanup(func() { MaxReviewRepos = repos })
+
+	s := newTestServer(t, "github.com/o/p", "github.com/o/q")
+
+	review := func(project string) string {
+		t.Helper()
+
+		if _, err := s.reviews.review(context.Background(), project, "main", "main"); err != nil {
+			t.Fatal(err)
+		}
+		return s.reviews.repos[project].Path
+	}
+
+	// Repositories in use are kept
+	p := review("github.com/o/p")
+	used, err := s.reviews.repo(context.Background(), "github.com/o/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := review("github.com/o/q")
+	if len(s.reviews.repos) != 2 {
+		t.Errorf("got %d repositories, want the used one kept", len(s.reviews.repos))
+	}
+
+	s.reviews.release(used)
+	review("github.com/o/q")
+	if _, ok := s.reviews.repos["github.com/o/p"]; ok || len(s.reviews.repos) != 1 {
+		t.Errorf("got repositories %v, want only github.com/o/q", s.reviews.repos)
+	}
+	if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for the evicted repository, want it removed", err)
+	}
+	if _, err := os.Stat(q); err != nil {
+		t.Errorf("got %v for the recently used repository, want it kept", err)
+	}
+}
+
+func TestReviewWaitsForWorkers(t *testing.T) {
+	newGitRepo(t)
+	s := newTestServer(t, "github.com/o/p")
+
+	// All workers are busy
+	for range cap(s.reviews.workers) {
+		s.reviews.workers <- struct{}{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := s.reviews.review(ctx, "github.com/o/p", "main", "main"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+var (
+	// AnalysisSchedule configures the periodic re-analysis of projects. It is
+	// disabled by default.
+	AnalysisSchedule Schedule
+
+	// MaxScheduleWait is the longest the scheduler sleeps, so it notices newly
+	// analyzed projects.
+	MaxScheduleWait = time.Minute
+
+	// ScheduleRetry is how long the scheduler waits before it retries to
+	// queue an analysis that couldn't be queued, e.g. because the queue was
+	// full.
+	ScheduleRetry = time.Minute
+)
+
+// Interval is a duration like "6h" or one of @hourly, @daily and @weekly.
+type Interval time.Duration
+
+func ParseInterval(s string) (Interval, error) {
+	switch s {
+	case "@hourly":
+		return Interval(time.Hour), nil
+	case "@daily":
+		return Interval(24 * time.Hour), nil
+	case "@weekly":
+		return Interval(7 * 24 * time.Hour), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q, use a duration like 6h or @hourly, @daily or @weekly", s)
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("invalid interval %q, must not be negative", s)
+	}
+
+	return Interval(d), nil
+}
+
+func (i *Interval) UnmarshalText(text []byte) error {
+	interval, err := ParseInterval(string(text))
+	if err != nil {
+		return err
+	}
+
+	*i = interval
+	return nil
+}
+
+// ProjectSchedule overrides the schedule of a single project.
+type ProjectSchedule struct {
+	Interval Interval `yaml:"interval"`
+	Disabled bool     `yaml:"disabled"`
+}
+
+// Schedule configures the periodic re-analysis of all analyzed projects. A
+// project is re-analyzed Interval plus a random duration of up to Jitter
+// after its last analysis. Projects aren't re-analyzed, if their interval is
+// zero.
+type Schedule struct {
+	Interval Interval                   `yaml:"interval"`
+	Jitter   Interval                   `yaml:"jitter"`
+	Projects map[string]ProjectSchedule `yaml:"projects"`
+}
+
+// LoadSchedule reads a schedule from a YAML or JSON file of the form
+// {"interval": "6h", "jitter": "10m", "projects": {"github.com/acme/api": {"interval": "1h"}}}.
+func LoadSchedule(path string) (Schedule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	// YAML is a superset of JSON, so this handles both formats
+	var schedule Schedule
+	if err := yaml.UnmarshalStrict(content, &schedule); err != nil {
+		return Schedule{}, err
+	}
+
+	return schedule, nil
+}
+
+func (s Schedule) interval(project string) time.Duration {
+	if override, exists := s.Projects[project]; exists {
+		if override.Disabled {
+			return 0
+		}
+
+		if override.Interval != 0 {
+			return time.Duration(override.Interval)
+		}
+	}
+
+	return time.Duration(s.Interval)
+}
+
+func (s Schedule) enabled() bool {
+	if s.Interval != 0 {
+		return true
+	}
+
+	for _, override := range s.Projects {
+		if !override.Disabled && override.Interval != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scheduler queues incremental analyses of all analyzed projects according
+// to its schedule.
+type scheduler struct {
+	db       *database.DB
+	jobs     *JobManager
+	schedule Schedule
+
+	next     map[string]time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newScheduler(db *database.DB, jobs *JobManager, schedule Schedule) *scheduler {
+	sc := &scheduler{
+		db:       db,
+		jobs:     jobs,
+		schedule: schedule,
+		next:     make(map[string]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go sc.run()
+
+	return sc
+}
+
+func (sc *scheduler) run() {
+	defer close(sc.done)
+
+	lastRuns, err := sc.db.GetLastRunTimes()
+	if err != nil {
+		log.Err(err).Msg("Failed to read last analysis runs, scheduling all projects from now")
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-sc.stop:
+			return
+		case now := <-timer.C:
+			timer.Reset(sc.tick(now, lastRuns))
+		}
+	}
+}
+
+// tick queues the analyses that are due at now and returns how long to wait
+// until the next one is due.
+func (sc *scheduler) tick(now time.Time, lastRuns map[string]time.Time) time.Duration {
+	projects, err := sc.db.GetProjects()
+	if err != nil {
+		log.Err(err).Msg("Failed to list projects for scheduled analyses")
+		return MaxScheduleWait
+	}
+
+	// Forget deleted projects, so they are scheduled afresh, if they are
+	// analyzed again
+	analyzed := make(map[string]bool, len(projects))
+	for _, project := range projects {
+		analyzed[project] = true
+	}
+	for project := range sc.next {
+		if !analyzed[project] {
+			delete(sc.next, project)
+		}
+	}
+	for project := range lastRuns {
+		if !analyzed[project] {
+			delete(lastRuns, project)
+		}
+	}
+
+	wait := MaxScheduleWait
+	for _, project := range projects {
+		interval := sc.schedule.interval(project)
+		if interval == 0 {
+			continue
+		}
+
+		next, scheduled := sc.next[project]
+		if !scheduled {
+			last, exists := lastRuns[project]
+			if !exists {
+				last = now
+			}
+			next = sc.nextRun(last, interval)
+		}
+
+		if !now.Before(next) {
+			job, created, err := sc.jobs.Submit(project, false, TriggerSchedule)
+			next = sc.nextRun(now, interval)
+			if err != nil {
+				log.Err(err).Str("project", project).Msg("Failed to queue scheduled analysis")
+				next = now.Add(min(ScheduleRetry, interval))
+			} else if created {
+				log.Info().Str("project", project).Str("job", job.ID).Msg("Queued scheduled analysis")
+			}
+		}
+
+		sc.next[project] = next
+		wait = min(wait, next.Sub(now))
+	}
+
+	return max(wait, time.Second)
+}
+
+func (sc *scheduler) nextRun(last time.Time, interval time.Duration) time.Time {
+	next := last.Add(interval)
+	if jitter := time.Duration(sc.schedule.Jitter); jitter > 0 {
+		next = next.Add(rand.N(jitter))
+	}
+
+	return next
+}
+
+// Stop stops scheduling new analyses and waits for the scheduler to return.
+func (sc *scheduler) Stop() {
+	sc.stopOnce.Do(func() {
+		close(sc.stop)
+	})
+	<-sc.done
+}
+
+func (s *Server) projectRuns(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", 50, 500)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	project := projectName(r)
+	if _, err := s.GetProject(project); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	runs, err := s.GetRuns(project, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, runs)
+}
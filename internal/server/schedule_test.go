@@ -0,0 +1,80 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerForgetsDeletedProjects(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p", "github.com/o/q")
+
+	now := time.Now()
+	sc := &scheduler{
+		db:       s.DB,
+		jobs:     s.jobs,
+		schedule: Schedule{Interval: Interval(time.Hour)},
+		next:     make(map[string]time.Time),
+	}
+	lastRuns := map[string]time.Time{"github.com/o/q": now.Add(-time.Minute)}
+
+	sc.tick(now, lastRuns)
+	if len(sc.next) != 2 || len(lastRuns) != 1 {
+		t.Fatalf("got schedule %v and last runs %v, want both projects scheduled", sc.next, lastRuns)
+	}
+
+	if err := s.DeleteProject("github.com/o/q"); err != nil {
+		t.Fatal(err)
+	}
+
+	sc.tick(now.Add(time.Minute), lastRuns)
+	if _, exists := sc.next["github.com/o/q"]; exists || len(sc.next) != 1 {
+		t.Errorf("got schedule %v, want only github.com/o/p", sc.next)
+	}
+	if len(lastRuns) != 0 {
+		t.Errorf("got last runs %v, want none", lastRuns)
+	}
+
+	if jobs := s.jobs.List(); len(jobs) != 0 {
+		t.Errorf("got %d jobs, want none before the interval passed", len(jobs))
+	}
+}
+
+func TestSchedulerRetriesFailedSubmits(t *testing.T) {
+	queued := MaxQueuedJobs
+	MaxQueuedJobs = 1
+	t.Cleanup(func() { MaxQueuedJobs = queued })
+
+	s := newTestServer(t, "github.com/o/p", "github.com/o/q")
+
+	now := time.Now()
+	sc := &scheduler{
+		db:       s.DB,
+		jobs:     s.jobs,
+		schedule: Schedule{Interval: Interval(time.Hour)},
+		next:     make(map[string]time.Time),
+	}
+	lastRuns := map[string]time.Time{
+		"github.com/o/p": now.Add(-2 * time.Hour),
+		"github.com/o/q": now.Add(-2 * time.Hour),
+	}
+
+	// Only one of both analyses fits into the queue
+	wait := sc.tick(now, lastRuns)
+	if wait != ScheduleRetry {
+		t.Errorf("got wait %s, want %s", wait, ScheduleRetry)
+	}
+
+	var retried int
+	for project, next := range sc.next {
+		switch next.Sub(now) {
+		case ScheduleRetry:
+			retried++
+		case time.Hour:
+		default:
+			t.Errorf("got next run of %s in %s, want in %s or %s", project, next.Sub(now), ScheduleRetry, time.Hour)
+		}
+	}
+	if retried != 1 {
+		t.Errorf("got %d retried projects, want 1: %v", retried, sc.next)
+	}
+}
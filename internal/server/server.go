@@ -1,52 +1,287 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"regexp"
 	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
 
 	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/auth"
 	"github.com/tim-hilt/codescene/internal/database"
+	"github.com/tim-hilt/codescene/internal/git"
+)
+
+var (
+	// AnalysisWorkers is the number of analyses that run concurrently.
+	AnalysisWorkers = 2
+
+	// AllowedOrigins are the origins allowed to make cross-origin requests.
+	// "*" allows all origins, but only listed origins may send credentials.
+	// CORS is disabled, if empty.
+	AllowedOrigins []string
 )
 
 type Server struct {
 	*database.DB
+	mux       *http.ServeMux
+	jobs      *JobManager
+	reviews   *reviewer
+	scheduler *scheduler
+	metrics   http.Handler
+}
+
+func New(db *database.DB) *Server {
+	s := &Server{
+		DB:      db,
+		mux:     http.NewServeMux(),
+		jobs:    NewJobManager(db, AnalysisWorkers),
+		reviews: newReviewer(db, ReviewWorkers),
+		metrics: newMetricsHandler(db),
+	}
+	s.routes()
+
+	if AnalysisSchedule.enabled() {
+		s.scheduler = newScheduler(db, s.jobs, AnalysisSchedule)
+	}
+
+	return s
+}
+
+// Close cancels all running analyses and removes the repositories fetched
+// for reviews.
+func (s *Server) Close() {
+	s.stopScheduler()
+	s.jobs.Close()
+	s.reviews.close()
+}
+
+// Shutdown rejects new analyses and waits for the running ones to finish.
+// They are canceled, if ctx is done first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopScheduler()
+	return s.jobs.Shutdown(ctx)
+}
+
+func (s *Server) stopScheduler() {
+	if s.scheduler != nil {
+		s.scheduler.Stop()
+	}
+}
+
+func (s *Server) routes() {
+	// Project names have the form <host>/<owner>/<name>
+	const project = "/api/v1/projects/{host}/{owner}/{name}"
+
+	s.mux.HandleFunc("GET /api/v1/me", s.me)
+	s.mux.HandleFunc("GET /api/v1/analyze", require(auth.RoleAnalyst, s.analyze))
+	s.mux.HandleFunc("GET /api/v1/jobs", require(auth.RoleViewer, s.listJobs))
+	s.mux.HandleFunc("POST /api/v1/jobs", require(auth.RoleAnalyst, s.createJob))
+	s.mux.HandleFunc("GET /api/v1/jobs/{id}", require(auth.RoleViewer, s.getJob))
+	s.mux.HandleFunc("DELETE /api/v1/jobs/{id}", require(auth.RoleAnalyst, s.cancelJob))
+	s.mux.HandleFunc("GET /api/v1/query", require(auth.RoleViewer, s.query))
+	s.mux.HandleFunc("POST /api/v1/query", require(auth.RoleViewer, s.query))
+	s.mux.HandleFunc("POST /api/v1/import", require(auth.RoleAnalyst, s.importDump))
+	s.mux.HandleFunc("GET /api/v1/projects", require(auth.RoleViewer, s.projects))
+	s.mux.HandleFunc("GET "+project, require(auth.RoleViewer, handleProject(s.GetProject)))
+	s.mux.HandleFunc("DELETE "+project, require(auth.RoleAdmin, s.deleteProject))
+	s.mux.HandleFunc("GET "+project+"/metadata", require(auth.RoleViewer, s.projectMetadata))
+	s.mux.HandleFunc("GET "+project+"/commits", require(auth.RoleViewer, s.projectCommits))
+	s.mux.HandleFunc("GET "+project+"/commits/{hash}/files", require(auth.RoleViewer, s.projectFiles))
+	s.mux.HandleFunc("GET "+project+"/contributors", require(auth.RoleViewer, handleProject(s.GetContributors)))
+	s.mux.HandleFunc("GET "+project+"/hotspots", require(auth.RoleViewer, handleProject(s.GetHotspots)))
+	s.mux.HandleFunc("GET "+project+"/defects", require(auth.RoleViewer, handleProject(s.GetDefectDensity)))
+	s.mux.HandleFunc("GET "+project+"/issues", require(auth.RoleViewer, handleProject(s.GetIssues)))
+	s.mux.HandleFunc("GET "+project+"/unplanned", require(auth.RoleViewer, handleProject(s.GetUnplannedWork)))
+	s.mux.HandleFunc("GET "+project+"/components", require(auth.RoleViewer, handleProject(s.GetComponents)))
+	s.mux.HandleFunc("GET "+project+"/components/trends", require(auth.RoleViewer, handleProject(s.GetComponentTrends)))
+	s.mux.HandleFunc("GET "+project+"/components/coupling", require(auth.RoleViewer, handleProject(s.GetComponentCoupling)))
+	s.mux.HandleFunc("GET "+project+"/components/teams", require(auth.RoleViewer, handleProject(s.GetComponentTeamOwnership)))
+	s.mux.HandleFunc("GET "+project+"/teams", require(auth.RoleViewer, handleProject(s.GetTeamOwnership)))
+	s.mux.HandleFunc("GET "+project+"/teams/coupling", require(auth.RoleViewer, handleProject(s.GetTeamCoupling)))
+	s.mux.HandleFunc("GET "+project+"/activity", require(auth.RoleViewer, s.projectActivity))
+	s.mux.HandleFunc("GET "+project+"/working-hours", require(auth.RoleViewer, s.projectWorkingHours))
+	s.mux.HandleFunc("GET "+project+"/runs", require(auth.RoleViewer, s.projectRuns))
+	s.mux.HandleFunc("GET "+project+"/export", require(auth.RoleViewer, s.exportProject))
+	s.mux.HandleFunc("GET "+project+"/findings", require(auth.RoleViewer, s.projectFindings))
+	s.mux.HandleFunc("GET "+project+"/review", require(auth.RoleViewer, s.projectReview))
+	s.mux.HandleFunc("GET /metrics", require(auth.RoleViewer, s.serveMetrics))
+	s.mux.HandleFunc("POST /hooks/github", s.githubHook)
+	s.mux.HandleFunc("POST /hooks/gitlab", s.gitlabHook)
+	s.mux.HandleFunc("POST /hooks/generic", s.genericHook)
+	s.mux.HandleFunc("/api/", func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, errNotFound)
+	})
+	s.mux.HandleFunc("/", s.frontend)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	method := r.Method
-	reMetadata := regexp.MustCompile(`^/projects/(.*)/metadata$`)
-	project := reMetadata.FindStringSubmatch(path)
+	if origin := r.Header.Get("Origin"); origin != "" {
+		// Whether an origin is allowed, and how, depends on the origin
+		w.Header().Add("Vary", "Origin")
+
+		if allowed, listed := allowedOrigin(origin); allowed {
+			// Only explicitly listed origins may send credentials, so that
+			// "*" doesn't expose authenticated responses to any website
+			if listed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+	}
+
+	r, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	instrument(w, r, s.mux)
+}
+
+// allowedOrigin reports whether origin may make cross-origin requests and
+// whether it is listed explicitly rather than allowed by "*".
+func allowedOrigin(origin string) (allowed, listed bool) {
+	for _, o := range AllowedOrigins {
+		if o == origin {
+			return true, true
+		}
+		if o == "*" {
+			allowed = true
+		}
+	}
+
+	return allowed, false
+}
+
+var errNotFound = errors.New("not found")
+
+// badRequestError marks errors caused by invalid request parameters.
+type badRequestError struct {
+	error
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func statusCode(err error) int {
+	var badRequest badRequestError
+
 	switch {
-	case path == "/analyze" && method == http.MethodGet:
-		s.analyze(w, r)
-	case path == "/projects" && method == http.MethodGet:
-		s.projects(w, r)
-	case len(project) > 1 && method == http.MethodGet:
-		s.projectMetadata(w, r, project[1])
+	case errors.Is(err, errImportTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &badRequest),
+		errors.Is(err, database.ErrGranularity),
+		errors.Is(err, database.ErrTimezone),
+		errors.Is(err, database.ErrInvalidQuery),
+		errors.Is(err, database.ErrExportFormat),
+		errors.Is(err, database.ErrNoDump),
+		errors.Is(err, internal.ErrRepoFormat),
+		errors.Is(err, internal.ErrNestedRepo):
+		return http.StatusBadRequest
+	case errors.Is(err, errNotFound),
+		errors.Is(err, ErrJobNotFound),
+		errors.Is(err, database.ErrProjectNotFound),
+		errors.Is(err, database.ErrCommitNotFound),
+		errors.Is(err, database.ErrNoComponents),
+		errors.Is(err, git.ErrRevisionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrNoCredentials),
+		errors.Is(err, auth.ErrInvalidCredentials),
+		errors.Is(err, errInvalidSignature):
+		return http.StatusUnauthorized
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrJobFinished),
+		errors.Is(err, database.ErrImportConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrQueueFull):
+		return http.StatusServiceUnavailable
 	default:
-		http.NotFound(w, r)
+		return http.StatusInternalServerError
 	}
 }
 
-func (s *Server) analyze(w http.ResponseWriter, r *http.Request) {
-	// TODO: Remove this once, the frontend is embedded
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.WriteHeader(http.StatusOK)
+func writeError(w http.ResponseWriter, err error) {
+	status := statusCode(err)
+	if status == http.StatusInternalServerError {
+		log.Err(err).Msg("Failed to handle request")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(errorResponse{errorBody{status, err.Error()}}); err != nil {
+		log.Err(err).Msg("Failed to write error response")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Err(err).Msg("Failed to write response")
+	}
+}
+
+func projectName(r *http.Request) string {
+	return r.PathValue("host") + "/" + r.PathValue("owner") + "/" + r.PathValue("name")
+}
+
+// handleProject serves the result of get for the project in the request path.
+func handleProject[T any](get func(project string) (T, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, err := get(projectName(r))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, v)
+	}
+}
+
+func queryInt(r *http.Request, key string, fallback, max int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return fallback, nil
+	}
 
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, badRequestError{fmt.Errorf("%s must be a non-negative integer", key)}
+	}
+
+	return min(i, max), nil
+}
+
+// analyze queues an analysis of the repository and streams its progress as
+// server-sent events. "progress" events carry the progress of the current
+// phase, the final event is "succeeded", "failed" or "canceled" with the job.
+// Closing the stream doesn't cancel the analysis.
+func (s *Server) analyze(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get("repo")
 	if repo == "" {
-		w.Write([]byte("data: repository not provided\n\n"))
-		w.(http.Flusher).Flush()
+		writeError(w, badRequestError{errors.New("repository not provided")})
 		return
 	}
 
@@ -56,60 +291,72 @@ func (s *Server) analyze(w http.ResponseWriter, r *http.Request) {
 		force = true
 	}
 
-	err := internal.Analyze(s.DB, repo, force, func(c, t int) {
-		w.Write([]byte("id: " + strconv.Itoa(c) + "\n"))
-		w.Write([]byte(fmt.Sprintf("data: {\"current\":%d,\"total\":%d}\n\n", c, t)))
-		w.(http.Flusher).Flush()
-	})
+	repo, err := authorizeRepo(r, repo)
 	if err != nil {
-		w.Write([]byte("data: " + err.Error() + "\n\n"))
-		w.(http.Flusher).Flush()
+		writeError(w, err)
 		return
 	}
-}
-
-func (s *Server) projects(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-	w.Header().Set("Content-Type", "application/json")
-
-	projects, err := s.GetProjects()
+	job, _, err := s.jobs.Submit(repo, force, TriggerAPI)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(projects); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	updates, stop, err := s.jobs.Watch(job.ID)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-}
+	defer stop()
 
-func (s *Server) projectMetadata(w http.ResponseWriter, _ *http.Request, project string) {
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
 
-	w.Header().Set("Content-Type", "application/json")
+	// Analyses may take longer than the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Err(err).Msg("Failed to disable write deadline")
+	}
 
-	metadata, err := s.GetProjectMetadata(project)
+	var id int
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case job, ok := <-updates:
+			if !ok {
+				return
+			}
 
-	if err == database.ErrProjectNotFound {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+			// Jobs start running before the analysis reports its first phase
+			if job.Status == JobRunning && job.Progress.Phase == "" {
+				continue
+			}
+
+			id++
+			switch job.Status {
+			case JobQueued:
+				writeEvent(w, id, "queued", job)
+			case JobRunning:
+				writeEvent(w, id, "progress", job.Progress)
+			default:
+				writeEvent(w, id, string(job.Status), job)
+			}
+			w.(http.Flusher).Flush()
+		}
 	}
+}
 
+func writeEvent(w http.ResponseWriter, id int, event string, v any) {
+	data, err := json.Marshal(v)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Err(err).Msg("Failed to encode event")
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(metadata); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data); err != nil {
+		log.Err(err).Msg("Failed to write event")
 	}
 }
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tim-hilt/codescene/internal"
+	"github.com/tim-hilt/codescene/internal/database"
+)
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     []string
+		origin      string
+		method      string
+		allowOrigin string
+		credentials string
+		status      int
+	}{
+		{"disabled", nil, "https://a.example", http.MethodOptions, "", "", http.StatusOK},
+		{"listed preflight", []string{"https://a.example"}, "https://a.example", http.MethodOptions, "https://a.example", "true", http.StatusNoContent},
+		{"listed request", []string{"https://a.example"}, "https://a.example", http.MethodGet, "https://a.example", "true", http.StatusOK},
+		{"unlisted", []string{"https://a.example"}, "https://b.example", http.MethodOptions, "", "", http.StatusOK},
+		{"wildcard preflight", []string{"*"}, "https://b.example", http.MethodOptions, "*", "", http.StatusNoContent},
+		{"wildcard request", []string{"*"}, "https://b.example", http.MethodGet, "*", "", http.StatusOK},
+		{"listed with wildcard", []string{"*", "https://a.example"}, "https://a.example", http.MethodGet, "https://a.example", "true", http.StatusOK},
+		{"unlisted with wildcard", []string{"https://a.example", "*"}, "https://b.example", http.MethodGet, "*", "", http.StatusOK},
+		{"no origin", []string{"*"}, "", http.MethodGet, "", "", http.StatusOK},
+	}
+
+	s := &Server{mux: http.NewServeMux()}
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			AllowedOrigins = test.allowed
+			t.Cleanup(func() { AllowedOrigins = nil })
+
+			r := httptest.NewRequest(test.method, "/", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d", w.Code, test.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, test.allowOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != test.credentials {
+				t.Errorf("got Access-Control-Allow-Credentials %q, want %q", got, test.credentials)
+			}
+		})
+	}
+}
+
+func TestAPI(t *testing.T) {
+	s := newTestServer(t, "github.com/o/p", "github.com/o/q")
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{http.MethodGet, "/api/v1/projects", http.StatusOK, `"name":"github.com/o/q"`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/p", http.StatusOK, `"commits":1`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/r", http.StatusNotFound, `"message":"project not found"`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/p/commits?limit=1", http.StatusOK, `"hash":"github.com/o/p"`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/p/commits?author=Bob", http.StatusOK, `"commits":[],"total":0`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/p/commits?limit=-1", http.StatusBadRequest, `"message":"limit must be a non-negative integer"`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/p/commits?since=yesterday", http.StatusBadRequest, `"status":400`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/r/commits", http.StatusNotFound, `"message":"project not found"`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/p/commits/unknown/files", http.StatusNotFound, `"message":"commit not found"`},
+		{http.MethodGet, "/api/v1/projects/github.com/o/p/contributors", http.StatusOK, `"contributor":"Alice"`},
+		{http.MethodGet, "/api/v1/unknown", http.StatusNotFound, `"message":"not found"`},
+		{http.MethodPut, "/api/v1/projects", http.StatusNotFound, `"status":404`},
+		{http.MethodDelete, "/api/v1/projects/github.com/o/p", http.StatusNoContent, ""},
+		{http.MethodGet, "/api/v1/projects/github.com/o/p", http.StatusNotFound, `"message":"project not found"`},
+		{http.MethodDelete, "/api/v1/projects/github.com/o/p", http.StatusNotFound, `"message":"project not found"`},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d: %s", test.method, test.path, w.Code, test.status, w.Body)
+		}
+		if !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s %s: got body %s, want it to contain %s", test.method, test.path, w.Body, test.body)
+		}
+		if w.Code >= 400 {
+			var response errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Error.Status != w.Code {
+				t.Errorf("%s %s: got error response %s, want a JSON error object", test.method, test.path, w.Body)
+			}
+		}
+	}
+}
+
+func TestAnalyzeEvents(t *testing.T) {
+	repo := newGitRepo(t)
+	repo.commit("main.go", "package main\n")
+	repo.commit("util.go", "package main\n")
+
+	t.Chdir(t.TempDir())
+	db, err := database.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	workers := AnalysisWorkers
+	AnalysisWorkers = 1
+	s := New(db)
+	AnalysisWorkers = workers
+	t.Cleanup(s.Close)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/analyze?repo=github.com/o/p", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d and content type %q, want an event stream", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	type event struct {
+		id         int
+		name, data string
+	}
+	var events []event
+	var e event
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		field, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch field {
+		case "id":
+			e.id, _ = strconv.Atoi(value)
+		case "event":
+			e.name = value
+		case "data":
+			e.data = value
+		case "":
+			events = append(events, e)
+			e = event{}
+		}
+	}
+
+	if len(events) < 2 {
+		t.Fatalf("got events %+v, want progress and a final event", events)
+	}
+	for i, e := range events {
+		if e.id != i+1 {
+			t.Errorf("got id %d for event %d, want %d", e.id, i, i+1)
+		}
+
+		switch {
+		case e.name == "progress":
+			var p internal.Progress
+			if err := json.Unmarshal([]byte(e.data), &p); err != nil || p.Phase == "" {
+				t.Errorf("got progress %s, want the phase of the analysis", e.data)
+			}
+		case i == len(events)-1:
+			var job Job
+			if err := json.Unmarshal([]byte(e.data), &job); err != nil || e.name != "succeeded" || job.Status != JobSucceeded {
+				t.Errorf("got final event %s with %s, want the succeeded job", e.name, e.data)
+			}
+		case e.name != "queued":
+			t.Errorf("got event %s before the end, want queued or progress", e.name)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/tim-hilt/codescene/web"
+)
+
+// Frontend is the file system the frontend is served from.
+var Frontend = web.Dist
+
+// frontend serves the files of Frontend. Paths that don't match a file are
+// routes of the single-page app and get index.html, so client-side routing
+// can handle them.
+func (s *Server) frontend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	if info, err := fs.Stat(Frontend, name); err == nil && !info.IsDir() {
+		if strings.HasPrefix(name, "assets/") {
+			// Vite adds content hashes to the names of assets
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else if name == "index.html" {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		http.ServeFileFS(w, r, Frontend, name)
+		return
+	}
+
+	if strings.HasPrefix(name, "assets/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	index, err := fs.ReadFile(Frontend, "index.html")
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "frontend not built, run \"bun run build\" in web/", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(index)
+}
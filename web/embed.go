@@ -0,0 +1,14 @@
+// Package web provides the built frontend. Run "bun run build" in this
+// directory before building the server to include it.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// Dist contains the files of the built frontend.
+var Dist, _ = fs.Sub(dist, "dist")
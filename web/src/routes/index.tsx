@@ -1,15 +1,19 @@
 import { Link, createFileRoute } from "@tanstack/react-router";
 
+type Project = {
+	name: string;
+};
+
 export const Route = createFileRoute("/")({
 	component: App,
 	loader: async () => {
-		const response = await fetch("http://localhost:8000/projects");
+		const response = await fetch("/api/v1/projects");
 		return response.json();
 	},
 });
 
 function App() {
-	const data: Array<string> = Route.useLoaderData();
+	const data: Array<Project> = Route.useLoaderData();
 	return (
 		<>
 			<div className="mb-8 flex items-center justify-between">
@@ -23,13 +27,13 @@ function App() {
 			</div>
 			<div className="grid grid-cols-4 gap-4">
 				{data.map((d) => {
-					const project = d.split("/").pop() || d;
+					const project = d.name.split("/").pop() || d.name;
 					return (
 						<Link
 							className="bg-white shadow-lg rounded-lg h-[25vw] flex items-center justify-center text-2xl"
 							key={project}
 							to="/projects/$"
-							params={{ _splat: d }}
+							params={{ _splat: d.name }}
 						>
 							{project}
 						</Link>
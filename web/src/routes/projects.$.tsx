@@ -32,7 +32,7 @@ export const Route = createFileRoute("/projects/$")({
 	// TODO: Possibly, the whole loader thing is not the correct way to go and I should use different suspense boundary restricted components with react query and useSuspenseQueries?
 	loader: async ({ params }): Promise<ProjectMetadata> => {
 		const response = await fetch(
-			`http://localhost:8000/projects/${params._splat}/metadata`,
+			`/api/v1/projects/${params._splat}/metadata`,
 		);
 		return response.json(); // TODO: Handle not found error correctly
 	},
@@ -12,6 +12,12 @@ export default defineConfig({
 		viteReact(),
 		tailwindcss(),
 	],
+	server: {
+		// The API is served by cmd/web during development
+		proxy: {
+			"/api": "http://localhost:8000",
+		},
+	},
 	test: {
 		globals: true,
 		environment: "jsdom",